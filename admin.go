@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// listenAdmin opens the listener of the admin server: --admin-socket, only
+// accessible to the user of the daemon, or --admin-port on the loopback
+// interface
+func (s *SpiffeJWT) listenAdmin() (net.Listener, error) {
+	if s.AdminSocket == "" {
+		lis, err := net.Listen("tcp", "127.0.0.1:"+s.AdminPort)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on --admin-port: %w", err)
+		}
+		if s.adminToken == "" {
+			logrus.Warnf("Admin server on %s does not require a token, any process sharing the network namespace may pause refreshes, set --admin-token-file or --admin-socket", lis.Addr())
+		}
+		return lis, nil
+	}
+
+	if err := removeStaleSocket(s.AdminSocket); err != nil {
+		return nil, fmt.Errorf("invalid --admin-socket: %w", err)
+	}
+	lis, err := net.Listen("unix", s.AdminSocket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on --admin-socket: %w", err)
+	}
+	if err := os.Chmod(s.AdminSocket, 0o600); err != nil {
+		lis.Close()
+		return nil, fmt.Errorf("failed to set the mode of the admin socket: %w", err)
+	}
+	return lis, nil
+}
+
+// startAdminServer runs the admin HTTP server of --enable-admin-api on lis
+// until ctx is done, then shuts it down gracefully
+func (s *SpiffeJWT) startAdminServer(ctx context.Context, lis net.Listener) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /refresh", s.optionalAdmin(s.handleRefresh))
+	mux.HandleFunc("GET /status", s.optionalAdmin(s.handleStatus))
+	mux.HandleFunc("POST /pause", s.optionalAdmin(s.handlePause))
+	mux.HandleFunc("POST /resume", s.optionalAdmin(s.handleResume))
+	if s.EnableDebugDump {
+		mux.HandleFunc("GET /debug/dump", s.optionalAdmin(s.handleDebugDump))
+	}
+
+	// Event streams are long-lived, they are exempt from --health-timeout
+	root := http.NewServeMux()
+	root.Handle("/", withTimeout(mux, s.HealthTimeout))
+	root.HandleFunc("GET /events", s.optionalAdmin(s.handleEvents))
+
+	server := &http.Server{
+		Handler:      root,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	server.RegisterOnShutdown(s.events.close)
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logrus.WithError(err).Warn("Admin server did not shut down gracefully")
+		}
+	}()
+
+	logrus.Infof("Starting admin server on %s", lis.Addr())
+	if err := server.Serve(lis); err != nil && err != http.ErrServerClosed {
+		logrus.WithError(err).Fatal("Admin server failed")
+	}
+}
+
+// optionalAdmin requires the admin token on the admin server only when
+// --admin-token-file is set
+func (s *SpiffeJWT) optionalAdmin(next http.HandlerFunc) http.HandlerFunc {
+	if s.adminToken == "" {
+		return next
+	}
+	return s.requireAdmin(next)
+}
+
+// handleRefresh forces an immediate refresh of the JWT SVID, subject to the
+// forced refresh cooldown
+func (s *SpiffeJWT) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	if s.paused() {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "refreshes are paused"})
+		return
+	}
+	s.requestRefresh("POST /refresh")
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "refresh requested"})
+}
+
+// handlePause suspends the refreshes of every token until resumed
+func (s *SpiffeJWT) handlePause(w http.ResponseWriter, r *http.Request) {
+	if atomic.CompareAndSwapInt32(&s.pausedFlag, 0, 1) {
+		logrus.Warn("Refreshes paused by POST /pause, tokens are no longer refreshed")
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "paused"})
+}
+
+// handleResume restarts the refreshes and catches up on the primary token
+// right away
+func (s *SpiffeJWT) handleResume(w http.ResponseWriter, r *http.Request) {
+	if atomic.CompareAndSwapInt32(&s.pausedFlag, 1, 0) {
+		logrus.Info("Refreshes resumed by POST /resume")
+		s.requestRefresh("POST /resume")
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "running"})
+}
+
+// paused reports whether refreshes are paused through the admin server
+func (s *SpiffeJWT) paused() bool {
+	return atomic.LoadInt32(&s.pausedFlag) == 1
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// TestListenAdmin checks that the admin server is only reachable locally:
+// on the loopback interface or on a socket of the user of the daemon
+func TestListenAdmin(t *testing.T) {
+	t.Run("loopback", func(t *testing.T) {
+		s := &SpiffeJWT{AdminPort: "0"}
+		lis, err := s.listenAdmin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer lis.Close()
+		if addr := lis.Addr().(*net.TCPAddr); !addr.IP.IsLoopback() {
+			t.Errorf("admin server listens on %s", addr)
+		}
+	})
+
+	t.Run("socket", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "admin.sock")
+		s := &SpiffeJWT{AdminSocket: path}
+		lis, err := s.listenAdmin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer lis.Close()
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if runtime.GOOS != "windows" && info.Mode().Perm() != 0o600 {
+			t.Errorf("admin socket has mode %s, want 0600", info.Mode().Perm())
+		}
+	})
+
+	t.Run("not a socket", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "admin")
+		if err := os.WriteFile(path, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		s := &SpiffeJWT{AdminSocket: path}
+		if lis, err := s.listenAdmin(); err == nil {
+			lis.Close()
+			t.Fatal("listenAdmin replaced a regular file")
+		}
+	})
+}
+
+// TestAdminServerToken checks that the admin token is enforced once
+// --admin-token-file is set
+func TestAdminServerToken(t *testing.T) {
+	s := &SpiffeJWT{AdminSocket: filepath.Join(t.TempDir(), "admin.sock"), adminToken: "secret", HealthTimeout: time.Second}
+	lis, err := s.listenAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.startAdminServer(ctx, lis)
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", s.AdminSocket)
+		},
+	}}
+	for _, tc := range []struct {
+		token string
+		want  int
+	}{
+		{"", http.StatusUnauthorized},
+		{"wrong", http.StatusUnauthorized},
+		{"secret", http.StatusOK},
+	} {
+		req, _ := http.NewRequest(http.MethodPost, "http://admin/pause", nil)
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("POST /pause with token %q = %d, want %d", tc.token, resp.StatusCode, tc.want)
+		}
+	}
+	if !s.paused() {
+		t.Error("refreshes are not paused")
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+)
+
+// adoptExistingToken returns the JWT SVID left in --jwt-file-name by the
+// previous process with --adopt-existing-token, if it passes validation for
+// the configured audience and has at least --adopt-existing-min-ttl left.
+// Adopting it spares an upgrade both a gap for consumers and a fetch. It
+// returns nil when there is nothing to adopt.
+func (s *SpiffeJWT) adoptExistingToken() *jwtsvid.SVID {
+	info, err := os.Stat(s.JWTFileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		logrus.Infof("No existing JWT SVID in %s to adopt", s.JWTFileName)
+		return nil
+	}
+	token, err := readTokenFile(s.JWTFileName, s.encryptionKey)
+	if err != nil {
+		logrus.WithError(err).Warn("Not adopting the existing JWT SVID")
+		return nil
+	}
+
+	// The signature was verified by the process that wrote the file, the
+	// audience is checked below according to --audience-validation-mode
+	jwt, err := jwtsvid.ParseInsecure(token, nil)
+	if err != nil {
+		logrus.WithError(err).Warn("Not adopting the existing JWT SVID")
+		return nil
+	}
+	if err := s.validateJWTSVID(jwt, s.JWTAudience); err != nil {
+		logrus.WithError(err).WithFields(svidFields(jwt)).Warn("Not adopting the existing JWT SVID")
+		return nil
+	}
+	if remaining := time.Until(jwt.Expiry); remaining < s.AdoptMinTTL {
+		logrus.WithFields(svidFields(jwt)).Infof("Not adopting the existing JWT SVID, it expires in %s, less than --adopt-existing-min-ttl", remaining.Round(time.Second))
+		return nil
+	}
+
+	s.mu.Lock()
+	s.current, s.lastRefresh = jwt, info.ModTime()
+	s.mu.Unlock()
+	s.recordExpiry(jwt)
+	logrus.WithFields(svidFields(jwt)).Infof("Adopted the existing JWT SVID in %s, it expires in %s", s.JWTFileName, time.Until(jwt.Expiry).Round(time.Second))
+	return jwt
+}
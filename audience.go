@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// trimAudience trims the whitespace around a configured audience, typically
+// left by a templated value, and rejects the audience if nothing is left
+func trimAudience(aud, source string) (string, error) {
+	trimmed := strings.TrimSpace(aud)
+	if trimmed == "" {
+		return "", fmt.Errorf("%s is empty or only whitespace", source)
+	}
+	if trimmed != aud {
+		logrus.Warnf("Trimmed the whitespace around audience %q of %s", trimmed, source)
+	}
+	return trimmed, nil
+}
+
+// validateAudiences trims the configured audiences and rejects empty ones
+// before anything is fetched, as the agent would fail on them with a less
+// helpful error
+func (s *SpiffeJWT) validateAudiences() error {
+	var err error
+	if len(s.AudienceRoundRobin) == 0 && s.AudienceFromJWT == "" {
+		if s.JWTAudience, err = trimAudience(s.JWTAudience, "--jwt-audience"); err != nil {
+			return err
+		}
+	}
+	for i, aud := range s.AudienceRoundRobin {
+		if s.AudienceRoundRobin[i], err = trimAudience(aud, fmt.Sprintf("entry %d of --audience-round-robin", i+1)); err != nil {
+			return err
+		}
+	}
+	for i, t := range s.Tokens {
+		if s.Tokens[i].Audience, err = trimAudience(t.Audience, fmt.Sprintf("--token %s", t.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// TestValidateAudiences checks that configured audiences are trimmed with a
+// warning and that empty ones are rejected
+func TestValidateAudiences(t *testing.T) {
+	hook := test.NewGlobal()
+	defer hook.Reset()
+
+	var spec tokenSpec
+	if err := spec.UnmarshalText([]byte("audience= extra ,file=/run/extra")); err != nil {
+		t.Fatal(err)
+	}
+	s := &SpiffeJWT{JWTAudience: " primary\n", Tokens: []tokenSpec{spec}}
+	if err := s.validateAudiences(); err != nil {
+		t.Fatal(err)
+	}
+	if s.JWTAudience != "primary" || s.Tokens[0].Audience != "extra" || s.Tokens[0].Name != "extra" {
+		t.Errorf("audiences not trimmed: %q, %+v", s.JWTAudience, s.Tokens[0])
+	}
+	var warnings []string
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == logrus.WarnLevel {
+			warnings = append(warnings, entry.Message)
+		}
+	}
+	if !slices.ContainsFunc(warnings, func(m string) bool { return strings.Contains(m, "--jwt-audience") }) ||
+		!slices.ContainsFunc(warnings, func(m string) bool { return strings.Contains(m, "--token extra") }) {
+		t.Errorf("missing trim warnings, got %q", warnings)
+	}
+
+	for _, s := range []*SpiffeJWT{
+		{JWTAudience: "  "},
+		{AudienceRoundRobin: []string{"a", " "}},
+	} {
+		if err := s.validateAudiences(); err == nil {
+			t.Errorf("accepted an empty audience in %+v", s)
+		}
+	}
+	if err := spec.UnmarshalText([]byte("audience= ,file=/run/extra")); err == nil {
+		t.Error("accepted a --token with a whitespace audience")
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+)
+
+// tokenAuditRecord is the audit log entry of a written token. It identifies
+// the token without containing it.
+type tokenAuditRecord struct {
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	SpiffeID string    `json:"spiffe_id"`
+	Audience []string  `json:"audience"`
+	JTI      string    `json:"jti,omitempty"`
+	Expiry   time.Time `json:"expiry"`
+}
+
+// auditLog is an append-only JSON lines file. Every record is synced to disk
+// before append returns. The file is never truncated: once it would exceed
+// maxSize it is renamed to <path>.1, replacing the previous generation, and
+// a new file is started.
+type auditLog struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+}
+
+// newTokenAuditRecord describes a written JWT SVID
+func newTokenAuditRecord(jwt *jwtsvid.SVID) tokenAuditRecord {
+	jti, _ := jwt.Claims["jti"].(string)
+	return tokenAuditRecord{
+		Time:     time.Now().UTC(),
+		Event:    "token_written",
+		SpiffeID: jwt.ID.String(),
+		Audience: jwt.Audience,
+		JTI:      jti,
+		Expiry:   jwt.Expiry.UTC(),
+	}
+}
+
+// append writes a record as a JSON line and syncs it
+func (a *auditLog) append(record any) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %w", err)
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.rotate(int64(len(line))); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(line); err != nil {
+		return fmt.Errorf("failed to append to audit log: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit log: %w", err)
+	}
+	return nil
+}
+
+// rotate moves the audit log aside if appending n bytes would exceed maxSize
+func (a *auditLog) rotate(n int64) error {
+	if a.maxSize <= 0 {
+		return nil
+	}
+	info, err := os.Stat(a.path)
+	if err != nil || info.Size() == 0 || info.Size()+n <= a.maxSize {
+		return nil
+	}
+	if err := os.Rename(a.path, a.path+".1"); err != nil {
+		return fmt.Errorf("failed to rotate audit log: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+)
+
+// Scope and API version used by the Azure Key Vault output
+const (
+	azureKeyVaultScope      = "https://vault.azure.net/.default"
+	azureKeyVaultAPIVersion = "7.4"
+)
+
+// AzureKeyVaultConfig configures the Azure Key Vault output. Access tokens
+// come from presenting a JWT SVID as the client assertion of an Azure AD
+// application with a federated identity credential, so no client secret is
+// involved.
+type AzureKeyVaultConfig struct {
+	VaultURL      string `env:"VAULT_URL" help:"URL of the vault, https://<name>.vault.azure.net, enables the Azure Key Vault output."`
+	Secret        string `env:"SECRET" help:"Name of the secret set to the JWT SVID on every rotation."`
+	TenantID      string `env:"TENANT_ID" help:"Azure AD tenant of the application."`
+	ClientID      string `env:"CLIENT_ID" help:"Client ID of the application with a federated identity credential for the SPIFFE ID."`
+	Audience      string `env:"AUDIENCE" help:"Audience of the JWT SVID presented to Azure AD." default:"api://AzureADTokenExchange"`
+	AuthorityHost string `env:"AUTHORITY_HOST" help:"Azure AD authority host." default:"https://login.microsoftonline.com"`
+	DeletedSecret string `env:"DELETED_SECRET" help:"What to do when the secret is soft-deleted: recover it, purge it, or fail." enum:"recover,purge,fail" default:"recover"`
+	MaxAttempts   int    `env:"MAX_ATTEMPTS" help:"Maximum number of attempts per rotation, retrying throttling, server errors and soft-delete conflicts." default:"3"`
+}
+
+// azureKeyVaultSink sets a Key Vault secret to JWT SVIDs
+type azureKeyVaultSink struct {
+	config   AzureKeyVaultConfig
+	client   *http.Client
+	fetchJWT func(ctx context.Context, audience string) (*jwtsvid.SVID, error)
+
+	mu          sync.Mutex
+	accessToken *secretBuffer
+	expiry      time.Time
+}
+
+// azureAPIError is an error response of Azure AD or Key Vault
+type azureAPIError struct {
+	Status     int
+	Code       string
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *azureAPIError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// retryable reports whether the call may succeed if retried, which includes
+// conflicts with a recovery or purge still in progress
+func (e *azureAPIError) retryable() bool {
+	return e.Status == http.StatusTooManyRequests || e.Status >= 500 ||
+		(e.Status == http.StatusConflict && strings.Contains(e.Message, "currently being"))
+}
+
+// softDeleted reports whether the secret exists in the soft-deleted state
+func (e *azureAPIError) softDeleted() bool {
+	return e.Status == http.StatusConflict && e.Code == "Conflict" && strings.Contains(e.Message, "deleted but recoverable")
+}
+
+// newAzureKeyVaultSink validates the configuration of the Azure Key Vault output
+func (s *SpiffeJWT) newAzureKeyVaultSink(c AzureKeyVaultConfig) (*azureKeyVaultSink, error) {
+	if c.Secret == "" {
+		return nil, errors.New("--azure-key-vault-secret is required")
+	}
+	if c.TenantID == "" || c.ClientID == "" {
+		return nil, errors.New("--azure-key-vault-tenant-id and --azure-key-vault-client-id are required")
+	}
+	if c.MaxAttempts < 1 {
+		return nil, errors.New("max attempts must be at least 1")
+	}
+	u, err := url.Parse(c.VaultURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid vault URL %q, expected https://<name>.vault.azure.net", c.VaultURL)
+	}
+	c.VaultURL = strings.TrimSuffix(c.VaultURL, "/")
+	c.AuthorityHost = strings.TrimSuffix(c.AuthorityHost, "/")
+
+	return &azureKeyVaultSink{
+		config:   c,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		fetchJWT: s.fetchJWTSVIDForAudience,
+	}, nil
+}
+
+func (a *azureKeyVaultSink) Name() string {
+	return fmt.Sprintf("azure-key-vault:%s/secrets/%s", a.config.VaultURL, a.config.Secret)
+}
+
+// Write sets the secret to the JWT SVID with an expiry attribute matching the
+// token, so that Key Vault reports it as expired once it is. Throttling,
+// server errors and a soft-deleted secret are retried with backoff.
+func (a *azureKeyVaultSink) Write(ctx context.Context, jwt *jwtsvid.SVID) error {
+	body := map[string]any{
+		"value":       jwt.Marshal(),
+		"contentType": "application/jwt",
+		"attributes": map[string]any{
+			"enabled": true,
+			"exp":     jwt.Expiry.Unix(),
+		},
+	}
+
+	var err error
+	for attempt := 1; attempt <= a.config.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			backoff := time.Duration(1<<(attempt-2)) * time.Second
+			var apiErr *azureAPIError
+			if errors.As(err, &apiErr) && apiErr.RetryAfter > backoff {
+				backoff = apiErr.RetryAfter
+			}
+			logrus.WithContext(ctx).WithError(err).Warnf("Retrying %s in %s", a.Name(), backoff)
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("failed to set secret: %w", ctx.Err())
+			case <-time.After(backoff):
+			}
+		}
+
+		err = a.call(ctx, http.MethodPut, a.secretURL("secrets"), body)
+		var apiErr *azureAPIError
+		if err == nil || !errors.As(err, &apiErr) {
+			break
+		}
+		if apiErr.softDeleted() {
+			if err = a.handleSoftDeleted(ctx); err != nil {
+				break
+			}
+			err = apiErr
+			continue
+		}
+		if !apiErr.retryable() {
+			break
+		}
+	}
+	if err != nil {
+		return a.explain(err)
+	}
+	return nil
+}
+
+// handleSoftDeleted recovers or purges the soft-deleted secret according to
+// --azure-key-vault-deleted-secret. Both operations complete asynchronously,
+// the caller retries setting the secret afterwards.
+func (a *azureKeyVaultSink) handleSoftDeleted(ctx context.Context) error {
+	switch a.config.DeletedSecret {
+	case "recover":
+		if err := a.call(ctx, http.MethodPost, a.secretURL("deletedsecrets")+"/recover", nil); err != nil {
+			return fmt.Errorf("failed to recover soft-deleted secret %s: %w", a.config.Secret, err)
+		}
+		logrus.WithContext(ctx).Warnf("Secret %s was soft-deleted, recovering it", a.Name())
+	case "purge":
+		err := a.call(ctx, http.MethodDelete, a.secretURL("deletedsecrets"), nil)
+		var apiErr *azureAPIError
+		if errors.As(err, &apiErr) && apiErr.Status == http.StatusForbidden {
+			return fmt.Errorf("unable to purge soft-deleted secret %s, purge protection may be enabled on the vault, use --azure-key-vault-deleted-secret=recover: %w", a.config.Secret, err)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to purge soft-deleted secret %s: %w", a.config.Secret, err)
+		}
+		logrus.WithContext(ctx).Warnf("Secret %s was soft-deleted, purging it", a.Name())
+	default:
+		return fmt.Errorf("secret %s is soft-deleted, recover or purge it, or set --azure-key-vault-deleted-secret", a.config.Secret)
+	}
+	return nil
+}
+
+// explain turns permission errors into messages naming the secret and the
+// application used
+func (a *azureKeyVaultSink) explain(err error) error {
+	var apiErr *azureAPIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+
+	switch apiErr.Status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("permission denied on secret %s of %s for application %s, it needs the Key Vault Secrets Officer role or a set secret access policy: %w",
+			a.config.Secret, a.config.VaultURL, a.config.ClientID, err)
+	case http.StatusTooManyRequests:
+		return fmt.Errorf("throttled by %s for secret %s: %w", a.config.VaultURL, a.config.Secret, err)
+	}
+	return err
+}
+
+// secretURL returns the URL of the secret in the given collection
+func (a *azureKeyVaultSink) secretURL(collection string) string {
+	return fmt.Sprintf("%s/%s/%s", a.config.VaultURL, collection, url.PathEscape(a.config.Secret))
+}
+
+// call makes an authenticated call to the Key Vault API
+func (a *azureKeyVaultSink) call(ctx context.Context, method, endpoint string, in any) error {
+	token, err := a.token(ctx)
+	if err != nil {
+		return err
+	}
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint+"?api-version="+azureKeyVaultAPIVersion, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	_, err = a.do(req)
+	return err
+}
+
+// token returns a cached access token, presenting a new JWT SVID to Azure AD
+// when it is about to expire
+func (a *azureKeyVaultSink) token(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.accessToken != nil && time.Until(a.expiry) > time.Minute {
+		return a.accessToken.String(), nil
+	}
+
+	jwt, err := a.fetchJWT(ctx, a.config.Audience)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch JWT SVID for Azure AD: %w", err)
+	}
+
+	form := url.Values{
+		"grant_type":            {"client_credentials"},
+		"client_id":             {a.config.ClientID},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {jwt.Marshal()},
+		"scope":                 {azureKeyVaultScope},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("%s/%s/oauth2/v2.0/token", a.config.AuthorityHost, url.PathEscape(a.config.TenantID)),
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	data, err := a.do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to exchange JWT SVID with Azure AD: %w", err)
+	}
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return "", fmt.Errorf("failed to decode Azure AD response: %w", err)
+	}
+
+	a.accessToken.release()
+	a.accessToken, a.expiry = newSecretBuffer([]byte(resp.AccessToken)), time.Now().Add(time.Duration(resp.ExpiresIn)*time.Second)
+	return resp.AccessToken, nil
+}
+
+// do sends a request and returns the response body, turning error responses
+// of Key Vault and Azure AD into an azureAPIError
+func (a *azureKeyVaultSink) do(req *http.Request) ([]byte, error) {
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode < 300 {
+		return data, nil
+	}
+
+	apiErr := &azureAPIError{Status: resp.StatusCode, Message: string(data)}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		apiErr.RetryAfter = time.Duration(secs) * time.Second
+	}
+	var e struct {
+		// Key Vault errors
+		Error *struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	var ad struct {
+		// Azure AD errors
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if json.Unmarshal(data, &e) == nil && e.Error != nil {
+		apiErr.Code, apiErr.Message = e.Error.Code, e.Error.Message
+	} else if json.Unmarshal(data, &ad) == nil && ad.Error != "" {
+		apiErr.Code, apiErr.Message = ad.Error, ad.Description
+	}
+	return nil, apiErr
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+)
+
+// signTestSVID returns a JWT SVID signed with a throwaway key
+func signTestSVID(t *testing.T, audience string, expiry time.Time) *jwtsvid.SVID {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.Signed(signer).Claims(jwt.Claims{
+		Subject:  "spiffe://example.org/workload",
+		Audience: jwt.Audience{audience},
+		Expiry:   jwt.NewNumericDate(expiry),
+	}).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	svid, err := jwtsvid.ParseInsecure(token, []string{audience})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return svid
+}
+
+// fakeKeyVault serves the Azure AD token endpoint and the secrets of a vault
+type fakeKeyVault struct {
+	mu            sync.Mutex
+	secrets       map[string]map[string]any
+	tokenRequests int
+	puts          int
+	// putStatus, when set, fails every PUT with a Key Vault error
+	putStatus int
+	putCode   string
+}
+
+func (f *fakeKeyVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/tenant/oauth2/v2.0/token":
+		f.tokenRequests++
+		if r.FormValue("client_id") != "client" || r.FormValue("client_assertion") == "" || r.FormValue("scope") != azureKeyVaultScope {
+			http.Error(w, `{"error":"invalid_request","error_description":"bad form"}`, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"access_token": "access-token", "expires_in": 3600})
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/secrets/"):
+		f.puts++
+		if r.Header.Get("Authorization") != "Bearer access-token" || r.URL.Query().Get("api-version") != azureKeyVaultAPIVersion {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if f.putStatus != 0 {
+			w.WriteHeader(f.putStatus)
+			json.NewEncoder(w).Encode(map[string]any{"error": map[string]string{"code": f.putCode, "message": "denied by the test"}})
+			return
+		}
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.secrets[strings.TrimPrefix(r.URL.Path, "/secrets/")] = body
+		json.NewEncoder(w).Encode(body)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// newTestAzureKeyVaultSink returns a sink writing secret "token" to a fake
+// vault, which also serves as the Azure AD authority
+func newTestAzureKeyVaultSink(t *testing.T, vault *fakeKeyVault) *azureKeyVaultSink {
+	t.Helper()
+	server := httptest.NewTLSServer(vault)
+	t.Cleanup(server.Close)
+
+	s := &SpiffeJWT{}
+	sink, err := s.newAzureKeyVaultSink(AzureKeyVaultConfig{
+		VaultURL:      server.URL + "/",
+		Secret:        "token",
+		TenantID:      "tenant",
+		ClientID:      "client",
+		Audience:      "api://AzureADTokenExchange",
+		AuthorityHost: server.URL,
+		DeletedSecret: "recover",
+		MaxAttempts:   3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.client = server.Client()
+	sink.fetchJWT = func(_ context.Context, audience string) (*jwtsvid.SVID, error) {
+		return signTestSVID(t, audience, time.Now().Add(time.Hour)), nil
+	}
+	return sink
+}
+
+// TestAzureKeyVaultWrite checks that the secret is created, then updated with
+// the access token cached from the first write
+func TestAzureKeyVaultWrite(t *testing.T) {
+	vault := &fakeKeyVault{secrets: map[string]map[string]any{}}
+	sink := newTestAzureKeyVaultSink(t, vault)
+
+	for i, name := range []string{"create", "update"} {
+		svid := signTestSVID(t, "workload", time.Now().Add(time.Duration(i+1)*time.Hour))
+		if err := sink.Write(context.Background(), svid); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		secret := vault.secrets["token"]
+		if secret["value"] != svid.Marshal() {
+			t.Errorf("%s: secret value is not the JWT SVID", name)
+		}
+		attrs, _ := secret["attributes"].(map[string]any)
+		if exp, _ := attrs["exp"].(float64); int64(exp) != svid.Expiry.Unix() {
+			t.Errorf("%s: secret expires at %v, want %d", name, attrs["exp"], svid.Expiry.Unix())
+		}
+	}
+	if vault.tokenRequests != 1 {
+		t.Errorf("%d access token requests, want 1", vault.tokenRequests)
+	}
+}
+
+// TestAzureKeyVaultPermissionDenied checks that a forbidden write fails at
+// once with an error naming the secret and the application
+func TestAzureKeyVaultPermissionDenied(t *testing.T) {
+	vault := &fakeKeyVault{secrets: map[string]map[string]any{}, putStatus: http.StatusForbidden, putCode: "Forbidden"}
+	sink := newTestAzureKeyVaultSink(t, vault)
+
+	err := sink.Write(context.Background(), signTestSVID(t, "workload", time.Now().Add(time.Hour)))
+	if err == nil {
+		t.Fatal("Write succeeded")
+	}
+	for _, want := range []string{"permission denied on secret token", "application client", "denied by the test"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+	if vault.puts != 1 {
+		t.Errorf("%d attempts, want 1 as permission errors are not retried", vault.puts)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// BenchCmd load-tests the Workload API of the local SPIFFE agent by driving
+// concurrent JWT SVID fetches through the same client code path as the daemon.
+type BenchCmd struct {
+	SpiffeAgentSocket string        `env:"SPIFFE_AGENT_SOCKET" help:"File name of the SPIFFE agent socket, or a Workload API address such as unix:///path or tcp://host:port" required:""`
+	Audience          string        `help:"Audience of the fetched JWTs." required:""`
+	Concurrency       int           `help:"Number of concurrent fetchers." default:"16"`
+	Duration          time.Duration `help:"Duration of the benchmark." default:"30s"`
+	Rate              int           `help:"Maximum fetches per second across all fetchers, 0 for unlimited."`
+	FetchTimeout      time.Duration `help:"Timeout of a single fetch." default:"10s"`
+	YesIKnow          bool          `name:"yes-i-know" help:"Acknowledge that the benchmark puts significant load on the SPIFFE agent."`
+}
+
+// benchResult collects the outcome of the fetches of one fetcher
+type benchResult struct {
+	latencies []time.Duration
+	errors    map[string]int
+}
+
+// Run executes the benchmark and prints a report
+func (c *BenchCmd) Run() error {
+	if !c.YesIKnow {
+		return errors.New("bench generates heavy load on the SPIFFE agent, pass --yes-i-know to run it")
+	}
+	if c.FetchTimeout <= 0 {
+		return errors.New("--fetch-timeout must be positive")
+	}
+	if c.Concurrency < 1 {
+		return errors.New("--concurrency must be at least 1")
+	}
+	if c.Duration <= 0 {
+		return errors.New("--duration must be positive")
+	}
+	// The fetches are spaced by a ticker, which needs a positive interval
+	if c.Rate < 0 || c.Rate > int(time.Second) {
+		return fmt.Errorf("--rate must be between 1 and %d fetches per second, or 0 for unlimited", int(time.Second))
+	}
+	if err := checkAgentSocket(c.SpiffeAgentSocket); err != nil {
+		return err
+	}
+
+	// Per-fetch logs would drown the report
+	logrus.SetLevel(logrus.WarnLevel)
+	s := &SpiffeJWT{SpiffeAgentSocket: c.SpiffeAgentSocket, JWTAudience: c.Audience, FetchTimeout: c.FetchTimeout}
+
+	var limiter <-chan time.Time
+	if c.Rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(c.Rate))
+		defer ticker.Stop()
+		limiter = ticker.C
+	}
+
+	fmt.Printf("Benchmarking %s for %s with %d fetchers\n", c.SpiffeAgentSocket, c.Duration, c.Concurrency)
+	deadline := time.Now().Add(c.Duration)
+	results := make([]benchResult, c.Concurrency)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(r *benchResult) {
+			defer wg.Done()
+			r.errors = map[string]int{}
+			for time.Now().Before(deadline) {
+				if limiter != nil {
+					<-limiter
+				}
+				start := time.Now()
+				if _, err := s.fetchJWTSVID(context.Background()); err != nil {
+					r.errors[errorCode(err)]++
+					continue
+				}
+				r.latencies = append(r.latencies, time.Since(start))
+			}
+		}(&results[i])
+	}
+	wg.Wait()
+
+	c.report(results)
+	return nil
+}
+
+// report prints throughput, latency percentiles and the error breakdown
+func (c *BenchCmd) report(results []benchResult) {
+	var latencies []time.Duration
+	errs := map[string]int{}
+	failed := 0
+	for _, r := range results {
+		latencies = append(latencies, r.latencies...)
+		for code, n := range r.errors {
+			errs[code] += n
+			failed += n
+		}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	fmt.Printf("Successful fetches: %d (%.1f/s)\n", len(latencies), float64(len(latencies))/c.Duration.Seconds())
+	fmt.Printf("Failed fetches:     %d\n", failed)
+	if len(latencies) > 0 {
+		fmt.Printf("Latency p50=%s p90=%s p99=%s max=%s\n",
+			percentile(latencies, 0.50), percentile(latencies, 0.90),
+			percentile(latencies, 0.99), latencies[len(latencies)-1])
+	}
+
+	names := make([]string, 0, len(errs))
+	for code := range errs {
+		names = append(names, code)
+	}
+	sort.Strings(names)
+	for _, code := range names {
+		fmt.Printf("Errors %-18s %d\n", code+":", errs[code])
+	}
+}
+
+// errorCode classifies a fetch error by its gRPC status code
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded.String()
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled.String()
+	}
+	return status.Code(err).String()
+}
+
+// percentile returns the p-th percentile of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	return sorted[int(float64(len(sorted)-1)*p)]
+}
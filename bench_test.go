@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestBenchValidation checks the flags rejected before any fetch, including
+// rates for which no ticker interval exists
+func TestBenchValidation(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		mutate  func(*BenchCmd)
+		wantErr string
+	}{
+		{"negative rate", func(c *BenchCmd) { c.Rate = -1 }, "--rate"},
+		{"rate above a fetch per nanosecond", func(c *BenchCmd) { c.Rate = int(time.Second) + 1 }, "--rate"},
+		{"zero duration", func(c *BenchCmd) { c.Duration = 0 }, "--duration"},
+		{"zero concurrency", func(c *BenchCmd) { c.Concurrency = 0 }, "--concurrency"},
+		{"zero fetch timeout", func(c *BenchCmd) { c.FetchTimeout = 0 }, "--fetch-timeout"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &BenchCmd{
+				SpiffeAgentSocket: "unix:///nonexistent/agent.sock",
+				Audience:          "bench",
+				Concurrency:       1,
+				Duration:          time.Second,
+				FetchTimeout:      time.Second,
+				YesIKnow:          true,
+			}
+			tc.mutate(c)
+			if err := c.Run(); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("Run() = %v, want an error about %s", err, tc.wantErr)
+			}
+		})
+	}
+}
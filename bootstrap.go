@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// BootstrapConfig configures the bootstrap of the SPIRE agent when its join
+// token is kept in Vault. The Vault token of the token file reads the join
+// token, which is handed to the agent command before the Workload API is
+// used.
+type BootstrapConfig struct {
+	TokenFile      string        `env:"TOKEN_FILE" help:"File with the Vault token used to read the SPIRE agent join token, enables the bootstrap." type:"existingfile"`
+	VaultAddr      string        `env:"VAULT_ADDR" help:"Address of Vault, such as https://vault.example.com:8200."`
+	JoinTokenPath  string        `env:"JOIN_TOKEN_PATH" help:"Vault API path read for the join token, without /v1 (e.g., secret/data/spire/join-token)."`
+	JoinTokenField string        `env:"JOIN_TOKEN_FIELD" help:"Field of the Vault secret holding the join token." default:"join_token"`
+	AgentCmd       string        `env:"AGENT_CMD" help:"Shell command starting the SPIRE agent, with the join token in SPIRE_JOIN_TOKEN (e.g., spire-agent run -joinToken \"$SPIRE_JOIN_TOKEN\")."`
+	Timeout        time.Duration `env:"TIMEOUT" help:"Time allowed to the SPIRE agent to create its socket once started." default:"2m"`
+}
+
+// bootstrapAgent reads the join token from Vault and starts the SPIRE agent
+// with it, then waits for the agent socket. The agent runs alongside the
+// daemon, which shuts down if the agent exits, see watchAgent.
+func (s *SpiffeJWT) bootstrapAgent() error {
+	c := s.Bootstrap
+	if c.VaultAddr == "" || c.JoinTokenPath == "" || c.AgentCmd == "" {
+		return errors.New("--bootstrap-vault-addr, --bootstrap-join-token-path and --bootstrap-agent-cmd are required with --bootstrap-token-file")
+	}
+	data, err := os.ReadFile(c.TokenFile)
+	if err != nil {
+		return fmt.Errorf("failed to read Vault token: %w", err)
+	}
+	vaultToken := strings.TrimSpace(string(data))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	joinToken, err := readVaultField(ctx, c.VaultAddr, c.JoinTokenPath, c.JoinTokenField, vaultToken)
+	if err != nil {
+		return fmt.Errorf("failed to read SPIRE agent join token: %w", err)
+	}
+	logrus.Infof("SPIRE agent join token read from Vault at %s", c.JoinTokenPath)
+
+	cmd := exec.Command("sh", "-c", c.AgentCmd)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "SPIRE_JOIN_TOKEN="+joinToken)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start SPIRE agent: %w", err)
+	}
+	logrus.WithField("pid", cmd.Process.Pid).Info("SPIRE agent started")
+	s.agentExited = make(chan error, 1)
+	go func() {
+		s.agentExited <- cmd.Wait()
+	}()
+
+	return waitAgentSocket(s.SpiffeAgentSocket, c.Timeout)
+}
+
+// watchAgent shuts down when the SPIRE agent of the bootstrap exits, unless
+// ctx is done as the agent may exit along with the daemon
+func (s *SpiffeJWT) watchAgent(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+	case err := <-s.agentExited:
+		if ctx.Err() != nil {
+			logrus.WithError(err).Debug("SPIRE agent exited during shutdown")
+			return
+		}
+		logrus.WithError(err).Fatal("SPIRE agent exited, shutting down")
+	}
+}
+
+// readVaultField reads a field of a Vault secret. Fields of KV version 2
+// secrets are nested under data.data, other secrets have them under data.
+func readVaultField(ctx context.Context, addr, path, field, token string) (string, error) {
+	endpoint := strings.TrimSuffix(addr, "/") + "/v1/" + strings.TrimPrefix(path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", fmt.Errorf("vault answered %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var secret struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("invalid vault response: %w", err)
+	}
+	value, ok := secret.Data[field].(string)
+	if nested, isKV2 := secret.Data["data"].(map[string]any); !ok && isKV2 {
+		value, ok = nested[field].(string)
+	}
+	if !ok || value == "" {
+		return "", fmt.Errorf("no field %q in the secret", field)
+	}
+	return value, nil
+}
+
+// waitAgentSocket waits for the unix socket of the SPIFFE agent to be
+// created. Other address schemes are not waited for.
+func waitAgentSocket(socket string, timeout time.Duration) error {
+	path, ok := agentSocketPath(socket)
+	if !ok {
+		return nil
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			logrus.Infof("SPIFFE agent socket %s is up", path)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("SPIFFE agent socket %s not created within %s", path, timeout)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// agentSocketPath returns the path of a unix socket address of the SPIFFE agent
+func agentSocketPath(socket string) (string, bool) {
+	u, err := url.Parse(workloadAPIAddr(socket))
+	if err != nil || u.Scheme != "unix" {
+		return "", false
+	}
+	if u.Path != "" {
+		return u.Path, true
+	}
+	return u.Opaque, true
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestWatchAgent checks that an exit of the SPIRE agent shuts the daemon
+// down, unless the daemon is already shutting down
+func TestWatchAgent(t *testing.T) {
+	logger := logrus.StandardLogger()
+	defer func(exit func(int)) { logger.ExitFunc = exit }(logger.ExitFunc)
+
+	for _, tc := range []struct {
+		name         string
+		shuttingDown bool
+		wantExit     bool
+	}{
+		{"agent exited", false, true},
+		{"shutdown", true, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			exited := false
+			logger.ExitFunc = func(int) { exited = true }
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tc.shuttingDown {
+				cancel()
+			}
+			s := &SpiffeJWT{agentExited: make(chan error, 1)}
+			s.agentExited <- errors.New("signal: terminated")
+			s.watchAgent(ctx)
+
+			if exited != tc.wantExit {
+				t.Errorf("exited = %v, want %v", exited, tc.wantExit)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// newFetchBudget returns the limiter of --max-fetches-per-minute, nil when
+// unlimited. The bucket holds a minute worth of calls.
+func newFetchBudget(perMinute int) *rate.Limiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(float64(perMinute)/60), perMinute)
+}
+
+// awaitFetchBudget blocks until the Workload API call budget allows another
+// call or ctx is done. Every call draws from the same budget, whatever
+// triggered it, and calls over budget are deferred rather than dropped.
+func (s *SpiffeJWT) awaitFetchBudget(ctx context.Context, call string) error {
+	if s.fetchBudget == nil {
+		return nil
+	}
+	r := s.fetchBudget.Reserve()
+	delay := r.Delay()
+	if delay <= 0 {
+		return nil
+	}
+	workloadAPIThrottled.Inc()
+	logrus.Warnf("Workload API call budget exhausted, deferring %s by %s", call, delay.Round(time.Millisecond))
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// The call is not made, give its slot back
+		r.Cancel()
+		return ctx.Err()
+	}
+}
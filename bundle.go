@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/spiffe/go-spiffe/v2/bundle/jwtbundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+)
+
+// fetchAndWriteJWTBundle fetches the JWT bundle of a trust domain from the
+// SPIFFE agent and writes it to a file as a JWKS document, and the key that
+// signed the current token as a JWK
+func (s *SpiffeJWT) fetchAndWriteJWTBundle(ctx context.Context, td spiffeid.TrustDomain) error {
+	if err := s.awaitFetchBudget(ctx, "JWT bundle fetch"); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	bundles, err := workloadapi.FetchJWTBundles(ctx, s.clientOptions()...)
+	if err != nil {
+		return fmt.Errorf("unable to fetch JWT bundles: %w", err)
+	}
+	bundle, err := bundles.GetJWTBundleForTrustDomain(td)
+	if err != nil {
+		return fmt.Errorf("unable to get JWT bundle: %w", err)
+	}
+
+	if s.JWTBundleFileName != "" {
+		jwks, err := bundle.Marshal()
+		if err != nil {
+			return fmt.Errorf("failed to marshal JWT bundle: %w", err)
+		}
+		if err := s.writeOutputFile(s.JWTBundleFileName, jwks, 0644); err != nil {
+			return fmt.Errorf("failed to write JWT bundle file: %w", err)
+		}
+		logrus.Infof("JWT bundle for %s written to %s", td, s.JWTBundleFileName)
+	}
+	if s.SigningKeyJWKFile != "" {
+		return s.writeSigningKeyJWK(bundle)
+	}
+	return nil
+}
+
+// wantsBundle reports whether any output needs the JWT bundle
+func (s *SpiffeJWT) wantsBundle() bool {
+	return s.JWTBundleFileName != "" || s.SigningKeyJWKFile != ""
+}
+
+// writeSigningKeyJWK writes the bundle key that signed the current token
+// as a JWK, so that consumers can validate it without the Workload API
+func (s *SpiffeJWT) writeSigningKeyJWK(bundle *jwtbundle.Bundle) error {
+	s.mu.RLock()
+	current := s.current
+	s.mu.RUnlock()
+	if current == nil {
+		return errors.New("no JWT SVID to find the signing key of")
+	}
+
+	token, err := parseTokenInsecure(current.Marshal())
+	if err != nil {
+		return err
+	}
+	kid, _ := token.Header["kid"].(string)
+	alg, _ := token.Header["alg"].(string)
+	key, ok := bundle.FindJWTAuthority(kid)
+	if !ok {
+		return fmt.Errorf("signing key %q is not in the JWT bundle of %s", kid, bundle.TrustDomain())
+	}
+
+	jwk, err := json.MarshalIndent(jose.JSONWebKey{Key: key, KeyID: kid, Algorithm: alg, Use: "sig"}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal signing key: %w", err)
+	}
+	if err := s.writeOutputFile(s.SigningKeyJWKFile, jwk, 0644); err != nil {
+		return fmt.Errorf("failed to write signing key file: %w", err)
+	}
+	s.mu.Lock()
+	s.signingKeyID = kid
+	s.mu.Unlock()
+	logrus.Infof("JWT signing key %s written to %s", kid, s.SigningKeyJWKFile)
+	return nil
+}
+
+// signingKeyChanged reports whether jwt was signed by another key than the
+// one last written to --signing-key-jwk-file
+func (s *SpiffeJWT) signingKeyChanged(jwt *jwtsvid.SVID) bool {
+	token, err := parseTokenInsecure(jwt.Marshal())
+	if err != nil {
+		return true
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return token.Header["kid"] != s.signingKeyID
+}
+
+// bundleLoop refreshes the JWT bundle on its own cadence. The bundle rotates
+// far less often than tokens, so it is not tied to the token schedule.
+// Failures are logged and retried on the next tick as the token is unaffected.
+// It stops when ctx is done.
+func (s *SpiffeJWT) bundleLoop(ctx context.Context, td spiffeid.TrustDomain) {
+	logrus.Infof("Refreshing JWT bundle every %s", s.BundleRefreshInterval)
+	ticker := time.NewTicker(s.BundleRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := s.fetchAndWriteJWTBundle(ctx, td); err != nil {
+			s.stats.recordFailure(failureBundle)
+			logrus.WithError(err).WithField(failureClassField, failureBundle).Error("unable to refresh JWT bundle")
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+)
+
+// x509BundleWatcher forces a refresh of the JWT SVID whenever the X.509
+// bundles served by the agent change. The first update only records them.
+type x509BundleWatcher struct {
+	s    *SpiffeJWT
+	last []byte
+}
+
+func (w *x509BundleWatcher) OnX509BundlesUpdate(set *x509bundle.Set) {
+	sum := bundlesFingerprint(set)
+	if w.last == nil {
+		w.last = sum
+		logrus.Infof("Watching %d X.509 bundles for changes", set.Len())
+		return
+	}
+	if bytes.Equal(sum, w.last) {
+		return
+	}
+	w.last = sum
+	bundleChanges.Inc()
+	logrus.Info("X.509 bundles changed")
+	w.s.requestRefresh("--watch-bundle-changes")
+}
+
+func (w *x509BundleWatcher) OnX509BundlesWatchError(err error) {
+	logrus.WithError(err).Warn("X.509 bundle watch failed, retrying")
+}
+
+// bundlesFingerprint hashes the authorities of every trust domain of set.
+// Bundles are listed in trust domain order.
+func bundlesFingerprint(set *x509bundle.Set) []byte {
+	h := sha256.New()
+	for _, bundle := range set.Bundles() {
+		h.Write([]byte(bundle.TrustDomain().IDString()))
+		for _, cert := range bundle.X509Authorities() {
+			h.Write(cert.Raw)
+		}
+	}
+	return h.Sum(nil)
+}
+
+// watchBundleChanges watches the X.509 bundles for --watch-bundle-changes
+// until ctx is done. The Workload API client retries on its own.
+func (s *SpiffeJWT) watchBundleChanges(ctx context.Context) {
+	err := workloadapi.WatchX509Bundles(ctx, &x509BundleWatcher{s: s}, s.clientOptions()...)
+	if err != nil && ctx.Err() == nil {
+		logrus.WithError(err).Error("X.509 bundle watch stopped")
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// certReloader serves the health server certificate from files that rotate
+// underneath it. The key pair is loaded again during a handshake once either
+// file has changed, and the last good pair is kept while the new one does
+// not load, as when the cert and key are caught between two writes.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	version string
+	// failed is the version of the files that last failed to load, not
+	// retried until they change again
+	failed string
+}
+
+// newCertReloader loads the initial key pair
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// fileVersion identifies the content of the files by size and mtime
+func fileVersion(paths ...string) (string, error) {
+	var version string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		version += fmt.Sprintf("%d:%d;", info.Size(), info.ModTime().UnixNano())
+	}
+	return version, nil
+}
+
+// reload loads the key pair if the files changed since the last load
+func (r *certReloader) reload() error {
+	version, err := fileVersion(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("unable to stat health server certificate: %w", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if version == r.version || version == r.failed {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		r.failed = version
+		return fmt.Errorf("unable to load health server certificate: %w", err)
+	}
+	if r.cert != nil {
+		logrus.Infof("Health server certificate %s reloaded, it expires at %s", r.certFile, cert.Leaf.NotAfter.Format(time.RFC3339))
+	}
+	r.cert, r.version = &cert, version
+	return nil
+}
+
+// getCertificate is the GetCertificate callback of the health server
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if err := r.reload(); err != nil {
+		logrus.WithError(err).Warn("Serving the previous health server certificate")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cert, nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// keyClaims are listed first in the comparison as they identify the token
+var keyClaims = []string{"aud", "sub", "iss", "exp"}
+
+// timestampClaims are expected to differ between two tokens of the same identity
+var timestampClaims = map[string]bool{"exp": true, "iat": true, "nbf": true}
+
+// CompareCmd diffs the headers and claims of two token files. It fails when
+// claims other than timestamps differ, which makes it usable as a CI check.
+type CompareCmd struct {
+	FileA string `arg:"" name:"file-a" help:"First token file." type:"existingfile"`
+	FileB string `arg:"" name:"file-b" help:"Second token file." type:"existingfile"`
+
+	EncryptionKeyFile string `env:"ENCRYPTION_KEY_FILE" help:"File with the hex encoded AES key of encrypted token files." type:"existingfile"`
+}
+
+// Run prints the comparison and returns an error if the tokens differ
+func (c *CompareCmd) Run() error {
+	var key []byte
+	if c.EncryptionKeyFile != "" {
+		var err error
+		if key, err = loadEncryptionKey(c.EncryptionKeyFile); err != nil {
+			return err
+		}
+	}
+
+	a, err := parseTokenFile(c.FileA, key)
+	if err != nil {
+		return err
+	}
+	b, err := parseTokenFile(c.FileB, key)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("--- %s\n+++ %s\n", c.FileA, c.FileB)
+	fmt.Println("header:")
+	printDiff(a.Header, b.Header, nil)
+	fmt.Println("claims:")
+	differs := printDiff(a.Claims, b.Claims, keyClaims)
+
+	var significant []string
+	for _, k := range differs {
+		if !timestampClaims[k] {
+			significant = append(significant, k)
+		}
+	}
+	if len(significant) > 0 {
+		return fmt.Errorf("claims differ: %v", significant)
+	}
+	return nil
+}
+
+// parseTokenFile reads and insecurely parses a token file
+func parseTokenFile(path string, key []byte) (*parsedToken, error) {
+	token, err := readTokenFile(path, key)
+	if err != nil {
+		return nil, err
+	}
+	p, err := parseTokenInsecure(token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// printDiff prints one line per key of a and b, listing the keys in first
+// followed by the remaining keys in order, and returns the keys that differ.
+// Lines are prefixed with "  " when equal, "- " or "+ " when only present on
+// one side and "! " when the values differ.
+func printDiff(a, b map[string]any, first []string) []string {
+	keys := orderedKeys(a, b, first)
+
+	var differs []string
+	for _, k := range keys {
+		va, okA := a[k]
+		vb, okB := b[k]
+		switch {
+		case !okA:
+			fmt.Printf("+ %-6s %s\n", k, formatValue(k, vb))
+		case !okB:
+			fmt.Printf("- %-6s %s\n", k, formatValue(k, va))
+		case reflect.DeepEqual(va, vb):
+			fmt.Printf("  %-6s %s\n", k, formatValue(k, va))
+			continue
+		default:
+			fmt.Printf("! %-6s %s != %s\n", k, formatValue(k, va), formatValue(k, vb))
+		}
+		differs = append(differs, k)
+	}
+	return differs
+}
+
+// orderedKeys returns the union of the keys of a and b, starting with the
+// keys in first that are present in either map
+func orderedKeys(a, b map[string]any, first []string) []string {
+	seen := map[string]bool{}
+	var keys []string
+	for _, k := range first {
+		_, okA := a[k]
+		_, okB := b[k]
+		if okA || okB {
+			keys = append(keys, k)
+			seen[k] = true
+		}
+	}
+
+	var rest []string
+	for _, m := range []map[string]any{a, b} {
+		for k := range m {
+			if !seen[k] {
+				rest = append(rest, k)
+				seen[k] = true
+			}
+		}
+	}
+	sort.Strings(rest)
+	return append(keys, rest...)
+}
+
+// Bounds of the rendering of a header or claim value, so that crafted
+// tokens cannot flood the output
+const (
+	maxValueDepth  = 8
+	maxValueLength = 512
+)
+
+// formatValue renders a header or claim value, annotating timestamps.
+// Values nested deeper than maxValueDepth are elided and the rendering is
+// truncated to maxValueLength bytes.
+func formatValue(key string, v any) string {
+	if n, ok := v.(float64); ok && timestampClaims[key] {
+		return fmt.Sprintf("%d (%s)", int64(n), time.Unix(int64(n), 0).UTC().Format(time.RFC3339))
+	}
+	data, err := json.Marshal(elideDeep(v, maxValueDepth))
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	if len(data) > maxValueLength {
+		return fmt.Sprintf("%s... (%d bytes)", strings.ToValidUTF8(string(data[:maxValueLength]), ""), len(data))
+	}
+	return string(data)
+}
+
+// elideDeep returns v with the objects and arrays below depth replaced by "..."
+func elideDeep(v any, depth int) any {
+	switch v := v.(type) {
+	case map[string]any:
+		if depth == 0 {
+			return "..."
+		}
+		out := make(map[string]any, len(v))
+		for k, e := range v {
+			out[k] = elideDeep(e, depth-1)
+		}
+		return out
+	case []any:
+		if depth == 0 {
+			return "..."
+		}
+		out := make([]any, len(v))
+		for i, e := range v {
+			out[i] = elideDeep(e, depth-1)
+		}
+		return out
+	default:
+		return v
+	}
+}
@@ -0,0 +1,616 @@
+package main
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"gopkg.in/yaml.v3"
+)
+
+// configReport carries the outcome of configuration validation between the
+// parse hooks of the config flags
+type configReport struct {
+	checkOnly bool
+	errs      []error
+	values    map[string]any
+	// profile is the applied --profile and fromProfile the keys it set
+	profile     string
+	fromProfile map[string]bool
+}
+
+// configFile is a flag loading a YAML configuration file. Keys are the long
+// flag names, e.g. jwt-audience or s3-bucket. Command line flags and
+// environment variables take precedence over it. The file is decoded
+// strictly: unknown keys and mistyped values are errors reported with their
+// line and column, all of them at once. Named presets of keys may be
+// defined under profiles and applied with --profile, the other keys of the
+// file override them.
+type configFile string
+
+// configDoc is a loaded configuration file
+type configDoc struct {
+	values   map[string]any
+	profiles map[string]map[string]any
+}
+
+// BeforeResolve loads and validates the configuration file
+func (c configFile) BeforeResolve(ctx *kong.Context, trace *kong.Path, report *configReport) error {
+	path := string(ctx.FlagValue(trace.Flag).(configFile))
+	doc, errs := loadConfigFile(path, ctx.Flags())
+	report.errs = errs
+	if doc != nil {
+		report.profile = profileFlagValue(ctx)
+		if report.profile == "" {
+			report.profile, _ = doc.values["profile"].(string)
+		}
+		var err error
+		if report.values, report.fromProfile, err = doc.withProfile(report.profile); err != nil {
+			report.errs = append(report.errs, fmt.Errorf("%s: %w", path, err))
+		}
+	}
+	if len(report.errs) > 0 && !report.checkOnly {
+		return errors.Join(report.errs...)
+	}
+
+	// Environment variables are applied before resolvers, a flag set from
+	// the environment must not be resolved from the file again
+	ctx.AddResolver(kong.ResolverFunc(func(_ *kong.Context, _ *kong.Path, flag *kong.Flag) (any, error) {
+		if envSet(flag.Envs) {
+			return nil, nil
+		}
+		return report.values[flag.Name], nil
+	}))
+	return nil
+}
+
+// profileFlagValue returns the --profile set on the command line or in the
+// environment, if any
+func profileFlagValue(ctx *kong.Context) string {
+	for _, flag := range ctx.Flags() {
+		if flag.Name == "profile" {
+			profile, _ := ctx.FlagValue(flag).(string)
+			return profile
+		}
+	}
+	return ""
+}
+
+// withProfile returns the values of the file with those of the named profile
+// underneath, and the keys set by the profile
+func (d *configDoc) withProfile(profile string) (map[string]any, map[string]bool, error) {
+	if profile == "" {
+		return d.values, nil, nil
+	}
+	preset, ok := d.profiles[profile]
+	if !ok {
+		names := make([]string, 0, len(d.profiles))
+		for name := range d.profiles {
+			names = append(names, strconv.Quote(name))
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return nil, nil, fmt.Errorf("unknown profile %q, no profiles are defined", profile)
+		}
+		return nil, nil, fmt.Errorf("unknown profile %q, available profiles: %s", profile, strings.Join(names, ", "))
+	}
+
+	values := map[string]any{}
+	fromProfile := map[string]bool{}
+	for k, v := range preset {
+		values[k], fromProfile[k] = v, true
+	}
+	for k, v := range d.values {
+		values[k] = v
+		delete(fromProfile, k)
+	}
+	return values, fromProfile, nil
+}
+
+// configCheckFlag validates the configuration and exits, for use in CI
+type configCheckFlag bool
+
+// BeforeReset switches configuration loading into reporting mode
+func (c configCheckFlag) BeforeReset(report *configReport) error {
+	report.checkOnly = true
+	return nil
+}
+
+// BeforeApply reports all configuration errors, including required flags
+// that are set neither in the file, the environment nor on the command line,
+// and exits.
+func (c configCheckFlag) BeforeApply(k *kong.Kong, ctx *kong.Context, report *configReport) error {
+	onCLI := map[*kong.Flag]bool{}
+	for _, p := range ctx.Path {
+		if p.Flag != nil {
+			onCLI[p.Flag] = true
+		}
+	}
+	isSet := func(flag *kong.Flag) bool {
+		return onCLI[flag] || report.values[flag.Name] != nil || envSet(flag.Envs)
+	}
+
+	// A required flag in a xor group is satisfied by any member of the group
+	xorSet := map[string]bool{}
+	for _, flag := range ctx.Flags() {
+		for _, xor := range flag.Xor {
+			xorSet[xor] = xorSet[xor] || isSet(flag)
+		}
+	}
+
+	errs := report.errs
+	for _, flag := range ctx.Flags() {
+		if !flag.Required || isSet(flag) {
+			continue
+		}
+		if len(flag.Xor) > 0 {
+			if !xorSet[flag.Xor[0]] && flag == firstInXor(ctx.Flags(), flag.Xor[0]) {
+				errs = append(errs, fmt.Errorf("missing one of the required keys %s", strings.Join(xorNames(ctx.Flags(), flag.Xor[0]), ", ")))
+			}
+			continue
+		}
+		errs = append(errs, fmt.Errorf("missing required key %q (flag --%s, env %s)", flag.Name, flag.Name, strings.Join(flag.Envs, ", ")))
+	}
+
+	if len(errs) == 0 {
+		fmt.Fprintln(k.Stdout, "configuration OK")
+		k.Exit(0)
+		return nil
+	}
+	for _, err := range errs {
+		fmt.Fprintln(k.Stderr, err)
+	}
+	fmt.Fprintf(k.Stderr, "configuration has %d error(s)\n", len(errs))
+	k.Exit(1)
+	return nil
+}
+
+// printConfigFlag prints the resolved configuration and exits
+type printConfigFlag bool
+
+// BeforeApply prints every flag that has a value with where the value comes
+// from
+func (c printConfigFlag) BeforeApply(k *kong.Kong, ctx *kong.Context, report *configReport) error {
+	for _, e := range resolvedConfig(ctx, report) {
+		fmt.Fprintf(k.Stdout, "%s: %s # %s\n", e.Name, printedValue(e.Value), e.Source)
+	}
+	k.Exit(0)
+	return nil
+}
+
+// configEntry is a flag that has a value and where the value comes from
+type configEntry struct {
+	Name   string `json:"name"`
+	Value  any    `json:"value"`
+	Source string `json:"source"`
+}
+
+// resolvedConfig returns every flag that has a value with where the value
+// comes from, in order of precedence: the command line, the environment, the
+// configuration file, its profile or the default.
+func resolvedConfig(ctx *kong.Context, report *configReport) []configEntry {
+	onCLI := map[*kong.Flag]bool{}
+	for _, p := range ctx.Path {
+		if p.Flag != nil && !p.Resolved {
+			onCLI[p.Flag] = true
+		}
+	}
+
+	var entries []configEntry
+	for _, flag := range ctx.Flags() {
+		if flag.Hidden || flag.Name == "help" || isConfigFlag(flag) {
+			continue
+		}
+		var source string
+		switch {
+		case onCLI[flag]:
+			source = "flag"
+		case envSet(flag.Envs):
+			source = "env " + strings.Join(flag.Envs, ", ")
+		case report.fromProfile[flag.Name]:
+			source = "profile " + report.profile
+		case report.values[flag.Name] != nil:
+			source = "config"
+		case flag.HasDefault:
+			source = "default"
+		default:
+			continue
+		}
+		entries = append(entries, configEntry{Name: flag.Name, Value: ctx.FlagValue(flag), Source: source})
+	}
+	return entries
+}
+
+// printedValue renders a flag value for --print-config
+func printedValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v)
+	case configFile, spiffeHelperConfig:
+		return strconv.Quote(fmt.Sprint(v))
+	case time.Duration:
+		return v.String()
+	}
+	return fmt.Sprint(v)
+}
+
+// firstInXor returns the first flag of a xor group
+func firstInXor(flags []*kong.Flag, group string) *kong.Flag {
+	for _, flag := range flags {
+		for _, xor := range flag.Xor {
+			if xor == group {
+				return flag
+			}
+		}
+	}
+	return nil
+}
+
+// xorNames returns the quoted names of the flags in a xor group
+func xorNames(flags []*kong.Flag, group string) []string {
+	var names []string
+	for _, flag := range flags {
+		for _, xor := range flag.Xor {
+			if xor == group {
+				names = append(names, strconv.Quote(flag.Name))
+			}
+		}
+	}
+	return names
+}
+
+// envSet reports whether any of the environment variables is set
+func envSet(envs []string) bool {
+	for _, env := range envs {
+		if _, ok := os.LookupEnv(env); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// loadConfigFile reads a YAML configuration file and validates it against
+// flags. It returns the values to resolve flags from, the profiles, and every
+// error found.
+func loadConfigFile(path string, flags []*kong.Flag) (*configDoc, []error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, []error{fmt.Errorf("failed to read configuration: %w", err)}
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, []error{fmt.Errorf("%s: %w", path, err)}
+	}
+	if len(doc.Content) == 0 {
+		return &configDoc{}, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, []error{configError(path, root, "", "expected a mapping of flag names to values")}
+	}
+
+	byName := map[string]*kong.Flag{}
+	for _, flag := range flags {
+		if flag.Name != "help" && !isConfigFlag(flag) {
+			byName[flag.Name] = flag
+		}
+	}
+
+	loaded := &configDoc{profiles: map[string]map[string]any{}}
+	var errs []error
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if key.Value != "profiles" {
+			continue
+		}
+		if value.Kind != yaml.MappingNode {
+			errs = append(errs, configError(path, value, key.Value, "expected a mapping of profile names to keys, got "+nodeKind(value)))
+			continue
+		}
+		for j := 0; j+1 < len(value.Content); j += 2 {
+			name, preset := value.Content[j], value.Content[j+1]
+			if preset.Kind != yaml.MappingNode {
+				errs = append(errs, configError(path, preset, "profiles."+name.Value, "expected a mapping of flag names to values, got "+nodeKind(preset)))
+				continue
+			}
+			values, presetErrs := configMappingValues(path, preset, byName, "profiles."+name.Value+".")
+			loaded.profiles[name.Value] = values
+			errs = append(errs, presetErrs...)
+		}
+	}
+
+	values, valueErrs := configMappingValues(path, root, byName, "")
+	loaded.values = values
+	return loaded, append(errs, valueErrs...)
+}
+
+// configMappingValues validates a mapping of flag names to values, the root
+// of the file when prefix is empty and a profile otherwise. Errors are
+// reported with the key prefixed.
+func configMappingValues(path string, node *yaml.Node, byName map[string]*kong.Flag, prefix string) (map[string]any, []error) {
+	values := map[string]any{}
+	var errs []error
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if prefix == "" && key.Value == "profiles" {
+			continue
+		}
+		if prefix != "" && key.Value == "profile" {
+			errs = append(errs, configError(path, key, prefix+key.Value, "a profile cannot select another profile"))
+			continue
+		}
+		flag, ok := byName[key.Value]
+		if !ok {
+			msg := "unknown key"
+			if guess := closestName(key.Value, byName); guess != "" {
+				msg += fmt.Sprintf(", did you mean %q?", guess)
+			}
+			errs = append(errs, configError(path, key, prefix+key.Value, msg))
+			continue
+		}
+
+		v, keyErrs := configNodeValue(path, prefix+key.Value, value, flag.Target.Type())
+		if len(keyErrs) > 0 {
+			errs = append(errs, keyErrs...)
+			continue
+		}
+		values[key.Value] = v
+	}
+	return values, errs
+}
+
+// configNodeValue validates a YAML node against a flag type and converts it
+// into a value kong can decode
+func configNodeValue(path, key string, node *yaml.Node, t reflect.Type) (any, []error) {
+	switch {
+	case t.Kind() == reflect.Slice:
+		if node.Kind == yaml.ScalarNode {
+			return node.Value, nil
+		}
+		if node.Kind != yaml.SequenceNode {
+			return nil, []error{configError(path, node, key, "expected a list, got "+nodeKind(node))}
+		}
+		var errs []error
+		items := make([]any, 0, len(node.Content))
+		for i, item := range node.Content {
+			v, itemErrs := configNodeValue(path, fmt.Sprintf("%s[%d]", key, i), item, t.Elem())
+			errs = append(errs, itemErrs...)
+			items = append(items, v)
+		}
+		return items, errs
+
+	case t.Kind() == reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return nil, []error{configError(path, node, key, "expected a mapping, got "+nodeKind(node))}
+		}
+		var errs []error
+		items := map[string]any{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			k := node.Content[i].Value
+			v, itemErrs := configNodeValue(path, key+"."+k, node.Content[i+1], t.Elem())
+			errs = append(errs, itemErrs...)
+			items[k] = v
+		}
+		return items, errs
+	}
+
+	if node.Kind != yaml.ScalarNode {
+		return nil, []error{configError(path, node, key, "expected a value of type "+configTypeName(t)+", got "+nodeKind(node))}
+	}
+
+	var err error
+	switch {
+	case reflect.PointerTo(t).Implements(textUnmarshaler):
+		if err := reflect.New(t).Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(node.Value)); err != nil {
+			return nil, []error{configError(path, node, key, err.Error())}
+		}
+	case t == reflect.TypeOf(time.Duration(0)):
+		_, err = time.ParseDuration(node.Value)
+	case t.Kind() == reflect.Bool:
+		_, err = strconv.ParseBool(node.Value)
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Int64:
+		_, err = strconv.ParseInt(node.Value, 10, 64)
+	case t.Kind() >= reflect.Uint && t.Kind() <= reflect.Uint64:
+		_, err = strconv.ParseUint(node.Value, 0, 64)
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		_, err = strconv.ParseFloat(node.Value, 64)
+	}
+	if err != nil {
+		return nil, []error{configError(path, node, key, fmt.Sprintf("invalid %s %q", configTypeName(t), node.Value))}
+	}
+	return node.Value, nil
+}
+
+// configError formats an error located at a YAML node
+func configError(path string, node *yaml.Node, key, msg string) error {
+	if key == "" {
+		return fmt.Errorf("%s:%d:%d: %s", path, node.Line, node.Column, msg)
+	}
+	return fmt.Errorf("%s:%d:%d: %s: %s", path, node.Line, node.Column, key, msg)
+}
+
+// nodeKind describes the kind of a YAML node in errors
+func nodeKind(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		return "a list"
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.AliasNode:
+		return "an alias"
+	}
+	return "a scalar"
+}
+
+// isConfigFlag reports whether a flag loads configuration rather than being
+// a configuration value itself
+func isConfigFlag(flag *kong.Flag) bool {
+	switch flag.Target.Type() {
+	case reflect.TypeOf(configFile("")), reflect.TypeOf(configCheckFlag(false)), reflect.TypeOf(printConfigFlag(false)), reflect.TypeOf(spiffeHelperConfig("")):
+		return true
+	}
+	return false
+}
+
+// closestName returns the known flag name closest to name, if any is close
+// enough to be a likely typo. Trailing parts of flag names are considered
+// too, so "audiance" suggests "jwt-audience".
+func closestName(name string, known map[string]*kong.Flag) string {
+	best, bestDist := "", 4
+	for candidate := range known {
+		d := editDistance(name, candidate)
+		for i, c := range candidate {
+			if c == '-' {
+				d = min(d, editDistance(name, candidate[i+1:]))
+			}
+		}
+		if d < bestDist || (d == bestDist && candidate < best) {
+			best, bestDist = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance computes the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// GenerateConfigCmd prints a documented YAML configuration skeleton
+type GenerateConfigCmd struct{}
+
+// Run writes the configuration skeleton of the run command to stdout
+func (c *GenerateConfigCmd) Run(ctx *kong.Context) error {
+	var run *kong.Node
+	for _, child := range ctx.Model.Children {
+		if child.Name == "run" {
+			run = child
+		}
+	}
+	if run == nil {
+		return errors.New("run command not found")
+	}
+
+	w := ctx.Stdout
+	fmt.Fprintln(w, "# spiffe-jwt configuration, load it with --config <file>.")
+	fmt.Fprintln(w, "# Keys are the long flag names. Command line flags and environment")
+	fmt.Fprintln(w, "# variables take precedence over values set in this file.")
+	fmt.Fprintln(w, "# Options without a default are commented out. Validate the file with")
+	fmt.Fprintln(w, "# --config <file> --config-check.")
+	fmt.Fprintln(w, "#")
+	fmt.Fprintln(w, "# Presets of keys may be defined under profiles and applied with")
+	fmt.Fprintln(w, "# --profile <name> or a profile key, the other keys override them:")
+	fmt.Fprintln(w, "# profiles:")
+	fmt.Fprintln(w, "#   vault:")
+	fmt.Fprintln(w, "#     jwt-audience: vault")
+
+	group := ""
+	for _, flag := range run.Flags {
+		if flag.Hidden || flag.Name == "help" || isConfigFlag(flag) {
+			continue
+		}
+		if flag.Group != nil && flag.Group.Title != group {
+			group = flag.Group.Title
+			fmt.Fprintf(w, "\n# --- %s ---\n", group)
+		}
+		writeConfigFlag(w, flag)
+	}
+	return nil
+}
+
+// writeConfigFlag writes a single documented configuration key
+func writeConfigFlag(w io.Writer, flag *kong.Flag) {
+	fmt.Fprintln(w)
+	help := flag.Help
+	if flag.Required {
+		help += " (required)"
+	}
+	fmt.Fprintf(w, "# %s\n", help)
+
+	meta := "type: " + configTypeName(flag.Target.Type())
+	if len(flag.Envs) > 0 {
+		meta += ", env: " + strings.Join(flag.Envs, ", ")
+	}
+	fmt.Fprintf(w, "# %s\n", meta)
+
+	if flag.HasDefault {
+		fmt.Fprintf(w, "%s: %s\n", flag.Name, configValue(flag.Target.Type(), flag.Default))
+	} else {
+		fmt.Fprintf(w, "# %s: %s\n", flag.Name, configValue(flag.Target.Type(), ""))
+	}
+}
+
+// textUnmarshaler is the type of flags parsed from a single string
+var textUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// configTypeName returns a human readable name of a flag type
+func configTypeName(t reflect.Type) string {
+	switch {
+	case t == reflect.TypeOf(time.Duration(0)):
+		return "duration"
+	case reflect.PointerTo(t).Implements(textUnmarshaler):
+		return "string"
+	case t.Kind() == reflect.Slice:
+		return "list of " + configTypeName(t.Elem())
+	case t.Kind() == reflect.Map:
+		return "map of " + configTypeName(t.Key()) + " to " + configTypeName(t.Elem())
+	}
+	return t.Kind().String()
+}
+
+// configValue renders a default value in YAML for the given flag type
+func configValue(t reflect.Type, v string) string {
+	if v == "" {
+		switch {
+		case t == reflect.TypeOf(time.Duration(0)):
+			return "0s"
+		case t.Kind() == reflect.Bool:
+			return "false"
+		case t.Kind() >= reflect.Int && t.Kind() <= reflect.Float64:
+			return "0"
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return v
+	case reflect.Slice:
+		if v == "" {
+			return "[]"
+		}
+		var items []string
+		for _, item := range strings.Split(v, ",") {
+			items = append(items, strconv.Quote(item))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case reflect.Map:
+		return "{}"
+	}
+	return strconv.Quote(v)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/kong"
+)
+
+// TestConfigPrecedence checks that flags win over environment variables,
+// which win over the configuration file and its profile
+func TestConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	config := `
+spiffe-agent-socket: /run/agent.sock
+jwt-audience: file
+jwt-file-name: /run/token
+profile: base
+profiles:
+  base:
+    jwt-audience: profile
+    svid-hint: profile
+`
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		env     string
+		args    []string
+		want    string
+		wantSrc string
+	}{
+		{"config file", "", nil, "file", "config"},
+		{"environment", "env", nil, "env", "env JWT_AUDIENCE"},
+		{"flag", "env", []string{"--jwt-audience", "flag"}, "flag", "flag"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.env != "" {
+				t.Setenv("JWT_AUDIENCE", tc.env)
+			}
+			cli := &CLI{}
+			report := &configReport{}
+			parser, err := kong.New(cli, kong.Bind(report))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, err := parser.Parse(append([]string{"--config", path}, tc.args...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cli.Run.JWTAudience != tc.want {
+				t.Errorf("jwt-audience = %q, want %q", cli.Run.JWTAudience, tc.want)
+			}
+			if cli.Run.SVIDHint != "profile" {
+				t.Errorf("svid-hint = %q, want the profile value", cli.Run.SVIDHint)
+			}
+			for _, entry := range resolvedConfig(ctx, report) {
+				if entry.Name == "jwt-audience" && entry.Source != tc.wantSrc {
+					t.Errorf("jwt-audience comes from %q, want %q", entry.Source, tc.wantSrc)
+				}
+			}
+		})
+	}
+}
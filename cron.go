@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// parseRefreshCron parses --refresh-cron in the timezone of --refresh-cron-timezone
+func (s *SpiffeJWT) parseRefreshCron() (cron.Schedule, error) {
+	loc, err := time.LoadLocation(s.RefreshCronTimezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --refresh-cron-timezone: %w", err)
+	}
+	schedule, err := cron.ParseStandard(s.RefreshCron)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --refresh-cron: %w", err)
+	}
+	if spec, ok := schedule.(*cron.SpecSchedule); ok && spec.Location == time.Local {
+		spec.Location = loc
+	}
+	return schedule, nil
+}
+
+// cronLoop forces a refresh at every time matching --refresh-cron, on top of
+// the expiry-driven schedule. Requests go through requestRefresh, so they
+// coalesce with pending ones and honour the forced refresh cooldown. It
+// stops when ctx is done.
+func (s *SpiffeJWT) cronLoop(ctx context.Context, schedule cron.Schedule) {
+	for {
+		next := schedule.Next(time.Now())
+		s.mu.Lock()
+		s.nextCronRefresh = next
+		s.mu.Unlock()
+		logrus.Infof("Next scheduled refresh at %s", next.Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.requestRefresh("--refresh-cron")
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+)
+
+// DatadogConfig configures the Datadog events of --token-metadata-backend=datadog
+type DatadogConfig struct {
+	APIKeyFile string   `name:"api-key-file" env:"API_KEY_FILE" help:"File with the Datadog API key." type:"existingfile"`
+	APIURL     string   `name:"api-url" env:"API_URL" help:"Datadog API endpoint of the site, e.g. https://api.datadoghq.eu." default:"https://api.datadoghq.com"`
+	Env        string   `env:"ENV" help:"Environment of the env tag of every event."`
+	Tags       []string `env:"TAGS" help:"Additional tags of every event, as key:value."`
+}
+
+// datadogEvent is a request of the Datadog Events API
+type datadogEvent struct {
+	Title          string   `json:"title"`
+	Text           string   `json:"text"`
+	Tags           []string `json:"tags"`
+	AlertType      string   `json:"alert_type"`
+	AggregationKey string   `json:"aggregation_key"`
+	SourceTypeName string   `json:"source_type_name"`
+}
+
+// datadogSink posts an event to Datadog for every written JWT SVID. It only
+// reports rotations, the token itself is never sent.
+type datadogSink struct {
+	config DatadogConfig
+	apiKey string
+	client *http.Client
+}
+
+// newDatadogSink reads the API key of the Datadog events backend
+func newDatadogSink(c DatadogConfig) (*datadogSink, error) {
+	if c.APIKeyFile == "" {
+		return nil, errors.New("--datadog-api-key-file is required")
+	}
+	key, err := os.ReadFile(c.APIKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Datadog API key: %w", err)
+	}
+	apiKey := strings.TrimSpace(string(key))
+	if apiKey == "" {
+		return nil, fmt.Errorf("Datadog API key file %s is empty", c.APIKeyFile)
+	}
+	u, err := url.Parse(c.APIURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid Datadog API URL %q", c.APIURL)
+	}
+	c.APIURL = strings.TrimSuffix(c.APIURL, "/")
+
+	return &datadogSink{
+		config: c,
+		apiKey: apiKey,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (d *datadogSink) Name() string {
+	return "datadog"
+}
+
+// Write posts an info event describing the rotated JWT SVID
+func (d *datadogSink) Write(ctx context.Context, jwt *jwtsvid.SVID) error {
+	tags := []string{"spiffe_id:" + jwt.ID.String()}
+	for _, aud := range jwt.Audience {
+		tags = append(tags, "audience:"+aud)
+	}
+	if d.config.Env != "" {
+		tags = append(tags, "env:"+d.config.Env)
+	}
+	tags = append(tags, d.config.Tags...)
+
+	text := fmt.Sprintf("SPIFFE ID: %s\nAudience: %s\nExpires at: %s", jwt.ID, strings.Join(jwt.Audience, ", "), jwt.Expiry.UTC().Format(time.RFC3339))
+	if id := refreshID(ctx); id != "" {
+		text += "\nRefresh ID: " + id
+	}
+	data, err := json.Marshal(datadogEvent{
+		Title:          "JWT SVID rotated for " + jwt.ID.String(),
+		Text:           text,
+		Tags:           tags,
+		AlertType:      "info",
+		AggregationKey: jwt.ID.String(),
+		SourceTypeName: "spiffe-jwt",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode Datadog event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.config.APIURL+"/api/v1/events", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("DD-API-KEY", d.apiKey)
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post Datadog event: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("Datadog Events API returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+)
+
+// debugDump is the body of /debug/dump, everything needed to triage a
+// support ticket in one call. It never holds a token.
+type debugDump struct {
+	Time       time.Time          `json:"time"`
+	Version    string             `json:"version"`
+	GoVersion  string             `json:"go_version"`
+	Goroutines int                `json:"goroutines"`
+	Config     []configEntry      `json:"config"`
+	Status     daemonStatus       `json:"status"`
+	Refreshes  []refreshEvent     `json:"refreshes"`
+	Metrics    map[string]float64 `json:"metrics"`
+}
+
+// handleDebugDump serves the support bundle of --enable-debug-dump
+func (s *SpiffeJWT) handleDebugDump(w http.ResponseWriter, r *http.Request) {
+	metrics, err := metricsSnapshot(prometheus.DefaultGatherer)
+	if err != nil {
+		logrus.WithError(err).Warn("unable to gather metrics for /debug/dump")
+	}
+	config := make([]configEntry, len(s.configEntries))
+	for i, e := range s.configEntries {
+		config[i] = configEntry{Name: e.Name, Value: dumpedValue(e.Name, e.Value), Source: e.Source}
+	}
+	writeJSON(w, http.StatusOK, debugDump{
+		Time:       time.Now(),
+		Version:    buildVersion(),
+		GoVersion:  runtime.Version(),
+		Goroutines: runtime.NumGoroutine(),
+		Config:     config,
+		Status:     s.status(),
+		Refreshes:  s.events.recent(),
+		Metrics:    metrics,
+	})
+}
+
+// buildVersion returns the module version and VCS revision of the binary
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	version := info.Main.Version
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			version += " " + setting.Value
+		case "vcs.modified":
+			if setting.Value == "true" {
+				version += " (modified)"
+			}
+		}
+	}
+	return version
+}
+
+// dumpedValue renders a flag value for /debug/dump, JSON scalars and lists
+// as is and anything else as text, with strings redacted
+func dumpedValue(name string, v any) any {
+	switch v := v.(type) {
+	case string:
+		return redactConfigValue(name, v)
+	case bool, int, int64, float64, []string, []int:
+		return v
+	}
+	return fmt.Sprint(v)
+}
+
+// redactConfigValue hides the parts of a flag value that may carry
+// credentials: shell commands, and the user info and query of URLs
+func redactConfigValue(name, str string) string {
+	if str == "" {
+		return str
+	}
+	if strings.HasSuffix(name, "-cmd") || strings.HasSuffix(name, "-hook") {
+		return "REDACTED"
+	}
+	if u, err := url.Parse(str); err == nil && u.Scheme != "" && u.Host != "" {
+		if u.User != nil {
+			u.User = url.User("REDACTED")
+		}
+		if u.RawQuery != "" {
+			u.RawQuery = "REDACTED"
+		}
+		return u.String()
+	}
+	return str
+}
+
+// metricsSnapshot flattens the gathered metrics to one value per series,
+// keyed by name and labels. Histograms and summaries are reduced to their
+// _count and _sum.
+func metricsSnapshot(g prometheus.Gatherer) (map[string]float64, error) {
+	families, err := g.Gather()
+	snapshot := map[string]float64{}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			key := family.GetName() + seriesLabels(m.GetLabel())
+			switch {
+			case m.Counter != nil:
+				snapshot[key] = m.GetCounter().GetValue()
+			case m.Gauge != nil:
+				snapshot[key] = m.GetGauge().GetValue()
+			case m.Untyped != nil:
+				snapshot[key] = m.GetUntyped().GetValue()
+			case m.Histogram != nil:
+				snapshot[family.GetName()+"_count"+seriesLabels(m.GetLabel())] = float64(m.GetHistogram().GetSampleCount())
+				snapshot[family.GetName()+"_sum"+seriesLabels(m.GetLabel())] = m.GetHistogram().GetSampleSum()
+			case m.Summary != nil:
+				snapshot[family.GetName()+"_count"+seriesLabels(m.GetLabel())] = float64(m.GetSummary().GetSampleCount())
+				snapshot[family.GetName()+"_sum"+seriesLabels(m.GetLabel())] = m.GetSummary().GetSampleSum()
+			}
+		}
+	}
+	return snapshot, err
+}
+
+// seriesLabels renders labels in the exposition format, {} omitted when
+// there are none
+func seriesLabels(labels []*dto.LabelPair) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(labels))
+	for i, l := range labels {
+		pairs[i] = fmt.Sprintf("%s=%q", l.GetName(), l.GetValue())
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ",") + "}"
+}
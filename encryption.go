@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// encryptedTokenPrefix marks a token file encrypted with --token-persistence-mode=encrypted
+const encryptedTokenPrefix = "spiffe-jwt-enc:v1:"
+
+// loadEncryptionKey reads a hex encoded 256-bit AES key from a file
+func loadEncryptionKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption key file: %w", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("encryption key file %s is not hex encoded: %w", path, err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key in %s must be 256 bits, got %d", path, len(key)*8)
+	}
+	return key, nil
+}
+
+// encryptToken seals a token with AES-GCM. The result is text: the prefix
+// followed by the base64 encoded nonce and ciphertext.
+func encryptToken(key []byte, token string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(token), nil)
+	return encryptedTokenPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptToken opens a token sealed by encryptToken
+func decryptToken(key []byte, data string) (string, error) {
+	if key == nil {
+		return "", errors.New("token is encrypted, an encryption key file is required")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(data, encryptedTokenPrefix))
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted token: %w", err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("invalid encrypted token: too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	token, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt token: %w", err)
+	}
+	return string(token), nil
+}
+
+// newGCM creates an AES-GCM cipher from a key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+)
+
+// Event stream settings of /events
+const (
+	// eventBuffer is the number of events a subscriber may lag behind
+	// before it is dropped
+	eventBuffer = 16
+	// eventWriteTimeout bounds the write of a single event to a client
+	eventWriteTimeout = 10 * time.Second
+	// eventKeepAlive is the interval of comments keeping idle streams open
+	// through proxies
+	eventKeepAlive = 30 * time.Second
+	// eventHistory is the number of events kept for Last-Event-ID replays
+	// and /debug/dump
+	eventHistory = 20
+)
+
+// errTooManySubscribers is returned once --events-max-subscribers streams
+// are open
+var errTooManySubscribers = errors.New("too many /events subscribers")
+
+// refreshEvent is the data of an /events message, sent on every refresh and
+// when the expiry warning is raised
+type refreshEvent struct {
+	// ID is the SSE event ID, increasing from 1 since the daemon started
+	ID        uint64     `json:"-"`
+	Kind      string     `json:"-"`
+	Time      time.Time  `json:"time"`
+	Status    string     `json:"status"`
+	RefreshID string     `json:"refresh_id,omitempty"`
+	SpiffeID  string     `json:"spiffe_id,omitempty"`
+	Expiry    *time.Time `json:"expiry,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	// Labels are the static labels of --label and the Downward API
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// eventBroker fans refresh events out to the /events subscribers. Publishing
+// never blocks: a subscriber whose buffer is full is dropped, so a slow or
+// stalled client cannot hold up the refresh loop.
+type eventBroker struct {
+	mu     sync.Mutex
+	subs   map[chan refreshEvent]struct{}
+	labels map[string]string
+	// max is the number of subscribers allowed at once, 0 for no limit
+	max    int
+	lastID uint64
+	// The last eventHistory events, oldest first
+	history []refreshEvent
+}
+
+// subscribe registers a new subscriber and, when it reconnects, returns the
+// events published after lastID that it missed. Its channel is closed when
+// it is dropped or the broker is closed.
+func (b *eventBroker) subscribe(lastID uint64, reconnect bool) (chan refreshEvent, []refreshEvent, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.max > 0 && len(b.subs) >= b.max {
+		return nil, nil, errTooManySubscribers
+	}
+	if b.subs == nil {
+		b.subs = make(map[chan refreshEvent]struct{})
+	}
+	ch := make(chan refreshEvent, eventBuffer)
+	b.subs[ch] = struct{}{}
+	if !reconnect {
+		return ch, nil, nil
+	}
+
+	// An ID from before a restart of the daemon replays the whole history
+	if lastID > b.lastID {
+		lastID = 0
+	}
+	i, _ := slices.BinarySearchFunc(b.history, lastID+1, func(ev refreshEvent, id uint64) int {
+		return cmp.Compare(ev.ID, id)
+	})
+	return ch, slices.Clone(b.history[i:]), nil
+}
+
+// unsubscribe removes a subscriber, if it was not dropped already
+func (b *eventBroker) unsubscribe(ch chan refreshEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// publish sends an event to every subscriber, dropping the ones lagging behind
+func (b *eventBroker) publish(ev refreshEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	ev.ID, ev.Labels = b.lastID, b.labels
+	b.history = append(b.history, ev)
+	if len(b.history) > eventHistory {
+		b.history = b.history[1:]
+	}
+
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+			logrus.Warn("Dropping /events subscriber lagging behind")
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// recent returns the last refresh events, oldest first
+func (b *eventBroker) recent() []refreshEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var refreshes []refreshEvent
+	for _, ev := range b.history {
+		if ev.Kind == "" {
+			refreshes = append(refreshes, ev)
+		}
+	}
+	return refreshes
+}
+
+// failing reports whether the last refresh event is a failure
+func (b *eventBroker) failing() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i := len(b.history) - 1; i >= 0; i-- {
+		if b.history[i].Kind == "" {
+			return b.history[i].Status != "refreshed" && b.history[i].Status != "recovered"
+		}
+	}
+	return false
+}
+
+// close ends every subscription
+func (b *eventBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// publishRefresh publishes the outcome of a refresh to /events. The first
+// success after failures is published as recovered.
+func (s *SpiffeJWT) publishRefresh(jwt *jwtsvid.SVID, err error) {
+	s.mu.RLock()
+	ev := refreshEvent{Time: time.Now(), Status: "refreshed", RefreshID: s.lastRefreshID}
+	s.mu.RUnlock()
+	var rejected *rejectedTokenError
+	switch {
+	case errors.As(err, &rejected):
+		ev.Status, ev.Error = "rejected", err.Error()
+	case err != nil:
+		ev.Status, ev.Error = "failed", err.Error()
+	default:
+		expiry := jwt.Expiry
+		ev.SpiffeID, ev.Expiry = jwt.ID.String(), &expiry
+		if s.events.failing() {
+			ev.Status = "recovered"
+		}
+	}
+	s.events.publish(ev)
+}
+
+// handleEvents streams refresh events as server-sent events until the client
+// goes away, falls behind, or the server shuts down. Events missed since the
+// Last-Event-ID of a reconnecting client are replayed from the history.
+func (s *SpiffeJWT) handleEvents(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	// The stream outlives the write timeout of the server, each write gets
+	// its own deadline instead
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// An invalid Last-Event-ID is treated as a new client
+	lastID, err := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+	ch, missed, err := s.events.subscribe(lastID, err == nil)
+	if err != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(int(eventKeepAlive.Seconds())))
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer s.events.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	send := func(format string, args ...any) bool {
+		_ = rc.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+	sendEvent := func(ev refreshEvent) bool {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			logrus.WithError(err).Error("unable to encode refresh event")
+			return true
+		}
+		kind := ev.Kind
+		if kind == "" {
+			kind = "refresh"
+		}
+		return send("id: %d\nevent: %s\ndata: %s\n\n", ev.ID, kind, data)
+	}
+	if !send(": connected\n\n") {
+		return
+	}
+	for _, ev := range missed {
+		if !sendEvent(ev) {
+			return
+		}
+	}
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if !send(": keep-alive\n\n") {
+				return
+			}
+		case ev, ok := <-ch:
+			if !ok || !sendEvent(ev) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Levels of the expiry warning, in increasing severity
+const (
+	expiryWarning  = "warning"
+	expiryCritical = "critical"
+)
+
+// expiryCheckInterval is how often the remaining lifetime of the current
+// JWT SVID is compared to the thresholds
+const expiryCheckInterval = 5 * time.Second
+
+// validateExpiryThresholds checks that the critical threshold is below the
+// warning one, when both are set
+func (s *SpiffeJWT) validateExpiryThresholds() error {
+	if s.ExpiryWarningThreshold < 0 || s.ExpiryCriticalThreshold < 0 {
+		return errors.New("expiry thresholds must not be negative")
+	}
+	if s.ExpiryWarningThreshold > 0 && s.ExpiryCriticalThreshold >= s.ExpiryWarningThreshold {
+		return fmt.Errorf("--expiry-critical-threshold (%s) must be below --expiry-warning-threshold (%s)",
+			s.ExpiryCriticalThreshold, s.ExpiryWarningThreshold)
+	}
+	return nil
+}
+
+// expiryLoop raises the expiry warning once the current JWT SVID gets within
+// a threshold of its expiry without having been refreshed. It stops when
+// ctx is done.
+func (s *SpiffeJWT) expiryLoop(ctx context.Context) {
+	ticker := time.NewTicker(expiryCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.checkExpiry()
+	}
+}
+
+// checkExpiry raises the expiry warning to the level matching the remaining
+// lifetime of the current JWT SVID. The level only escalates, it is cleared
+// by the next successful rotation.
+func (s *SpiffeJWT) checkExpiry() {
+	s.mu.Lock()
+	if s.current == nil {
+		s.mu.Unlock()
+		return
+	}
+	remaining := time.Until(s.current.Expiry)
+	level := ""
+	switch {
+	case s.ExpiryCriticalThreshold > 0 && remaining < s.ExpiryCriticalThreshold:
+		level = expiryCritical
+	case s.ExpiryWarningThreshold > 0 && remaining < s.ExpiryWarningThreshold:
+		level = expiryWarning
+	}
+	if level == "" || level == s.expiryAlert || s.expiryAlert == expiryCritical {
+		s.mu.Unlock()
+		return
+	}
+	s.expiryAlert = level
+	id, expiry := s.current.ID.String(), s.current.Expiry
+	s.mu.Unlock()
+
+	expiryAlerts.WithLabelValues(level).Set(1)
+	entry := logrus.WithFields(logrus.Fields{"threshold": level, "spiffe_id": id})
+	if level == expiryCritical {
+		entry.Errorf("JWT SVID expires in %s and has not been refreshed", remaining.Round(time.Second))
+	} else {
+		entry.Warnf("JWT SVID expires in %s and has not been refreshed", remaining.Round(time.Second))
+	}
+	s.events.publish(refreshEvent{Kind: "expiry_warning", Time: time.Now(), Status: level, SpiffeID: id, Expiry: &expiry})
+}
+
+// clearExpiryAlert clears the expiry warning after a successful rotation
+func (s *SpiffeJWT) clearExpiryAlert() {
+	s.mu.Lock()
+	level := s.expiryAlert
+	s.expiryAlert = ""
+	s.mu.Unlock()
+
+	if level != "" {
+		expiryAlerts.WithLabelValues(expiryWarning).Set(0)
+		expiryAlerts.WithLabelValues(expiryCritical).Set(0)
+		logrus.Infof("JWT SVID refreshed, expiry %s cleared", level)
+	}
+}
+
+// expiryWarnings returns the expiry warning reported by /readyz, if any
+func (s *SpiffeJWT) expiryWarnings() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.expiryAlert == "" {
+		return nil
+	}
+	return []string{"expiry_" + s.expiryAlert}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// watchTokenReads reports the opens of the token file by other processes
+// through fanotify until ctx is done. The directory is watched rather than
+// the file, whose inode changes with atomic writes. Without CAP_SYS_ADMIN it
+// only warns.
+func (s *SpiffeJWT) watchTokenReads(ctx context.Context) {
+	target, err := filepath.Abs(s.JWTFileName)
+	if err == nil {
+		var dir string
+		if dir, err = filepath.EvalSymlinks(filepath.Dir(target)); err == nil {
+			target = filepath.Join(dir, filepath.Base(target))
+		}
+	}
+	if err != nil {
+		logrus.WithError(err).Warn("unable to audit token file reads")
+		return
+	}
+
+	fd, err := unix.FanotifyInit(unix.FAN_CLASS_NOTIF|unix.FAN_CLOEXEC|unix.FAN_NONBLOCK, unix.O_RDONLY|unix.O_LARGEFILE|unix.O_CLOEXEC)
+	if err != nil {
+		logrus.WithError(err).Warn("unable to audit token file reads, fanotify requires CAP_SYS_ADMIN")
+		return
+	}
+	events := os.NewFile(uintptr(fd), "fanotify")
+	if err := unix.FanotifyMark(fd, unix.FAN_MARK_ADD, unix.FAN_OPEN|unix.FAN_EVENT_ON_CHILD, unix.AT_FDCWD, filepath.Dir(target)); err != nil {
+		events.Close()
+		logrus.WithError(err).Warnf("unable to audit token file reads in %s", filepath.Dir(target))
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		events.Close()
+	}()
+	logrus.Infof("Auditing reads of %s", target)
+
+	buf := make([]byte, 4096)
+	size := binary.Size(unix.FanotifyEventMetadata{})
+	for {
+		n, err := events.Read(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				logrus.WithError(err).Warn("Token file read audit stopped")
+			}
+			return
+		}
+		for off := 0; off+size <= n; {
+			var meta unix.FanotifyEventMetadata
+			if err := binary.Read(bytes.NewReader(buf[off:off+size]), binary.NativeEndian, &meta); err != nil || int(meta.Event_len) < size {
+				break
+			}
+			off += int(meta.Event_len)
+			// Overflows carry no file
+			if meta.Fd < 0 {
+				continue
+			}
+			path, _ := os.Readlink("/proc/self/fd/" + strconv.Itoa(int(meta.Fd)))
+			unix.Close(int(meta.Fd))
+			if path != target || int(meta.Pid) == os.Getpid() {
+				continue
+			}
+			exe, uid := processInfo(int(meta.Pid))
+			s.recordTokenRead(int(meta.Pid), exe, uid)
+		}
+	}
+}
+
+// processInfo returns the executable and owner of a process, unknown and -1
+// when it already exited
+func processInfo(pid int) (string, int) {
+	dir := "/proc/" + strconv.Itoa(pid)
+	exe, err := os.Readlink(dir + "/exe")
+	if err != nil {
+		exe = "unknown"
+	}
+	uid := -1
+	if info, err := os.Stat(dir); err == nil {
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			uid = int(st.Uid)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		logrus.WithError(err).Debugf("unable to read the owner of process %d", pid)
+	}
+	return exe, uid
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+// watchTokenReads is not supported outside of Linux
+func (s *SpiffeJWT) watchTokenReads(ctx context.Context) {
+	logrus.Warn("Token file read audit is only supported on Linux")
+}
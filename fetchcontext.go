@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+)
+
+// extraSelectorsField is the name of the attestation hints of jwtsvid.Params
+// in go-spiffe versions that support them
+const extraSelectorsField = "ExtraSelectors"
+
+// parseFetchUserContext parses --fetch-user-context into the selectors sent
+// with every fetch. Every entry is selectors=<type>:<value>[,...].
+func (s *SpiffeJWT) parseFetchUserContext() error {
+	for _, entry := range s.FetchUserContext {
+		key, value, ok := strings.Cut(entry, "=")
+		if !ok || key != "selectors" {
+			return fmt.Errorf("invalid --fetch-user-context %q, expected selectors=<type>:<value>", entry)
+		}
+		for _, selector := range strings.Split(value, ",") {
+			typ, val, ok := strings.Cut(strings.TrimSpace(selector), ":")
+			if !ok || typ == "" || val == "" {
+				return fmt.Errorf("invalid selector %q in --fetch-user-context, expected <type>:<value>", selector)
+			}
+			s.fetchSelectors = append(s.fetchSelectors, typ+":"+val)
+		}
+	}
+	if len(s.fetchSelectors) > 0 && !setExtraSelectors(&jwtsvid.Params{}, s.fetchSelectors) {
+		logrus.Warn("The go-spiffe SDK does not support attestation hints in jwtsvid.Params, --fetch-user-context is ignored")
+		s.fetchSelectors = nil
+	}
+	return nil
+}
+
+// setExtraSelectors sets the attestation hints of params through reflection,
+// so that the SDK can be upgraded independently. Both a list of strings and
+// a list of structs with Type and Value fields are supported. It reports
+// whether the SDK has the field.
+func setExtraSelectors(params *jwtsvid.Params, selectors []string) bool {
+	field := reflect.ValueOf(params).Elem().FieldByName(extraSelectorsField)
+	if !field.IsValid() || field.Kind() != reflect.Slice {
+		return false
+	}
+	elem := field.Type().Elem()
+	list := reflect.MakeSlice(field.Type(), 0, len(selectors))
+	for _, selector := range selectors {
+		typ, value, _ := strings.Cut(selector, ":")
+		switch {
+		case elem.Kind() == reflect.String:
+			list = reflect.Append(list, reflect.ValueOf(selector).Convert(elem))
+		case elem.Kind() == reflect.Struct:
+			v := reflect.New(elem).Elem()
+			t, val := v.FieldByName("Type"), v.FieldByName("Value")
+			if t.Kind() != reflect.String || val.Kind() != reflect.String {
+				return false
+			}
+			t.SetString(typ)
+			val.SetString(value)
+			list = reflect.Append(list, v)
+		default:
+			return false
+		}
+	}
+	field.Set(list)
+	return true
+}
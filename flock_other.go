@@ -0,0 +1,18 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// tryLockFile is not supported outside of Linux
+func tryLockFile(f *os.File) (bool, error) {
+	return false, errors.ErrUnsupported
+}
+
+// unlockFile is not supported outside of Linux
+func unlockFile(f *os.File) error {
+	return errors.ErrUnsupported
+}
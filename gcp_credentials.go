@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+)
+
+// Google API endpoints of the token exchange
+const (
+	gcpSTSEndpoint            = "https://sts.googleapis.com/v1/token"
+	gcpIAMCredentialsEndpoint = "https://iamcredentials.googleapis.com/v1"
+)
+
+// gcpCredentials exchanges JWT SVIDs with Google STS through a workload
+// identity pool provider for access tokens, optionally impersonating a
+// service account
+type gcpCredentials struct {
+	workloadIdentityProvider string
+	audience                 string
+	serviceAccount           string
+	client                   *http.Client
+	fetchJWT                 func(ctx context.Context, audience string) (*jwtsvid.SVID, error)
+
+	stsEndpoint            string
+	iamCredentialsEndpoint string
+
+	mu          sync.Mutex
+	accessToken *secretBuffer
+	expiry      time.Time
+}
+
+// gcpAPIError is an error response of a Google API
+type gcpAPIError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+func (e *gcpAPIError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Code, e.Status, e.Message)
+}
+
+// retryable reports whether the call may succeed if retried
+func (e *gcpAPIError) retryable() bool {
+	return e.Code == http.StatusTooManyRequests || e.Code >= 500
+}
+
+// newGCPCredentials returns credentials of the workload identity pool
+// provider, the audience defaults to https: followed by the provider name
+func (s *SpiffeJWT) newGCPCredentials(client *http.Client, provider, audience, serviceAccount string) *gcpCredentials {
+	if audience == "" {
+		audience = "https:" + provider
+	}
+	return &gcpCredentials{
+		workloadIdentityProvider: provider,
+		audience:                 audience,
+		serviceAccount:           serviceAccount,
+		client:                   client,
+		fetchJWT:                 s.fetchJWTSVIDForAudience,
+		stsEndpoint:              gcpSTSEndpoint,
+		iamCredentialsEndpoint:   gcpIAMCredentialsEndpoint,
+	}
+}
+
+// token returns a cached access token, exchanging a new JWT SVID when it is
+// about to expire
+func (c *gcpCredentials) token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.accessToken != nil && time.Until(c.expiry) > time.Minute {
+		return c.accessToken.String(), nil
+	}
+
+	jwt, err := c.fetchJWT(ctx, c.audience)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch JWT SVID for Google STS: %w", err)
+	}
+
+	var sts struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	err = gcpDo(ctx, c.client, http.MethodPost, c.stsEndpoint, "", map[string]string{
+		"grantType":          "urn:ietf:params:oauth:grant-type:token-exchange",
+		"audience":           c.workloadIdentityProvider,
+		"scope":              "https://www.googleapis.com/auth/cloud-platform",
+		"requestedTokenType": "urn:ietf:params:oauth:token-type:access_token",
+		"subjectToken":       jwt.Marshal(),
+		"subjectTokenType":   "urn:ietf:params:oauth:token-type:jwt",
+	}, &sts)
+	if err != nil {
+		return "", fmt.Errorf("failed to exchange JWT SVID with Google STS: %w", err)
+	}
+	token, expiry := sts.AccessToken, time.Now().Add(time.Duration(sts.ExpiresIn)*time.Second)
+
+	if c.serviceAccount != "" {
+		var sa struct {
+			AccessToken string    `json:"accessToken"`
+			ExpireTime  time.Time `json:"expireTime"`
+		}
+		err = gcpDo(ctx, c.client, http.MethodPost,
+			fmt.Sprintf("%s/projects/-/serviceAccounts/%s:generateAccessToken", c.iamCredentialsEndpoint, url.PathEscape(c.serviceAccount)),
+			token, map[string]any{"scope": []string{"https://www.googleapis.com/auth/cloud-platform"}}, &sa)
+		if err != nil {
+			return "", fmt.Errorf("failed to impersonate service account %s: %w", c.serviceAccount, err)
+		}
+		token, expiry = sa.AccessToken, sa.ExpireTime
+	}
+
+	c.accessToken.release()
+	c.accessToken, c.expiry = newSecretBuffer([]byte(token)), expiry
+	return token, nil
+}
+
+// gcpDo sends a JSON request to a Google API and decodes the JSON response into out
+func gcpDo(ctx context.Context, client *http.Client, method, endpoint, token string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return gcpSend(client, req, out)
+}
+
+// gcpSend sends a request to a Google API and decodes the JSON response into out
+func gcpSend(client *http.Client, req *http.Request, out any) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Error *gcpAPIError `json:"error"`
+		}
+		if json.Unmarshal(data, &e) != nil || e.Error == nil {
+			e.Error = &gcpAPIError{Message: string(data)}
+		}
+		e.Error.Code = resp.StatusCode
+		return e.Error
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+)
+
+// gcpSecretManagerEndpoint is the Secret Manager API endpoint
+const gcpSecretManagerEndpoint = "https://secretmanager.googleapis.com/v1"
+
+// GCPSecretManagerConfig configures the GCP Secret Manager output. Access
+// tokens come from exchanging a JWT SVID with Google STS through a workload
+// identity pool provider, optionally impersonating a service account, so
+// the whole chain is SPIFFE-rooted.
+type GCPSecretManagerConfig struct {
+	Project                  string `env:"PROJECT" help:"Project of the secret, enables the GCP Secret Manager output."`
+	Secret                   string `env:"SECRET" help:"Name of the secret to add a version to on every rotation."`
+	WorkloadIdentityProvider string `env:"WORKLOAD_IDENTITY_PROVIDER" help:"Full resource name of the workload identity pool provider, //iam.googleapis.com/projects/<number>/locations/global/workloadIdentityPools/<pool>/providers/<provider>."`
+	Audience                 string `env:"AUDIENCE" help:"Audience of the JWT SVID exchanged with Google STS, https: followed by the provider name if unset."`
+	ServiceAccount           string `env:"SERVICE_ACCOUNT" help:"Email of the service account to impersonate, the federated identity is used directly if unset."`
+	KeepVersions             int    `env:"KEEP_VERSIONS" help:"Number of enabled versions to keep, older ones are pruned. 0 keeps all."`
+	PruneAction              string `env:"PRUNE_ACTION" help:"How older versions are pruned: disable or destroy." enum:"disable,destroy" default:"disable"`
+	MaxAttempts              int    `env:"MAX_ATTEMPTS" help:"Maximum number of attempts per version, retrying quota and server errors." default:"3"`
+}
+
+// gcpSecretManagerSink adds JWT SVIDs as new versions of a secret
+type gcpSecretManagerSink struct {
+	config      GCPSecretManagerConfig
+	client      *http.Client
+	credentials *gcpCredentials
+
+	secretManagerEndpoint string
+}
+
+// newGCPSecretManagerSink validates the configuration of the GCP Secret Manager output
+func (s *SpiffeJWT) newGCPSecretManagerSink(c GCPSecretManagerConfig) (*gcpSecretManagerSink, error) {
+	if c.Secret == "" {
+		return nil, errors.New("--gcp-secret-manager-secret is required")
+	}
+	if c.WorkloadIdentityProvider == "" {
+		return nil, errors.New("--gcp-secret-manager-workload-identity-provider is required")
+	}
+	if c.MaxAttempts < 1 {
+		return nil, errors.New("max attempts must be at least 1")
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	return &gcpSecretManagerSink{
+		config:                c,
+		client:                client,
+		credentials:           s.newGCPCredentials(client, c.WorkloadIdentityProvider, c.Audience, c.ServiceAccount),
+		secretManagerEndpoint: gcpSecretManagerEndpoint,
+	}, nil
+}
+
+func (g *gcpSecretManagerSink) Name() string {
+	return fmt.Sprintf("gcp-secret-manager:projects/%s/secrets/%s", g.config.Project, g.config.Secret)
+}
+
+// Write adds the JWT SVID as a new version of the secret, retrying quota and
+// server errors on its own so that it does not depend on the other outputs,
+// then prunes older versions
+func (g *gcpSecretManagerSink) Write(ctx context.Context, jwt *jwtsvid.SVID) error {
+	data := []byte(jwt.Marshal())
+	body := map[string]any{"payload": map[string]string{
+		"data":       base64.StdEncoding.EncodeToString(data),
+		"dataCrc32c": strconv.FormatUint(uint64(crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))), 10),
+	}}
+
+	var err error
+	for attempt := 1; attempt <= g.config.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			backoff := time.Duration(1<<(attempt-2)) * time.Second
+			logrus.WithContext(ctx).WithError(err).Warnf("Retrying %s in %s", g.Name(), backoff)
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("failed to add secret version: %w", ctx.Err())
+			case <-time.After(backoff):
+			}
+		}
+
+		err = g.call(ctx, http.MethodPost, g.secretURL(":addVersion"), body, nil)
+		var apiErr *gcpAPIError
+		if err == nil || (errors.As(err, &apiErr) && !apiErr.retryable()) {
+			break
+		}
+	}
+	if err != nil {
+		return g.explain(err)
+	}
+
+	if g.config.KeepVersions > 0 {
+		if err := g.prune(ctx); err != nil {
+			logrus.WithContext(ctx).WithError(g.explain(err)).Warnf("unable to prune versions of %s", g.Name())
+		}
+	}
+	return nil
+}
+
+// prune disables or destroys the enabled versions beyond the newest KeepVersions
+func (g *gcpSecretManagerSink) prune(ctx context.Context) error {
+	var list struct {
+		Versions []struct {
+			Name       string    `json:"name"`
+			CreateTime time.Time `json:"createTime"`
+		} `json:"versions"`
+	}
+	if err := g.call(ctx, http.MethodGet, g.secretURL("/versions?filter=state:ENABLED&pageSize=100"), nil, &list); err != nil {
+		return err
+	}
+	if len(list.Versions) <= g.config.KeepVersions {
+		return nil
+	}
+
+	// Versions are listed newest first
+	for _, v := range list.Versions[g.config.KeepVersions:] {
+		if err := g.call(ctx, http.MethodPost, g.secretManagerEndpoint+"/"+v.Name+":"+g.config.PruneAction, map[string]any{}, nil); err != nil {
+			return err
+		}
+		logrus.WithContext(ctx).Infof("Secret version %s pruned (%s)", v.Name, g.config.PruneAction)
+	}
+	return nil
+}
+
+// explain turns permission and quota errors into messages naming the secret
+// and the identity used
+func (g *gcpSecretManagerSink) explain(err error) error {
+	var apiErr *gcpAPIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+
+	identity := "the federated identity of " + g.config.WorkloadIdentityProvider
+	if g.config.ServiceAccount != "" {
+		identity = "service account " + g.config.ServiceAccount
+	}
+	switch apiErr.Code {
+	case http.StatusForbidden:
+		return fmt.Errorf("permission denied on secret projects/%s/secrets/%s for %s, it needs roles/secretmanager.secretVersionAdder (and secretVersionManager to prune): %w",
+			g.config.Project, g.config.Secret, identity, err)
+	case http.StatusNotFound:
+		return fmt.Errorf("secret projects/%s/secrets/%s not found or not visible to %s: %w", g.config.Project, g.config.Secret, identity, err)
+	case http.StatusTooManyRequests:
+		return fmt.Errorf("quota exhausted on secret projects/%s/secrets/%s for %s: %w", g.config.Project, g.config.Secret, identity, err)
+	}
+	return err
+}
+
+// secretURL returns the URL of the secret followed by suffix
+func (g *gcpSecretManagerSink) secretURL(suffix string) string {
+	return fmt.Sprintf("%s/projects/%s/secrets/%s%s", g.secretManagerEndpoint,
+		url.PathEscape(g.config.Project), url.PathEscape(g.config.Secret), suffix)
+}
+
+// call makes an authenticated call to the Secret Manager API
+func (g *gcpSecretManagerSink) call(ctx context.Context, method, endpoint string, in, out any) error {
+	token, err := g.credentials.token(ctx)
+	if err != nil {
+		return err
+	}
+	return gcpDo(ctx, g.client, method, endpoint, token, in, out)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	callbackv1 "github.com/CentML/spiffe-jwt/proto/callback/v1"
+)
+
+// grpcCallbackRetryPolicy retries transient failures of PushToken through the
+// gRPC client's built-in retry support
+const grpcCallbackRetryPolicy = `{"methodConfig": [{
+	"name": [{"service": "spiffejwt.callback.v1.TokenCallback"}],
+	"retryPolicy": {
+		"maxAttempts": %d,
+		"initialBackoff": "0.5s",
+		"maxBackoff": "5s",
+		"backoffMultiplier": 2,
+		"retryableStatusCodes": ["UNAVAILABLE", "RESOURCE_EXHAUSTED", "ABORTED"]
+	}
+}]}`
+
+// GRPCCallbackConfig configures pushing refreshed JWT SVIDs to a gRPC service
+// implementing spiffejwt.callback.v1.TokenCallback.
+type GRPCCallbackConfig struct {
+	Address     string `env:"ADDRESS" help:"Address (host:port) of the gRPC callback service, enables the gRPC output."`
+	CAFile      string `env:"CA_FILE" help:"CA bundle used to verify the callback service, system roots if unset." type:"existingfile"`
+	CertFile    string `env:"CERT_FILE" help:"Client certificate for mutual TLS." type:"existingfile"`
+	KeyFile     string `env:"KEY_FILE" help:"Client key for mutual TLS." type:"existingfile"`
+	ServerName  string `env:"SERVER_NAME" help:"Override the server name verified in the callback service certificate."`
+	Insecure    bool   `env:"INSECURE" help:"Connect to the callback service without TLS."`
+	MaxAttempts int    `env:"MAX_ATTEMPTS" help:"Maximum number of attempts per push (1-5)." default:"3"`
+}
+
+// grpcCallbackSink pushes JWT SVIDs to a gRPC callback service
+type grpcCallbackSink struct {
+	address string
+	conn    *grpc.ClientConn
+	client  callbackv1.TokenCallbackClient
+}
+
+// newGRPCCallbackSink creates a client for the callback service. The
+// connection is established lazily on the first push.
+func newGRPCCallbackSink(c GRPCCallbackConfig) (*grpcCallbackSink, error) {
+	if c.MaxAttempts < 1 || c.MaxAttempts > 5 {
+		return nil, errors.New("max attempts must be between 1 and 5")
+	}
+
+	creds := insecure.NewCredentials()
+	if !c.Insecure {
+		tlsConfig, err := c.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if c.MaxAttempts > 1 {
+		opts = append(opts, grpc.WithDefaultServiceConfig(fmt.Sprintf(grpcCallbackRetryPolicy, c.MaxAttempts)))
+	}
+
+	conn, err := grpc.NewClient(c.Address, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gRPC client: %w", err)
+	}
+
+	return &grpcCallbackSink{
+		address: c.Address,
+		conn:    conn,
+		client:  callbackv1.NewTokenCallbackClient(conn),
+	}, nil
+}
+
+// tlsConfig builds the client TLS configuration
+func (c GRPCCallbackConfig) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: c.ServerName,
+	}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.CAFile)
+		}
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+func (s *grpcCallbackSink) Name() string {
+	return "grpc://" + s.address
+}
+
+// Write pushes the JWT SVID to the callback service
+func (s *grpcCallbackSink) Write(ctx context.Context, jwt *jwtsvid.SVID) error {
+	_, err := s.client.PushToken(ctx, &callbackv1.PushTokenRequest{
+		Token:     jwt.Marshal(),
+		SpiffeId:  jwt.ID.String(),
+		Audience:  jwt.Audience,
+		ExpiresAt: jwt.Expiry.Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to push JWT SVID: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+// tlsVersions maps the accepted --health-tls-min-version values
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// readiness is the body of /readyz
+type readiness struct {
+	Ready    bool     `json:"ready"`
+	Status   string   `json:"status,omitempty"`
+	Reason   string   `json:"reason,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+	NotReady []string `json:"not_ready,omitempty"`
+}
+
+// daemonStatus is the body of /status
+type daemonStatus struct {
+	Started     bool                  `json:"started"`
+	Draining    bool                  `json:"draining"`
+	Paused      bool                  `json:"paused,omitempty"`
+	SpiffeID    string                `json:"spiffe_id,omitempty"`
+	Audience    []string              `json:"audience,omitempty"`
+	Expiry      *time.Time            `json:"expiry,omitempty"`
+	LastRefresh *time.Time            `json:"last_refresh,omitempty"`
+	RefreshID   string                `json:"last_refresh_id,omitempty"`
+	NextCron    *time.Time            `json:"next_cron_refresh,omitempty"`
+	Rotations   int                   `json:"rotations"`
+	Failures    map[string]int        `json:"failures"`
+	Rejections  int                   `json:"rejections,omitempty"`
+	Sinks       map[string]sinkStatus `json:"sinks,omitempty"`
+	Tokens      []tokenState          `json:"tokens,omitempty"`
+}
+
+// startHealthServer runs HTTP server for health checks until ctx is done,
+// then shuts it down gracefully
+func (s *SpiffeJWT) startHealthServer(ctx context.Context) {
+	mux := http.NewServeMux()
+	if s.MetricsPort == "" || s.MetricsPort == s.HealthPort {
+		logrus.Infof("Serving /metrics on the health server port %s", s.HealthPort)
+		mux.Handle("/metrics", s.metricsHandler())
+	} else {
+		logrus.Infof("Serving /metrics on port %s, separately from the health server on port %s", s.MetricsPort, s.HealthPort)
+		go s.startMetricsServer(ctx)
+	}
+	mux.HandleFunc("/started", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&s.started) == 1 {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	mux.HandleFunc("/readyz", s.handleReadyz)
+	mux.HandleFunc("GET /readyz/{name}", s.handleTokenReadyz)
+	mux.HandleFunc("GET /status", s.handleStatus)
+	mux.HandleFunc("POST /drain", s.requireAdmin(s.handleDrain))
+	if s.IntrospectionAudience != "" {
+		mux.HandleFunc("POST /introspect", s.handleIntrospect)
+	}
+
+	// Event streams are long-lived, they are exempt from --health-timeout
+	root := http.NewServeMux()
+	root.Handle("/", withTimeout(mux, s.HealthTimeout))
+	root.HandleFunc("GET /events", s.handleEvents)
+
+	server := &http.Server{
+		Addr:              ":" + s.HealthPort,
+		Handler:           rejectOverLimit(root),
+		ReadTimeout:       5 * time.Second,
+		ReadHeaderTimeout: s.HealthReadHeaderTimeout,
+		WriteTimeout:      10 * time.Second,
+		ConnContext:       connLimitContext,
+	}
+
+	ln, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		logrus.WithError(err).Fatal("Health server failed")
+	}
+	if s.HealthMaxConnections > 0 {
+		ln = &limitListener{Listener: ln, max: int64(s.HealthMaxConnections)}
+	}
+	server.RegisterOnShutdown(s.events.close)
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logrus.WithError(err).Warn("Health server did not shut down gracefully")
+		}
+	}()
+
+	if s.HealthTLSCertFile != "" {
+		// The certificate is reloaded as it rotates, without a restart
+		var certs *certReloader
+		if certs, err = newCertReloader(s.HealthTLSCertFile, s.HealthTLSKeyFile); err != nil {
+			logrus.WithError(err).Fatal("Health server failed")
+		}
+		server.TLSConfig = &tls.Config{
+			MinVersion:     tlsVersions[s.HealthTLSMinVersion],
+			GetCertificate: certs.getCertificate,
+		}
+		if s.introspectClientCAs != nil {
+			// Client certificates authenticate callers of /introspect
+			server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			server.TLSConfig.ClientCAs = s.introspectClientCAs
+		}
+		logrus.Infof("Starting health server on port %s with TLS %s+", s.HealthPort, s.HealthTLSMinVersion)
+		err = server.ServeTLS(ln, "", "")
+	} else {
+		logrus.Infof("Starting health server on port %s", s.HealthPort)
+		err = server.Serve(ln)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		logrus.WithError(err).Fatal("Health server failed")
+	}
+}
+
+// metricsHandler serves the Prometheus metrics, with the static labels of
+// --label when set
+func (s *SpiffeJWT) metricsHandler() http.Handler {
+	if s.metricLabels == nil {
+		return promhttp.Handler()
+	}
+	gatherer := newLabelGatherer(prometheus.DefaultGatherer, s.metricLabels)
+	return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+}
+
+// startMetricsServer runs the HTTP server of --metrics-port until ctx is
+// done, then shuts it down gracefully
+func (s *SpiffeJWT) startMetricsServer(ctx context.Context) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.metricsHandler())
+	server := &http.Server{
+		Addr:              ":" + s.MetricsPort,
+		Handler:           withTimeout(mux, s.HealthTimeout),
+		ReadTimeout:       5 * time.Second,
+		ReadHeaderTimeout: s.HealthReadHeaderTimeout,
+		WriteTimeout:      10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logrus.WithError(err).Warn("Metrics server did not shut down gracefully")
+		}
+	}()
+
+	logrus.Infof("Starting metrics server on port %s", s.MetricsPort)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logrus.WithError(err).Fatal("Metrics server failed")
+	}
+}
+
+// limitListener counts open connections and flags the ones accepted over
+// max. They are still served, but only with a 503, so that monitoring gets
+// a clear answer instead of hanging in the accept queue.
+type limitListener struct {
+	net.Listener
+	max  int64
+	open int64
+}
+
+// limitConn is a connection accepted by a limitListener
+type limitConn struct {
+	net.Conn
+	overLimit bool
+	release   func()
+}
+
+// overLimitKey is the context key marking requests on connections over the limit
+type overLimitKey struct{}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	open := atomic.AddInt64(&l.open, 1)
+	var once sync.Once
+	return &limitConn{
+		Conn:      conn,
+		overLimit: open > l.max,
+		release:   func() { once.Do(func() { atomic.AddInt64(&l.open, -1) }) },
+	}, nil
+}
+
+func (c *limitConn) Close() error {
+	c.release()
+	return c.Conn.Close()
+}
+
+// connLimitContext marks the requests of connections accepted over the limit
+func connLimitContext(ctx context.Context, c net.Conn) context.Context {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	if lc, ok := c.(*limitConn); ok && lc.overLimit {
+		return context.WithValue(ctx, overLimitKey{}, true)
+	}
+	return ctx
+}
+
+// rejectOverLimit answers requests on connections over the limit with a 503
+// and closes them
+func rejectOverLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Context().Value(overLimitKey{}) != nil {
+			healthRejectedConnections.Inc()
+			w.Header().Set("Connection", "close")
+			http.Error(w, "too many connections", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bufferedResponse holds a response until the handler is done with it
+type bufferedResponse struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header         { return b.header }
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+func (b *bufferedResponse) WriteHeader(code int)        { b.code = code }
+
+// withTimeout answers with a 503 when next does not complete within
+// timeout. The request context of next is cancelled at the deadline, and
+// whatever it writes afterwards is discarded.
+func withTimeout(next http.Handler, timeout time.Duration) http.Handler {
+	if timeout <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		buf := &bufferedResponse{header: make(http.Header), code: http.StatusOK}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			next.ServeHTTP(buf, r.WithContext(ctx))
+		}()
+
+		select {
+		case <-done:
+			for k, v := range buf.header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(buf.code)
+			if _, err := w.Write(buf.body.Bytes()); err != nil {
+				logrus.WithError(err).Debug("unable to write response")
+			}
+		case <-ctx.Done():
+			logrus.Warnf("Health handler %s timed out after %s", r.URL.Path, timeout)
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "timeout"})
+		}
+	})
+}
+
+// handleReadyz reports whether consumers should read the token from this
+// instance. It is withdrawn while draining, even though refreshes continue,
+// and when the JWT SVID expired. With --token, it also fails when
+// --readiness-policy is not met. An expiry warning is reported in the body
+// without failing it.
+func (s *SpiffeJWT) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	code, body := s.readiness()
+	writeJSON(w, code, body)
+}
+
+// readiness returns the status code and body of /readyz
+func (s *SpiffeJWT) readiness() (int, readiness) {
+	var notReady []string
+	if len(s.Tokens) > 0 {
+		notReady = s.tokensNotReady()
+	}
+	switch {
+	case atomic.LoadInt32(&s.draining) == 1:
+		return http.StatusServiceUnavailable, readiness{Reason: "draining"}
+	case atomic.LoadInt32(&s.started) == 0:
+		return http.StatusServiceUnavailable, readiness{Reason: "starting"}
+	case len(notReady) > 0 && s.tokensExpired(notReady):
+		return s.expired(readiness{Reason: "tokens expired", NotReady: notReady})
+	case len(notReady) > 0:
+		return http.StatusServiceUnavailable, readiness{Reason: "tokens not ready", NotReady: notReady}
+	case s.currentExpired():
+		return s.expired(readiness{Reason: "expired"})
+	default:
+		return http.StatusOK, readiness{Ready: true, Warnings: s.expiryWarnings()}
+	}
+}
+
+// currentExpired reports whether the current JWT SVID expired
+func (s *SpiffeJWT) currentExpired() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current != nil && !time.Now().Before(s.current.Expiry)
+}
+
+// expired returns the status code of --http-response-code-on-expired and
+// the body of a readiness check failing on an expired JWT SVID
+func (s *SpiffeJWT) expired(body readiness) (int, readiness) {
+	body.Status = "expired"
+	return s.ExpiredResponseCode, body
+}
+
+// handleStatus reports the state of the daemon and its current token
+func (s *SpiffeJWT) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.status())
+}
+
+// status returns a snapshot of the state of the daemon
+func (s *SpiffeJWT) status() daemonStatus {
+	st := daemonStatus{
+		Started:  atomic.LoadInt32(&s.started) == 1,
+		Draining: atomic.LoadInt32(&s.draining) == 1,
+		Paused:   s.paused(),
+	}
+	st.Rotations, st.Failures = s.stats.snapshot()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	st.Rejections = s.rejections
+	st.RefreshID = s.lastRefreshID
+	if !s.nextCronRefresh.IsZero() {
+		next := s.nextCronRefresh
+		st.NextCron = &next
+	}
+	if len(s.sinkHealth) > 0 {
+		st.Sinks = make(map[string]sinkStatus, len(s.sinkHealth))
+		for name, sink := range s.sinkHealth {
+			st.Sinks[name] = sink
+		}
+	}
+	if len(s.Tokens) > 0 {
+		st.Tokens = s.tokenStatuses()
+	}
+	if s.current != nil {
+		expiry, lastRefresh := s.current.Expiry, s.lastRefresh
+		st.SpiffeID = s.current.ID.String()
+		st.Audience = s.current.Audience
+		st.Expiry = &expiry
+		st.LastRefresh = &lastRefresh
+	}
+	return st
+}
+
+// handleDrain withdraws readiness ahead of a shutdown
+func (s *SpiffeJWT) handleDrain(w http.ResponseWriter, r *http.Request) {
+	s.drain("POST /drain")
+	writeJSON(w, http.StatusOK, readiness{Reason: "draining"})
+}
+
+// drain makes /readyz fail so consumers move to another instance, while the
+// refresh loop keeps the token fresh until shutdown
+func (s *SpiffeJWT) drain(reason string) {
+	if atomic.CompareAndSwapInt32(&s.draining, 0, 1) {
+		logrus.Infof("Draining, requested by %s", reason)
+	}
+}
+
+// requireAdmin restricts a handler to requests bearing the admin token
+func (s *SpiffeJWT) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.adminToken == "" {
+			http.Error(w, "admin endpoints are disabled, set --admin-token-file", http.StatusForbidden)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.WithError(err).Debug("unable to write response")
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+)
+
+// checkIdentityChange compares the SPIFFE ID of a newly fetched JWT SVID to
+// the previously written one. A change is only an error with
+// --on-identity-change=fatal, in which case the new token is not written.
+func (s *SpiffeJWT) checkIdentityChange(ctx context.Context, jwt *jwtsvid.SVID) (previous string, err error) {
+	s.mu.RLock()
+	current := s.current
+	s.mu.RUnlock()
+	if current == nil || current.ID == jwt.ID {
+		return "", nil
+	}
+
+	previous = current.ID.String()
+	identityChanges.Inc()
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"previous": previous,
+		"new":      jwt.ID.String(),
+	}).Warn("SPIFFE ID of the JWT SVID changed")
+
+	if s.OnIdentityChange == "fatal" {
+		return "", fmt.Errorf("SPIFFE ID changed from %s to %s", previous, jwt.ID)
+	}
+	return previous, nil
+}
+
+// runIdentityChangeHook runs --identity-change-hook once the token with the
+// new SPIFFE ID has been written
+func (s *SpiffeJWT) runIdentityChangeHook(ctx context.Context, previous string, jwt *jwtsvid.SVID) {
+	cmd := exec.Command("sh", "-c", s.IdentityChangeHook)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"SPIFFE_JWT_FILE="+s.JWTFileName,
+		"SPIFFE_JWT_PREVIOUS_ID="+previous,
+		"SPIFFE_JWT_NEW_ID="+jwt.ID.String(),
+		"SPIFFE_JWT_REFRESH_ID="+refreshID(ctx),
+	)
+	if err := cmd.Run(); err != nil {
+		logrus.WithContext(ctx).WithError(err).Warn("identity change hook failed")
+	}
+}
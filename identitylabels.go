@@ -0,0 +1,38 @@
+package main
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+)
+
+// identityLabelNames are the labels of the rotation metrics derived from the
+// SPIFFE ID. Full SPIFFE IDs are only reported by /status, as labels they
+// would be unbounded.
+var identityLabelNames = []string{"trust_domain", "workload"}
+
+// identityLabels returns the trust domain and the first path segment of id,
+// or empty values with --metrics-identity-labels=false
+func (s *SpiffeJWT) identityLabels(id spiffeid.ID) []string {
+	if !s.MetricsIdentityLabels {
+		return []string{"", ""}
+	}
+	workload, _, _ := strings.Cut(strings.TrimPrefix(id.Path(), "/"), "/")
+	return []string{id.TrustDomain().Name(), workload}
+}
+
+// recordExpiry reports the expiry of the current JWT SVID, removing the
+// series of the previous identity when it changed
+func (s *SpiffeJWT) recordExpiry(jwt *jwtsvid.SVID) {
+	labels := s.identityLabels(jwt.ID)
+	s.mu.Lock()
+	previous := s.expiryLabels
+	s.expiryLabels = labels
+	s.mu.Unlock()
+	if previous != nil && !slices.Equal(previous, labels) {
+		tokenExpiry.DeleteLabelValues(previous...)
+	}
+	tokenExpiry.WithLabelValues(labels...).Set(float64(jwt.Expiry.Unix()))
+}
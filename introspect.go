@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+)
+
+// maxIntrospectBody bounds the form of an /introspect request
+const maxIntrospectBody = 64 << 10
+
+// introspection is the RFC 7662 response of /introspect. Inactive tokens
+// only carry active, without saying why.
+type introspection struct {
+	Active bool     `json:"active"`
+	Sub    string   `json:"sub,omitempty"`
+	Aud    []string `json:"aud,omitempty"`
+	Exp    int64    `json:"exp,omitempty"`
+	Iss    string   `json:"iss,omitempty"`
+}
+
+// setupIntrospection loads the client credentials of /introspect and
+// connects to the Workload API for the trust bundles tokens are validated
+// against. The bundles are kept up to date until the source is closed.
+func (s *SpiffeJWT) setupIntrospection() error {
+	if !s.DaemonMode {
+		return errors.New("--introspection-audience is only supported in daemon mode")
+	}
+	if s.IntrospectionTokenFile == "" && s.IntrospectClientCAFile == "" {
+		return errors.New("--introspection-audience requires client authentication, set --introspection-token-file or --introspection-client-ca-file")
+	}
+
+	if s.IntrospectionTokenFile != "" {
+		token, err := os.ReadFile(s.IntrospectionTokenFile)
+		if err != nil {
+			return fmt.Errorf("failed to read introspection token file: %w", err)
+		}
+		s.introspectToken = strings.TrimSpace(string(token))
+		if s.introspectToken == "" {
+			return fmt.Errorf("introspection token file %s is empty", s.IntrospectionTokenFile)
+		}
+	}
+	if s.IntrospectClientCAFile != "" {
+		if s.HealthTLSCertFile == "" {
+			return errors.New("--introspection-client-ca-file requires --health-tls-cert-file")
+		}
+		pem, err := os.ReadFile(s.IntrospectClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read introspection client CA file: %w", err)
+		}
+		s.introspectClientCAs = x509.NewCertPool()
+		if !s.introspectClientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", s.IntrospectClientCAFile)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.FetchTimeout)
+	defer cancel()
+	// The JWT source only watches the JWT bundles, unlike a bundle source
+	bundles, err := s.newJWTSource(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch the trust bundles of /introspect: %w", err)
+	}
+	s.introspectBundles = bundles
+	logrus.Infof("Serving /introspect for audience %s", s.IntrospectionAudience)
+	return nil
+}
+
+// handleIntrospect implements RFC 7662 token introspection of JWT SVIDs for
+// --introspection-audience
+func (s *SpiffeJWT) handleIntrospect(w http.ResponseWriter, r *http.Request) {
+	if !s.introspectionClient(r) {
+		introspections.WithLabelValues("unauthorized").Inc()
+		w.Header().Set("WWW-Authenticate", `Bearer realm="introspect"`)
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxIntrospectBody)
+	token := r.PostFormValue("token")
+	if token == "" {
+		introspections.WithLabelValues("invalid_request").Inc()
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	svid, err := jwtsvid.ParseAndValidate(token, s.introspectBundles, []string{s.IntrospectionAudience})
+	if err != nil {
+		logrus.WithError(err).Debug("Introspected token is not active")
+		introspections.WithLabelValues("inactive").Inc()
+		writeJSON(w, http.StatusOK, introspection{Active: false})
+		return
+	}
+	introspections.WithLabelValues("active").Inc()
+	iss, _ := svid.Claims["iss"].(string)
+	writeJSON(w, http.StatusOK, introspection{
+		Active: true,
+		Sub:    svid.ID.String(),
+		Aud:    svid.Audience,
+		Exp:    svid.Expiry.Unix(),
+		Iss:    iss,
+	})
+}
+
+// introspectionClient authenticates the caller of /introspect by the bearer
+// token of --introspection-token-file or a client certificate verified
+// against --introspection-client-ca-file
+func (s *SpiffeJWT) introspectionClient(r *http.Request) bool {
+	if s.introspectClientCAs != nil && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+	if s.introspectToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.introspectToken)) == 1
+}
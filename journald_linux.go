@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/coreos/go-systemd/v22/journal"
+	"github.com/sirupsen/logrus"
+)
+
+// journalPriorities maps logrus levels to syslog priorities
+var journalPriorities = map[logrus.Level]journal.Priority{
+	logrus.TraceLevel: journal.PriDebug,
+	logrus.DebugLevel: journal.PriDebug,
+	logrus.InfoLevel:  journal.PriInfo,
+	logrus.WarnLevel:  journal.PriWarning,
+	logrus.ErrorLevel: journal.PriErr,
+	logrus.FatalLevel: journal.PriCrit,
+	logrus.PanicLevel: journal.PriAlert,
+}
+
+// setupJournald sends the logs to the journal instead of stderr
+func setupJournald() error {
+	if !journal.Enabled() {
+		return errors.New("journal socket not found")
+	}
+	logrus.AddHook(journalHook{send: journal.Send})
+	logrus.SetOutput(io.Discard)
+	return nil
+}
+
+// journalHook sends every entry to the journal, with its fields as journal
+// fields, e.g. failure_class becomes FAILURE_CLASS
+type journalHook struct {
+	send func(message string, priority journal.Priority, vars map[string]string) error
+}
+
+func (journalHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h journalHook) Fire(entry *logrus.Entry) error {
+	entry = redactEntry(entry)
+	vars := map[string]string{"SYSLOG_IDENTIFIER": "spiffe-jwt"}
+	for k, v := range entry.Data {
+		if field := journalField(k); field != "" {
+			vars[field] = fmt.Sprint(fieldValue(v))
+		}
+	}
+	return h.send(entry.Message, journalPriorities[entry.Level], vars)
+}
+
+// journalField turns a log field name into a valid journal field name:
+// uppercase letters, digits and underscores, not starting with an underscore
+func journalField(name string) string {
+	field := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+	return strings.TrimLeft(field, "_0123456789")
+}
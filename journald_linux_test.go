@@ -0,0 +1,27 @@
+package main
+
+import (
+	"io"
+	"testing"
+
+	"github.com/coreos/go-systemd/v22/journal"
+	"github.com/sirupsen/logrus"
+)
+
+// TestJournalLabels checks that labels added after the journal hook is
+// registered still reach the journal
+func TestJournalLabels(t *testing.T) {
+	var sent map[string]string
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	logger.AddHook(journalHook{send: func(_ string, _ journal.Priority, vars map[string]string) error {
+		sent = vars
+		return nil
+	}})
+	addLabelHook(logger, labelSet{"cluster": "east"})
+
+	logger.Info("Fetched JWT SVID")
+	if sent["CLUSTER"] != "east" {
+		t.Errorf("journal fields %v lack the cluster label", sent)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// setupJournald fails outside of Linux, the journal is Linux only
+func setupJournald() error {
+	return errors.New("journald is only supported on Linux")
+}
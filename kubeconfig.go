@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// KubeconfigConfig configures --output-format=kubeconfig. The fields are only
+// used to create a missing kubeconfig, refreshes update just the token of
+// the user entry.
+type KubeconfigConfig struct {
+	Server  string `env:"SERVER" help:"URL of the Kubernetes API server, required to create a new kubeconfig."`
+	CAFile  string `name:"ca-file" env:"CA_FILE" help:"Certificate authority file of the API server, referenced from a new kubeconfig."`
+	Cluster string `env:"CLUSTER" help:"Name of the cluster entry of a new kubeconfig." default:"spiffe"`
+	Context string `env:"CONTEXT" help:"Name of the context entry of a new kubeconfig." default:"spiffe"`
+	User    string `env:"USER" help:"Name of the user entry whose token is refreshed." default:"spiffe-jwt"`
+}
+
+// kubeconfig is the skeleton of a new kubeconfig
+type kubeconfig struct {
+	APIVersion     string              `yaml:"apiVersion"`
+	Kind           string              `yaml:"kind"`
+	Clusters       []kubeconfigCluster `yaml:"clusters"`
+	Contexts       []kubeconfigContext `yaml:"contexts"`
+	CurrentContext string              `yaml:"current-context"`
+	Users          []kubeconfigUser    `yaml:"users"`
+}
+
+type kubeconfigCluster struct {
+	Name    string `yaml:"name"`
+	Cluster struct {
+		Server               string `yaml:"server"`
+		CertificateAuthority string `yaml:"certificate-authority,omitempty"`
+	} `yaml:"cluster"`
+}
+
+type kubeconfigContext struct {
+	Name    string `yaml:"name"`
+	Context struct {
+		Cluster string `yaml:"cluster"`
+		User    string `yaml:"user"`
+	} `yaml:"context"`
+}
+
+type kubeconfigUser struct {
+	Name string `yaml:"name"`
+	User struct {
+		Token string `yaml:"token"`
+	} `yaml:"user"`
+}
+
+// newKubeconfig returns a kubeconfig with a single cluster, context and user
+func newKubeconfig(c KubeconfigConfig, token string) ([]byte, error) {
+	if c.Server == "" {
+		return nil, errors.New("--kubeconfig-server is required to create a new kubeconfig")
+	}
+
+	cluster := kubeconfigCluster{Name: c.Cluster}
+	cluster.Cluster.Server = c.Server
+	cluster.Cluster.CertificateAuthority = c.CAFile
+	context := kubeconfigContext{Name: c.Context}
+	context.Context.Cluster = c.Cluster
+	context.Context.User = c.User
+	user := kubeconfigUser{Name: c.User}
+	user.User.Token = token
+
+	return encodeYAML(kubeconfig{
+		APIVersion:     "v1",
+		Kind:           "Config",
+		Clusters:       []kubeconfigCluster{cluster},
+		Contexts:       []kubeconfigContext{context},
+		CurrentContext: c.Context,
+		Users:          []kubeconfigUser{user},
+	})
+}
+
+// updateKubeconfig sets the token of the user entry of an existing
+// kubeconfig, adding the entry if missing. The rest of the document,
+// comments included, is preserved.
+func updateKubeconfig(data []byte, c KubeconfigConfig, token string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse kubeconfig: %w", err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("kubeconfig is not a YAML mapping")
+	}
+
+	users := mappingValue(doc.Content[0], "users", yaml.SequenceNode)
+	if users == nil {
+		return nil, errors.New("users of the kubeconfig is not a list")
+	}
+
+	var user *yaml.Node
+	found := false
+	for _, entry := range users.Content {
+		if name := mappingValue(entry, "name", yaml.ScalarNode); name != nil && name.Value == c.User {
+			user, found = mappingValue(entry, "user", yaml.MappingNode), true
+			break
+		}
+	}
+	if !found {
+		entry := &yaml.Node{Kind: yaml.MappingNode}
+		mappingValue(entry, "name", yaml.ScalarNode).Value = c.User
+		user = mappingValue(entry, "user", yaml.MappingNode)
+		users.Content = append(users.Content, entry)
+	}
+	// A malformed entry is not replaced, a second one would make the name ambiguous
+	if user == nil {
+		return nil, fmt.Errorf("user %s of the kubeconfig is not a mapping", c.User)
+	}
+
+	tokenNode := mappingValue(user, "token", yaml.ScalarNode)
+	if tokenNode == nil {
+		return nil, fmt.Errorf("token of user %s of the kubeconfig is not a string", c.User)
+	}
+	tokenNode.Value, tokenNode.Tag, tokenNode.Style = token, "!!str", 0
+
+	return encodeYAML(&doc)
+}
+
+// mappingValue returns the value of key in a mapping node, adding it with
+// the given kind if missing. It returns nil if node is not a mapping or the
+// existing value is of another kind.
+func mappingValue(node *yaml.Node, key string, kind yaml.Kind) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			if value := node.Content[i+1]; value.Kind == kind {
+				return value
+			}
+			return nil
+		}
+	}
+
+	value := &yaml.Node{Kind: kind}
+	if kind == yaml.ScalarNode {
+		value.Tag = "!!str"
+	}
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+	return value
+}
+
+// encodeYAML encodes v with the two-space indentation used by kubectl
+func encodeYAML(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		return nil, fmt.Errorf("failed to encode kubeconfig: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode kubeconfig: %w", err)
+	}
+	return buf.Bytes(), nil
+}
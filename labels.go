@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+)
+
+// labelSet is a set of static labels by name
+type labelSet map[string]string
+
+// downwardAPILabels maps the environment variables conventionally set from
+// the Kubernetes Downward API to the labels they provide
+var downwardAPILabels = map[string]string{
+	"POD_NAME":      "pod",
+	"POD_NAMESPACE": "namespace",
+	"NODE_NAME":     "node",
+}
+
+// labelNamePattern matches valid Prometheus label names
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// reservedLabels are the label names already used by metrics and log entries
+var reservedLabels = slices.Concat([]string{"target", "sink", "reason", "level", "audience", "code", "exe", "outcome", "le", "quantile", "msg", "time", "error"}, identityLabelNames)
+
+// resolveLabels returns the labels of --label together with the pod, namespace
+// and node of the Downward API environment variables that are set. Explicit
+// labels win.
+func (s *SpiffeJWT) resolveLabels() (map[string]string, error) {
+	labels := map[string]string{}
+	for env, name := range downwardAPILabels {
+		if v := os.Getenv(env); v != "" {
+			labels[name] = v
+		}
+	}
+	for name, v := range s.Labels {
+		switch {
+		case !labelNamePattern.MatchString(name) || strings.HasPrefix(name, "__"):
+			return nil, fmt.Errorf("invalid label name %q", name)
+		case slices.Contains(reservedLabels, name):
+			return nil, fmt.Errorf("label name %q is reserved", name)
+		case v == "":
+			return nil, fmt.Errorf("label %q has an empty value", name)
+		}
+		labels[name] = v
+	}
+	return labels, nil
+}
+
+// labelGatherer adds static labels to every metric of the wrapped gatherer.
+// The labels never change, so they do not add to the cardinality.
+type labelGatherer struct {
+	prometheus.Gatherer
+	pairs []*dto.LabelPair
+}
+
+func newLabelGatherer(g prometheus.Gatherer, labels map[string]string) *labelGatherer {
+	lg := &labelGatherer{Gatherer: g}
+	for name, value := range labels {
+		lg.pairs = append(lg.pairs, &dto.LabelPair{Name: &name, Value: &value})
+	}
+	return lg
+}
+
+func (g *labelGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	for _, family := range families {
+		for _, m := range family.Metric {
+			m.Label = append(m.Label, g.pairs...)
+			sort.Slice(m.Label, func(i, j int) bool { return m.Label[i].GetName() < m.Label[j].GetName() })
+		}
+	}
+	return families, err
+}
+
+// labelHook adds static labels as fields of every log entry, without
+// overriding the fields of the entry
+type labelHook struct {
+	labels map[string]string
+}
+
+func (h *labelHook) Levels() []logrus.Level { return logrus.AllLevels }
+
+func (h *labelHook) Fire(entry *logrus.Entry) error {
+	for name, value := range h.labels {
+		if _, ok := entry.Data[name]; !ok {
+			entry.Data[name] = value
+		}
+	}
+	return nil
+}
+
+// addLabelHook adds labels to every entry of logger. The hook runs before the
+// hooks already registered, so that the journal hook sends the labels too.
+func addLabelHook(logger *logrus.Logger, labels labelSet) {
+	hooks := logrus.LevelHooks{}
+	hooks.Add(&labelHook{labels: labels})
+	for level, levelHooks := range logger.Hooks {
+		hooks[level] = append(hooks[level], levelHooks...)
+	}
+	logger.ReplaceHooks(hooks)
+}
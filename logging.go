@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// jwtPattern matches anything shaped like a compact JWS
+var jwtPattern = regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+
+// setupLogging installs the formatter for --log-format and the destination of
+// --log-output, and adds the hostname to every entry with
+// --log-include-hostname. Every format and output goes through the redaction
+// layer, so tokens never reach the logs.
+func setupLogging(format, output string, includeHostname bool) {
+	var f logrus.Formatter
+	switch format {
+	case "json":
+		f = &logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano}
+	case "gcp":
+		f = &gcpFormatter{}
+	case "ecs":
+		f = &ecsFormatter{}
+	default:
+		f = &logrus.TextFormatter{}
+	}
+	logrus.SetFormatter(&redactingFormatter{Formatter: f})
+	logrus.AddHook(refreshIDHook{})
+
+	// Hooks run in order, the hostname must be set before the journal hook
+	if includeHostname {
+		if hostname, err := os.Hostname(); err != nil {
+			logrus.WithError(err).Warn("unable to get the hostname, logging without it")
+		} else {
+			logrus.AddHook(&labelHook{labels: labelSet{"hostname": hostname}})
+		}
+	}
+
+	if output == "journald" {
+		if err := setupJournald(); err != nil {
+			logrus.WithError(err).Warn("journald is unavailable, logging to stderr")
+		}
+	}
+}
+
+// redactingFormatter replaces tokens in messages and string fields before
+// handing the entry to the wrapped formatter
+type redactingFormatter struct {
+	logrus.Formatter
+}
+
+func (f *redactingFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	return f.Formatter.Format(redactEntry(entry))
+}
+
+// redactEntry returns a copy of entry with tokens replaced in the message
+// and string fields
+func redactEntry(entry *logrus.Entry) *logrus.Entry {
+	redacted := *entry
+	redacted.Message = redact(entry.Message)
+	redacted.Data = make(logrus.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		switch v := v.(type) {
+		case string:
+			redacted.Data[k] = redact(v)
+		case error:
+			redacted.Data[k] = redact(v.Error())
+		default:
+			redacted.Data[k] = v
+		}
+	}
+	return &redacted
+}
+
+// redact replaces anything shaped like a JWT in s
+func redact(s string) string {
+	return jwtPattern.ReplaceAllString(s, "[REDACTED]")
+}
+
+// gcpSeverities maps logrus levels to Cloud Logging severities
+var gcpSeverities = map[logrus.Level]string{
+	logrus.TraceLevel: "DEBUG",
+	logrus.DebugLevel: "DEBUG",
+	logrus.InfoLevel:  "INFO",
+	logrus.WarnLevel:  "WARNING",
+	logrus.ErrorLevel: "ERROR",
+	logrus.FatalLevel: "CRITICAL",
+	logrus.PanicLevel: "ALERT",
+}
+
+// gcpFormatter writes the structured JSON understood by Cloud Logging. The
+// failure class becomes a label, so failures can be filtered and counted.
+type gcpFormatter struct{}
+
+func (f *gcpFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	out := make(map[string]any, len(entry.Data)+4)
+	for k, v := range entry.Data {
+		out[k] = fieldValue(v)
+	}
+	if class, ok := entry.Data[failureClassField]; ok {
+		delete(out, failureClassField)
+		out["logging.googleapis.com/labels"] = map[string]any{failureClassField: class}
+	}
+	out["severity"] = gcpSeverities[entry.Level]
+	out["time"] = entry.Time.Format(time.RFC3339Nano)
+	out["message"] = entry.Message
+	return marshalLine(out)
+}
+
+// ecsFormatter writes Elastic Common Schema JSON
+type ecsFormatter struct{}
+
+func (f *ecsFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	out := make(map[string]any, len(entry.Data)+4)
+	for k, v := range entry.Data {
+		switch k {
+		case logrus.ErrorKey:
+			out["error.message"] = fieldValue(v)
+		case failureClassField:
+			out["labels"] = map[string]any{failureClassField: v}
+		default:
+			out[k] = fieldValue(v)
+		}
+	}
+	out["@timestamp"] = entry.Time.UTC().Format(time.RFC3339Nano)
+	out["log.level"] = entry.Level.String()
+	out["message"] = entry.Message
+	out["ecs.version"] = "1.6.0"
+	return marshalLine(out)
+}
+
+// fieldValue makes errors readable once encoded, as logrus.JSONFormatter does
+func fieldValue(v any) any {
+	if err, ok := v.(error); ok {
+		return err.Error()
+	}
+	return v
+}
+
+// marshalLine encodes a log entry as a single JSON line
+func marshalLine(v map[string]any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode log entry: %w", err)
+	}
+	return append(data, '\n'), nil
+}
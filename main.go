@@ -2,129 +2,1244 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
+	"io"
+	"io/fs"
+	"net"
 	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/alecthomas/kong"
+	"github.com/robfig/cron/v3"
 	"github.com/sirupsen/logrus"
 	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
 	"github.com/spiffe/go-spiffe/v2/workloadapi"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
 )
 
+// CLI is the command line interface of spiffe-jwt
+type CLI struct {
+	LogFormat          string `env:"LOG_FORMAT" help:"Format of the logs: text, json, or the gcp and ecs presets for Cloud Logging and Elastic." enum:"text,json,gcp,ecs" default:"text"`
+	LogOutput          string `env:"LOG_OUTPUT" help:"Destination of the logs: stderr, or journald through its native protocol with priorities and structured fields (Linux only)." enum:"stderr,journald" default:"stderr"`
+	LogIncludeHostname bool   `env:"LOG_INCLUDE_HOSTNAME" help:"Add the hostname of the machine to every log entry, as the hostname field."`
+
+	Run     SpiffeJWT  `cmd:"" default:"withargs" help:"Fetch a JWT SVID and keep it refreshed (default)."`
+	Compare CompareCmd `cmd:"" help:"Compare the headers and claims of two token files."`
+	Watch   WatchCmd   `cmd:"" help:"Print a summary of every rotation of a token file."`
+	Bench   BenchCmd   `cmd:"" help:"Load-test the Workload API of the SPIFFE agent."`
+
+	SinkPluginCheck SinkPluginCheckCmd `cmd:"" help:"Check that a sink plugin implements the sinkplugin protocol."`
+
+	PublishJWKS PublishJWKSCmd `cmd:"" name:"publish-jwks" help:"Publish the OIDC discovery document and JWKS of a trust domain to an S3 or GCS bucket."`
+
+	GenerateConfig              GenerateConfigCmd              `cmd:"" help:"Print a documented YAML configuration skeleton."`
+	TranslateSpiffeHelperConfig TranslateSpiffeHelperConfigCmd `cmd:"" help:"Print the native configuration equivalent to a spiffe-helper configuration file."`
+}
+
 // SpiffeJWT periodically refreshes a JWT SVID from the SPIFFE agent and writes it to a file.
 // If it fails to fetch the JWT SVID, it will log an error and exit.
 type SpiffeJWT struct {
+	Config      configFile      `help:"Path to a YAML configuration file, see generate-config." type:"existingfile"`
+	ConfigCheck configCheckFlag `help:"Validate the configuration file, environment and flags, then exit."`
+	PrintConfig printConfigFlag `help:"Print the resolved configuration with the source of every value, then exit."`
+	Profile     string          `env:"PROFILE" help:"Profile of the configuration file to apply, keys set otherwise override it."`
+
+	SpiffeHelperConfig spiffeHelperConfig `help:"Path to a spiffe-helper configuration file to migrate from." type:"existingfile"`
+
 	DaemonMode              bool          `env:"DAEMON_MODE" help:"Run in daemon mode." default:"true"`
+	InitialFetchSync        bool          `env:"INITIAL_FETCH_SYNC" help:"In daemon mode, complete the first fetch and write before starting the health server and backgrounding the refresh loop."`
+	InitialFetchTimeout     time.Duration `env:"INITIAL_FETCH_TIMEOUT" help:"With --initial-fetch-sync, time allowed to the first fetch and write, retries included, before shutting down." default:"30s"`
+	InitialFetchRetries     int           `env:"INITIAL_FETCH_RETRIES" help:"Number of times a failed first fetch or write is retried with backoff before shutting down." default:"0"`
+	ConnectCheckOnly        bool          `env:"CONNECT_CHECK_ONLY" help:"Fetch the JWT bundles to check that the SPIFFE agent is reachable and attests this workload, then exit. The audience and output flags are not required."`
 	HealthPort              string        `env:"HEALTH_PORT" help:"Port to listen for health checks." default:"8080"`
-	JWTAudience             string        `env:"JWT_AUDIENCE" help:"Audience of the JWT." required:""`
-	JWTFileName             string        `env:"JWT_FILE_NAME" help:"Name of the file to write the JWT SVID to." required:""`
-	SpiffeAgentSocket       string        `env:"SPIFFE_AGENT_SOCKET" help:"File name of the SPIFFE agent socket" required:""`
+	MetricsPort             string        `env:"METRICS_PORT" help:"Port to serve /metrics on over plain HTTP, separately from the health server. By default, or when equal to --health-port, /metrics is served by the health server."`
+	HealthTimeout           time.Duration `env:"HEALTH_TIMEOUT" help:"Maximum time a health server handler may take before answering 503, 0 to disable." default:"2s"`
+	HealthReadHeaderTimeout time.Duration `env:"HEALTH_READ_HEADER_TIMEOUT" help:"Maximum time the health and metrics servers wait for the request headers, against slow clients holding connections open. 0 falls back to the 5s read timeout." default:"2s"`
+	EventsMaxSubscribers    int           `env:"EVENTS_MAX_SUBSCRIBERS" help:"Maximum number of open /events streams across the health and admin servers, further ones get a 503. 0 means unlimited." default:"32"`
+	HealthMaxConnections    int           `env:"HEALTH_MAX_CONNECTIONS" help:"Maximum number of open health server connections, further connections get a 503. 0 means unlimited." default:"100"`
+	Labels                  labelSet      `name:"label" env:"LABELS" placeholder:"NAME=VALUE" help:"Static label attached to every metric, log entry and /events message. Repeatable. The pod, namespace and node are added from POD_NAME, POD_NAMESPACE and NODE_NAME when set."`
+	MetricsCardinalityLimit int           `env:"METRICS_CARDINALITY_LIMIT" help:"Maximum number of label value combinations across labelled metrics, new ones are dropped with a warning. 0 means unlimited." default:"100"`
+	MetricsIdentityLabels   bool          `env:"METRICS_IDENTITY_LABELS" help:"Label the rotation and expiry metrics with the trust domain and the first path segment of the SPIFFE ID, e.g. trust_domain=example.org and workload=ns for spiffe://example.org/ns/app. Set to false to leave the labels empty." default:"true"`
+	PrometheusPushGateway   string        `env:"PROMETHEUS_PUSH_GATEWAY" help:"URL of a Prometheus Pushgateway to push the metrics to with --report-metrics-on-exit."`
+	ReportMetricsOnExit     bool          `env:"REPORT_METRICS_ON_EXIT" help:"Push the metrics to --prometheus-push-gateway as the process exits, with the spiffe_jwt_last_exit_timestamp_seconds and spiffe_jwt_exit_code gauges. For one-shot runs."`
+	HealthTLSCertFile       string        `env:"HEALTH_TLS_CERT_FILE" help:"Certificate file to serve health checks over TLS." type:"existingfile" and:"health-tls"`
+	HealthTLSKeyFile        string        `env:"HEALTH_TLS_KEY_FILE" help:"Key file to serve health checks over TLS." type:"existingfile" and:"health-tls"`
+	HealthTLSMinVersion     string        `env:"HEALTH_TLS_MIN_VERSION" help:"Minimum TLS version of the health server, 1.3 is recommended for new deployments." enum:"1.2,1.3" default:"1.2"`
+	AdminTokenFile          string        `env:"ADMIN_TOKEN_FILE" help:"File with the bearer token required by admin endpoints such as POST /drain." type:"existingfile"`
+	IntrospectionAudience   string        `env:"INTROSPECTION_AUDIENCE" help:"In daemon mode, serve POST /introspect (RFC 7662) on the health server, validating presented JWT SVIDs against the trust bundles of the Workload API and this audience. Requires client authentication with --introspection-token-file or --introspection-client-ca-file."`
+	IntrospectionTokenFile  string        `env:"INTROSPECTION_TOKEN_FILE" help:"File with the bearer token authenticating callers of /introspect." type:"existingfile"`
+	IntrospectClientCAFile  string        `name:"introspection-client-ca-file" env:"INTROSPECTION_CLIENT_CA_FILE" help:"CA bundle verifying the client certificates authenticating callers of /introspect, requires --health-tls-cert-file." type:"existingfile"`
+	EnableAdminAPI          bool          `name:"enable-admin-api" env:"ENABLE_ADMIN_API" help:"In daemon mode, start an admin server on 127.0.0.1:--admin-port or --admin-socket with POST /refresh, GET /status, POST /pause and POST /resume. The admin token is required when --admin-token-file is set, which is recommended on the loopback interface as other containers of a pod share it."`
+	AdminPort               string        `env:"ADMIN_PORT" help:"Port of the admin server of --enable-admin-api, on the loopback interface only." default:"9090"`
+	AdminSocket             string        `env:"ADMIN_SOCKET" help:"Serve the admin server of --enable-admin-api on this unix socket, only reachable by the user of the daemon, instead of --admin-port."`
+	EnableDebugDump         bool          `name:"enable-debug-dump" env:"ENABLE_DEBUG_DUMP" help:"Serve GET /debug/dump on the admin server: version, redacted resolved configuration, status, recent refreshes, metrics and goroutine count as one JSON document for support tickets. Requires --enable-admin-api."`
+	TokenAPISocket          string        `name:"token-api-socket" env:"TOKEN_API_SOCKET" help:"In daemon mode, serve the gRPC token API (spiffejwt.token.v1.TokenService) on this unix socket, with GetToken and WatchToken streaming every rotation of the JWT SVID (Linux only)."`
+	TokenAPIAllowedUIDs     []int         `name:"token-api-allowed-uid" env:"TOKEN_API_ALLOWED_UIDS" help:"Uids of the processes allowed to call the token API, checked with the peer credentials of the socket. Defaults to the uid of spiffe-jwt."`
+	DrainOnSigtermDelay     time.Duration `env:"DRAIN_ON_SIGTERM_DELAY" help:"On SIGTERM, stop reporting ready and wait this long before shutting down."`
+	DeadlinePropagation     bool          `name:"fetch-deadline-propagation" env:"FETCH_DEADLINE_PROPAGATION" help:"Bound the whole refresh of the primary JWT SVID and of every --token, its fetch, write and output targets, by --fetch-timeout rather than the Workload API call alone."`
+	ShutdownFinishInflight  time.Duration `env:"SHUTDOWN_FINISH_INFLIGHT" help:"On shutdown, let a JWT SVID fetch in flight complete and be written for up to this long, so that consumers get a fresh token. 0 cancels it right away."`
+	ReadyFile               string        `env:"READY_FILE" help:"In daemon mode, create this empty file while /readyz reports ready and remove it otherwise and on shutdown, for consumers waiting on a file."`
+	JWTAudience             string        `env:"JWT_AUDIENCE" help:"Audience of the JWT." xor:"audience"`
+	AudienceRoundRobin      []string      `env:"AUDIENCE_ROUND_ROBIN" help:"Audiences to cycle through, one per refresh, instead of a single audience." xor:"audience"`
+	AudienceWeights         []string      `name:"audience-weight" env:"AUDIENCE_WEIGHT" placeholder:"AUDIENCE:WEIGHT" help:"Relative weight of an audience of --audience-round-robin, e.g. a:3,b:1 to fetch for a three times as often as for b. Audiences are then drawn at random by weight rather than cycled, unlisted ones weigh 1."`
+	AudienceFromJWT         string        `env:"AUDIENCE_FROM_JWT" help:"Use the first audience of an existing JWT file as the audience, for self-renewal." xor:"audience" type:"existingfile"`
+	JWTFileName             string        `env:"JWT_FILE_NAME" help:"Name of the file to write the JWT SVID to." xor:"output"`
+	JSONOutput              bool          `env:"JSON_OUTPUT" help:"In one-shot mode, print the JWT SVID, SPIFFE ID, audience and expiry to stdout as a JSON object instead of writing a file." xor:"output"`
+	Tokens                  []tokenSpec   `name:"token" env:"TOKENS" sep:";" placeholder:"audience=AUD,file=PATH[,name=NAME]" help:"Additional JWT SVID to fetch and write, refreshed on its own schedule in daemon mode. Repeatable, separated by ; in the environment."`
+	TokenConcurrency        int           `env:"TOKEN_CONCURRENCY" help:"Maximum number of JWT SVIDs fetched in parallel with --token." default:"4"`
+	TokenRefreshLockFile    string        `env:"TOKEN_REFRESH_LOCK_FILE" help:"Serialize JWT SVID fetches, those of concurrent refreshes of this process and of other processes locking the same file."`
+	FetchTimeout            time.Duration `env:"FETCH_TIMEOUT" help:"Timeout of a JWT SVID fetch from the SPIFFE agent, including the wait for --token-refresh-lock-file." default:"10s"`
+	ReadinessPolicy         string        `env:"READINESS_POLICY" help:"Which tokens must hold an unexpired JWT SVID for /readyz to succeed: all of them, a majority (quorum), or those of --readiness-critical-tokens (critical). The primary token is named default." enum:"all,quorum,critical" default:"all"`
+	ReadinessCriticalTokens []string      `env:"READINESS_CRITICAL_TOKENS" help:"Names of the tokens required by --readiness-policy=critical."`
+	ExpiredResponseCode     int           `name:"http-response-code-on-expired" env:"HTTP_RESPONSE_CODE_ON_EXPIRED" help:"Status code of /readyz and /readyz/{name} when the JWT SVID expired, e.g. 200 to tell an expired token, reported as status expired in the body, from a process that is down." default:"503"`
+	BestEffort              bool          `env:"BEST_EFFORT" help:"With --token, exit zero as long as at least one JWT SVID was written."`
+	TokenMapFile            string        `env:"TOKEN_MAP_FILE" help:"Also write the current JWT SVID of every audience to this JSON file, keyed by audience with the expiries under _meta. Replaced atomically with mode 0600 whenever one rotates, whatever --file-write-mode."`
+	SpiffeAgentSocket       string        `env:"SPIFFE_AGENT_SOCKET" help:"File name of the SPIFFE agent socket, or a Workload API address such as unix:///path or tcp://host:port" required:"" xor:"socket"`
+	SpiffeAgentSocketEnv    string        `env:"SPIFFE_AGENT_SOCKET_ENV" help:"Name of the environment variable to read the SPIFFE agent socket from at runtime." required:"" xor:"socket"`
+	SVIDHint                string        `name:"svid-hint" env:"SVID_HINT" help:"Use the JWT SVID with this hint when the workload is registered with several identities."`
+	FetchUserContext        []string      `name:"fetch-user-context" env:"FETCH_USER_CONTEXT" sep:"none" help:"Attestation hints sent with every fetch, as selectors=<type>:<value>[,...], e.g. selectors=k8s:pod-label:team:platform. Repeatable. Passed in jwtsvid.Params.ExtraSelectors by go-spiffe versions that support it, ignored with a warning otherwise."`
+	SVIDHintMismatch        string        `name:"svid-hint-mismatch" env:"SVID_HINT_MISMATCH" help:"What to do when no JWT SVID carries --svid-hint: keep the current token and retry (retry), or use the first JWT SVID returned (fallback)." enum:"retry,fallback" default:"retry"`
+	WorkloadAPIUserAgent    string        `env:"WORKLOAD_API_USER_AGENT" help:"User-Agent sent on Workload API calls (e.g., spiffe-jwt/1.0.0+myapp)."`
+	WorkloadAPICAFile       string        `name:"workload-api-ca-file" env:"WORKLOAD_API_CA_FILE" help:"CA bundle verifying the server certificate of a tcp:// Workload API, enabling TLS." type:"existingfile"`
+	WorkloadAPICertFile     string        `name:"workload-api-cert-file" env:"WORKLOAD_API_CERT_FILE" help:"Client certificate presented to a tcp:// Workload API over TLS." type:"existingfile" and:"workload-api-client-cert"`
+	WorkloadAPIKeyFile      string        `name:"workload-api-key-file" env:"WORKLOAD_API_KEY_FILE" help:"Key of --workload-api-cert-file." type:"existingfile" and:"workload-api-client-cert"`
+	WorkloadAPIServerName   string        `name:"workload-api-server-name" env:"WORKLOAD_API_SERVER_NAME" help:"Name expected in the server certificate of a tcp:// Workload API over TLS, the host of the address by default."`
+	InsecureWorkloadAPI     bool          `name:"insecure-workload-api" env:"INSECURE_WORKLOAD_API" help:"Acknowledge that a tcp:// Workload API without TLS options is reached over plaintext."`
 	RefreshIntervalOverride time.Duration `env:"REFRESH_INTERVAL_OVERRIDE" help:"Override the default refresh interval (e.g., 30s, 5m)."`
+	TokenExpirySlack        time.Duration `env:"TOKEN_EXPIRY_SLACK" help:"Clock skew tolerated between the issuer and this host, added to the token expiry (e.g., 10s)."`
+	AudienceValidationMode  string        `env:"AUDIENCE_VALIDATION_MODE" help:"How the audience of a fetched JWT SVID is checked: equal to the requested audience (exact), starting with the pattern (prefix), or matching the pattern as a whole (regex)." enum:"exact,prefix,regex" default:"exact"`
+	AudiencePattern         string        `name:"audience-validation-pattern" env:"AUDIENCE_VALIDATION_PATTERN" help:"Prefix or regular expression of --audience-validation-mode, the requested audience by default in prefix mode."`
+	AllowedAlgorithms       []string      `env:"ALLOWED_ALGORITHMS" help:"Signature algorithms a fetched JWT SVID may use, others are rejected without being written." default:"RS256,RS384,RS512,ES256,ES384,ES512,PS256,PS384,PS512"`
+	ExpectedIssuer          string        `env:"EXPECTED_ISSUER" help:"Issuer pinned for fetched JWT SVIDs. A token whose iss claim differs is rejected and not written, independently of the agent."`
+	MaxIssuedAtSkew         time.Duration `env:"MAX_ISSUED_AT_SKEW" help:"How far in the future the issued-at time of a fetched JWT SVID may be before it is rejected." default:"1m"`
+	RejectedFatalBefore     time.Duration `env:"REJECTED_FATAL_BEFORE" help:"After a rejected JWT SVID, keep the last known good one and retry, exiting once it expires within this duration." default:"30s"`
+	JWTBundleFileName       string        `env:"JWT_BUNDLE_FILE_NAME" help:"Name of the file to write the JWT bundle (JWKS) of the trust domain to. Startup waits for the first bundle."`
+	SigningKeyJWKFile       string        `name:"signing-key-jwk-file" env:"SIGNING_KEY_JWK_FILE" help:"Name of the file to write the bundle key that signed the JWT SVID to, as a JWK. Refreshed with the bundle and when the signing key changes."`
+	BundleRefreshInterval   time.Duration `env:"BUNDLE_REFRESH_INTERVAL" help:"Interval at which the JWT bundle is refreshed, independent of the token." default:"1h"`
+	AuditLogFile            string        `env:"AUDIT_LOG_FILE" help:"Append a JSON line describing every written token (never the token itself) to this file."`
+	AuditLogMaxSize         int64         `env:"AUDIT_LOG_MAX_SIZE" help:"Size in bytes after which the audit log is rotated to <file>.1, 0 to disable." default:"10485760"`
+	TokenReadAudit          bool          `env:"TOKEN_READ_AUDIT" help:"In daemon mode, record every open of the JWT file by another process, with its PID, executable and uid, in the logs, the audit log and a per-executable metric. Uses fanotify, which requires CAP_SYS_ADMIN, and is skipped with a warning without it (Linux only)."`
+	TokenReadAuditAllow     []string      `env:"TOKEN_READ_AUDIT_ALLOW" help:"Executables, by absolute path, whose opens of the JWT file are expected and not recorded by --token-read-audit."`
+	ExitAfter               time.Duration `env:"EXIT_AFTER" help:"In daemon mode, stop after this duration and exit with a summary, for canary runs."`
+	ExitAfterRotations      int           `env:"EXIT_AFTER_ROTATIONS" help:"In daemon mode, stop after this many successful rotations of the JWT SVID, the initial fetch included, and exit with a summary. Combined with --exit-after, whichever comes first."`
+	ExitAfterMaxFailures    int           `env:"EXIT_AFTER_MAX_FAILURES" help:"Number of failures tolerated by --exit-after and --exit-after-rotations before exiting non-zero. --exit-after-rotations stops as soon as they are exceeded." default:"0"`
+	OnIdentityChange        string        `env:"ON_IDENTITY_CHANGE" help:"What to do when the SPIFFE ID of a refreshed JWT SVID differs from the previous one: accept it or exit without writing it." enum:"accept,fatal" default:"accept"`
+	IdentityChangeHook      string        `env:"IDENTITY_CHANGE_HOOK" help:"Shell command to run after a JWT SVID with a new SPIFFE ID is written. The IDs are passed in SPIFFE_JWT_PREVIOUS_ID and SPIFFE_JWT_NEW_ID."`
+	NotifyPIDFile           string        `name:"notify-pid-file" env:"NOTIFY_PID_FILE" help:"Send --notify-signal to the process whose PID is in this file after every rotation of the JWT SVID, for a companion such as a proxy reloading its credentials. The file is read on every rotation (not supported on Windows)."`
+	NotifySignal            string        `env:"NOTIFY_SIGNAL" help:"Signal sent by --notify-pid-file, by name, e.g. SIGUSR1 for Envoy." default:"SIGHUP"`
+	RefreshOnStartupOnly    bool          `name:"token-refresh-on-startup-only" env:"TOKEN_REFRESH_ON_STARTUP_ONLY" help:"In daemon mode, fetch the JWT SVID once at startup and never refresh it, exiting when it expires. For jobs shorter than the token lifetime."`
+	MaxFetchesPerMinute     int           `env:"MAX_FETCHES_PER_MINUTE" help:"Budget of Workload API calls per minute shared by all refreshes, forced or not. Calls over budget are deferred. 0 means unlimited."`
+	ExpiryWarningThreshold  time.Duration `env:"EXPIRY_WARNING_THRESHOLD" help:"Raise the expiry warning when the JWT SVID has less than this lifetime left without having been refreshed, 0 to disable."`
+	ExpiryCriticalThreshold time.Duration `env:"EXPIRY_CRITICAL_THRESHOLD" help:"Escalate the expiry warning to critical below this remaining lifetime, 0 to disable."`
+	RefreshCron             string        `env:"REFRESH_CRON" help:"Cron expression (minute hour day-of-month month day-of-week) of extra forced refreshes on top of the expiry-driven schedule, e.g. 0 2 * * * for 02:00 every day."`
+	RefreshCronTimezone     string        `env:"REFRESH_CRON_TIMEZONE" help:"IANA timezone of --refresh-cron, such as Europe/Paris." default:"Local"`
+	AdoptExistingToken      bool          `env:"ADOPT_EXISTING_TOKEN" help:"On startup, adopt a JWT SVID already in --jwt-file-name that passes validation and has at least --adopt-existing-min-ttl left, and schedule the next refresh from its expiry instead of fetching right away. For zero-gap binary upgrades."`
+	AdoptMinTTL             time.Duration `name:"adopt-existing-min-ttl" env:"ADOPT_EXISTING_MIN_TTL" help:"Remaining lifetime a JWT SVID needs to be adopted by --adopt-existing-token." default:"1m"`
+	WatchBundleChanges      bool          `env:"WATCH_BUNDLE_CHANGES" help:"Watch the X.509 bundles of the Workload API and force a refresh of the JWT SVID whenever they change, regardless of its expiry. Subject to --forced-refresh-cooldown."`
+	Sandbox                 bool          `env:"SANDBOX" help:"After startup, restrict the process to the files, directories and ports of its configuration with Landlock and seccomp on Linux (kernel 5.13 or later), or pledge and unveil on OpenBSD. Running programs stays allowed only for --identity-change-hook, --sink-plugin and --token-file-acl."`
+	ForcedRefreshCooldown   time.Duration `env:"FORCED_REFRESH_COOLDOWN" help:"Minimum time after a refresh before a forced refresh (SIGHUP) is honoured." default:"30s"`
+	TokenFileModeRefresh    bool          `env:"TOKEN_FILE_MODE_REFRESH" help:"Chmod output files to their intended mode after every write, regardless of the umask and of the mode of an existing file."`
+	FileSELinuxLabel        string        `name:"file-selinux-label" env:"FILE_SELINUX_LABEL" help:"SELinux context to set on output files after every write (e.g., system_u:object_r:container_file_t:s0). A failure is only logged unless --file-selinux-required is set."`
+	FileSELinuxRequired     bool          `name:"file-selinux-required" env:"FILE_SELINUX_REQUIRED" help:"Fail the write when --file-selinux-label cannot be set."`
+	TokenFileACL            string        `name:"token-file-acl" env:"TOKEN_FILE_ACL" help:"POSIX ACL entries to add to token files after every write with setfacl -m (e.g., u:1000:r), to grant a uid read access without changing the group. Skipped with a warning when setfacl is not in PATH."`
+	TokenFileWindowsACL     []string      `name:"token-file-windows-acl" env:"TOKEN_FILE_WINDOWS_ACL" help:"On Windows, accounts or SIDs granted access to token files, whose ACL is replaced after every write with inheritance disabled, and checked. Defaults to the current user and SYSTEM (Windows only)."`
+	TokenIncludeRawHeader   bool          `name:"token-include-raw-header" env:"TOKEN_INCLUDE_RAW_HEADER" help:"Also write the decoded JWT header (alg, kid, typ) to <jwt-file-name>.header.json. Only rewritten when the header changes, so its mtime tracks signing key rotations."`
+	Xattr                   bool          `name:"xattr" env:"XATTR" help:"Tag token files with the expiry and SPIFFE ID of the token in the user.spiffe.expiry and user.spiffe.id extended attributes after every write. Best effort."`
+	RequireHardenedMount    bool          `env:"REQUIRE_HARDENED_MOUNT" help:"Refuse to start unless the directories of the output files are on filesystems mounted noexec and nosuid (Linux only)."`
+	FileWriteMode           string        `env:"FILE_WRITE_MODE" help:"How files are written: in place (direct), or to a temporary file in the same directory renamed over the destination (atomic-per-dir)." enum:"direct,atomic-per-dir" default:"direct"`
+	TokenMetadataBackend    string        `env:"TOKEN_METADATA_BACKEND" help:"Backend receiving an event with the metadata of every written JWT SVID, never the token itself. Failures are only logged." enum:",datadog" default:""`
+	OutputFormat            string        `env:"OUTPUT_FORMAT" help:"Format of the JWT file: the raw token, or a kubeconfig whose user token is the JWT SVID." enum:"raw,kubeconfig" default:"raw"`
+	TokenPersistenceMode    string        `env:"TOKEN_PERSISTENCE_MODE" help:"How the JWT SVID is stored on disk: plain or encrypted (AES-GCM)." enum:"plain,encrypted" default:"plain"`
+	EncryptionKeyFile       string        `env:"ENCRYPTION_KEY_FILE" help:"File with the hex encoded 256-bit AES key used in encrypted persistence mode." type:"existingfile"`
+	Mlock                   bool          `env:"MLOCK" help:"Keep secrets out of swap. The whole process is locked with mlockall when RLIMIT_MEMLOCK is unlimited or with CAP_IPC_LOCK. Otherwise only the buffers holding the cached access tokens, the token map and the encryption key are locked, with a warning, and the JWT SVID held by the Workload API client and transient copies on the Go heap may still be swapped. Replaced secrets are zeroed, but Go may leave copies until they are garbage collected (Linux only)."`
+	SinkPlugins             []string      `name:"sink-plugin" env:"SINK_PLUGINS" help:"Out-of-process output target, as [name=]path of an executable implementing the sinkplugin protocol. Supervised and restarted with backoff when it exits, see sink-plugin-check."`
+
+	Bootstrap        BootstrapConfig        `embed:"" prefix:"bootstrap-" envprefix:"BOOTSTRAP_" group:"SPIRE agent bootstrap"`
+	Kubeconfig       KubeconfigConfig       `embed:"" prefix:"kubeconfig-" envprefix:"KUBECONFIG_" group:"Kubeconfig output"`
+	SecretsManager   SecretsManagerConfig   `embed:"" prefix:"secrets-manager-" envprefix:"SECRETS_MANAGER_" group:"AWS Secrets Manager output"`
+	GCPSecretManager GCPSecretManagerConfig `embed:"" prefix:"gcp-secret-manager-" envprefix:"GCP_SECRET_MANAGER_" group:"GCP Secret Manager output"`
+	AzureKeyVault    AzureKeyVaultConfig    `embed:"" prefix:"azure-key-vault-" envprefix:"AZURE_KEY_VAULT_" group:"Azure Key Vault output"`
+	S3               S3Config               `embed:"" prefix:"s3-" envprefix:"S3_" group:"S3 output"`
+	GRPCCallback     GRPCCallbackConfig     `embed:"" prefix:"grpc-callback-" envprefix:"GRPC_CALLBACK_" group:"gRPC callback output"`
+	Datadog          DatadogConfig          `embed:"" prefix:"datadog-" envprefix:"DATADOG_" group:"Datadog events"`
+
+	// Additional output targets written after the local file
+	sinks []Sink
+
+	// AES key of the encrypted persistence mode
+	encryptionKey []byte
+
+	// Pending forced refresh requests, consumed by the refresh loop
+	refreshCh chan struct{}
+
+	// Append-only record of written tokens, nil unless --audit-log-file is set
+	audit *auditLog
+
+	// Exit of the SPIRE agent started by --bootstrap-token-file, nil otherwise
+	agentExited chan error
+
+	// Activity of the daemon, summarized by --exit-after
+	stats runStats
+
+	// The current JWT SVID and when it was refreshed, reported by /status
+	mu          sync.RWMutex
+	current     *jwtsvid.SVID
+	lastRefresh time.Time
+	// Correlation ID of the last refresh attempt of the JWT SVID
+	lastRefreshID string
+	// Identity labels of the current series of the expiry metric
+	expiryLabels []string
+
+	// Context of the fetches of the refresh loops in daemon mode, cancelled
+	// on shutdown apart from the loops
+	fetchCtx context.Context
+
+	// Read locked by every fetch and write of a JWT SVID, locked on shutdown
+	// to wait for the one in flight
+	inflight sync.RWMutex
+
+	// Connection to the SPIFFE agent shared by concurrent fetches, when set
+	source *workloadapi.JWTSource
+
+	// Lock serializing fetches with --token-refresh-lock-file
+	refreshLock *refreshLock
+
+	// TLS configuration of a tcp:// Workload API, when set
+	workloadTLS *tls.Config
+
+	// Consecutive rejected JWT SVIDs since the last good one, reported by /status
+	rejections int
+
+	// Index of the current audience of --audience-round-robin
+	audienceIndex int
+
+	// Weights of the audiences of --audience-round-robin, nil to cycle through them
+	audienceWeights []int
+
+	// Compiled --audience-validation-pattern in regex mode
+	audienceRegexp *regexp.Regexp
+
+	// Next refresh forced by --refresh-cron, reported by /status
+	nextCronRefresh time.Time
+
+	// Health of the primary token followed by every --token, in daemon mode
+	tokenStates []*tokenState
+
+	// Level of the raised expiry warning, empty if none
+	expiryAlert string
+
+	// Aggregate of --token-map-file, nil if unset
+	tokenMap *tokenMap
+
+	// Static labels of --label and the Downward API, nil if none
+	metricLabels map[string]string
+
+	// Subscribers of /events
+	events eventBroker
+
+	// Subscribers of WatchToken of the token API
+	tokenWatchers tokenWatchers
+
+	// Outcome of the last write to each sink, reported by /status
+	sinkHealth map[string]sinkStatus
+
+	// Key ID last written to --signing-key-jwk-file
+	signingKeyID string
+
+	// Resolved configuration reported by /debug/dump, when enabled
+	configEntries []configEntry
+
+	// Parsed --notify-signal, when --notify-pid-file is set
+	notifySignal syscall.Signal
+
+	// Selectors of --fetch-user-context, nil if unset or unsupported
+	fetchSelectors []string
+
+	// SIDs granted access to token files on Windows, nil elsewhere
+	tokenFileTrustees []string
+
+	// Bearer token of the admin endpoints, empty if they are disabled
+	adminToken string
+
+	// Client credentials and trust bundles of /introspect, when enabled
+	introspectToken     string
+	introspectClientCAs *x509.CertPool
+	introspectBundles   *workloadapi.JWTSource
+
+	// Limiter of --max-fetches-per-minute, nil when unlimited
+	fetchBudget *rate.Limiter
 
 	// Atomic flag to track if initial JWT has been fetched
 	started int32 // 0 = false, 1 = true
+
+	// Atomic flag set once draining, readiness is withdrawn while refreshes continue
+	draining int32 // 0 = false, 1 = true
+
+	// Atomic flag set while refreshes are paused through the admin server
+	pausedFlag int32 // 0 = false, 1 = true
 }
 
 func main() {
-	s := &SpiffeJWT{}
-	kong.Parse(s)
+	cli := &CLI{}
+	report := &configReport{}
+	ctx := kong.Parse(cli, kong.Bind(report))
+	if cli.Run.EnableDebugDump {
+		cli.Run.configEntries = resolvedConfig(ctx, report)
+	}
+	setupLogging(cli.LogFormat, cli.LogOutput, cli.LogIncludeHostname)
+	err := ctx.Run()
+	if strings.HasPrefix(ctx.Command(), "run") {
+		cli.Run.reportMetricsOnExit(err)
+	}
+	ctx.FatalIfErrorf(err)
+}
+
+// Run fetches the JWT SVID once or, in daemon mode, keeps it refreshed
+func (s *SpiffeJWT) Run() error {
+	if s.SpiffeAgentSocketEnv != "" {
+		socket := os.Getenv(s.SpiffeAgentSocketEnv)
+		if socket == "" {
+			return fmt.Errorf("environment variable %s of --spiffe-agent-socket-env is not set", s.SpiffeAgentSocketEnv)
+		}
+		logrus.Infof("Using SPIFFE agent socket %s from %s", socket, s.SpiffeAgentSocketEnv)
+		s.SpiffeAgentSocket = socket
+	}
+
+	if s.Bootstrap.TokenFile != "" {
+		if err := s.bootstrapAgent(); err != nil {
+			return err
+		}
+		if !s.DaemonMode {
+			go s.watchAgent(context.Background())
+		}
+	}
+	if err := checkAgentSocket(s.SpiffeAgentSocket); err != nil {
+		return err
+	}
+	if err := s.setupWorkloadAPITLS(); err != nil {
+		return err
+	}
+
+	if s.ReportMetricsOnExit {
+		if s.PrometheusPushGateway == "" {
+			return errors.New("--report-metrics-on-exit requires --prometheus-push-gateway")
+		}
+		// Fatal errors exit without returning from Run
+		logrus.RegisterExitHandler(func() { s.pushMetrics(1) })
+	}
+	metricsCardinality.limit = s.MetricsCardinalityLimit
+	s.events.max = s.EventsMaxSubscribers
+
+	labels, err := s.resolveLabels()
+	if err != nil {
+		return err
+	}
+	if len(labels) > 0 {
+		s.metricLabels = labels
+		s.events.labels = labels
+		addLabelHook(logrus.StandardLogger(), labels)
+	}
+
+	if s.ConnectCheckOnly {
+		return s.connectCheck()
+	}
+
+	// Not required by --connect-check-only, which writes nothing
+	if s.JWTAudience == "" && len(s.AudienceRoundRobin) == 0 && s.AudienceFromJWT == "" {
+		return errors.New("missing flags: --jwt-audience, --audience-round-robin or --audience-from-jwt")
+	}
+	if s.JWTFileName == "" && !s.JSONOutput {
+		return errors.New("missing flags: --jwt-file-name or --json-output")
+	}
+
+	if s.Mlock {
+		if runtime.GOOS != "linux" {
+			return errors.New("--mlock is only supported on Linux")
+		}
+		mlockSecrets = true
+		if err := lockAllMemory(); err != nil {
+			logrus.WithError(err).Warn("Only secret buffers are locked in memory with --mlock, the JWT SVID may still be swapped")
+		} else {
+			logrus.Info("Process memory locked with --mlock")
+		}
+	}
+
+	if s.TokenPersistenceMode == "encrypted" {
+		if s.EncryptionKeyFile == "" {
+			return errors.New("--encryption-key-file is required in encrypted persistence mode")
+		}
+		key, err := loadEncryptionKey(s.EncryptionKeyFile)
+		if err != nil {
+			return err
+		}
+		s.encryptionKey = newSecretBuffer(key).Bytes()
+	}
+
+	if len(s.Tokens) > 0 {
+		if err := s.validateTokens(); err != nil {
+			return err
+		}
+	}
+	if err := s.validateAudiences(); err != nil {
+		return err
+	}
+
+	switch s.AudienceValidationMode {
+	case "regex":
+		if s.AudiencePattern == "" {
+			return errors.New("--audience-validation-pattern is required with --audience-validation-mode=regex")
+		}
+		re, err := regexp.Compile("^(?:" + s.AudiencePattern + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid --audience-validation-pattern: %w", err)
+		}
+		s.audienceRegexp = re
+	case "exact":
+		if s.AudiencePattern != "" {
+			return errors.New("--audience-validation-pattern requires --audience-validation-mode=prefix or regex")
+		}
+	}
+
+	if s.Profile != "" && s.Config == "" {
+		return errors.New("--profile requires --config")
+	}
+	if s.MetricsPort != "" && !validPort(s.MetricsPort) {
+		return fmt.Errorf("invalid --metrics-port %q, expected a port number", s.MetricsPort)
+	}
+	if s.ReadyFile != "" && !s.DaemonMode {
+		return errors.New("--ready-file is only supported in daemon mode")
+	}
+	if s.EnableAdminAPI {
+		if !s.DaemonMode {
+			return errors.New("--enable-admin-api is only supported in daemon mode")
+		}
+		if !validPort(s.AdminPort) {
+			return fmt.Errorf("invalid --admin-port %q, expected a port number", s.AdminPort)
+		}
+	}
+	if s.AdminSocket != "" && !s.EnableAdminAPI {
+		return errors.New("--admin-socket requires --enable-admin-api")
+	}
+	if s.EnableDebugDump && !s.EnableAdminAPI {
+		return errors.New("--enable-debug-dump requires --enable-admin-api")
+	}
+	if s.TokenAPISocket != "" {
+		if !s.DaemonMode {
+			return errors.New("--token-api-socket is only supported in daemon mode")
+		}
+		if runtime.GOOS != "linux" {
+			return errors.New("--token-api-socket is only supported on Linux")
+		}
+	}
+	if s.NotifyPIDFile != "" {
+		sig, err := parseSignal(s.NotifySignal)
+		if errors.Is(err, errors.ErrUnsupported) {
+			return fmt.Errorf("--notify-pid-file is not supported on %s", runtime.GOOS)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid --notify-signal: %w", err)
+		}
+		s.notifySignal = sig
+	}
+	if runtime.GOOS == "windows" {
+		trustees, err := resolveTrustees(s.TokenFileWindowsACL)
+		if err != nil {
+			return fmt.Errorf("invalid --token-file-windows-acl: %w", err)
+		}
+		s.tokenFileTrustees = trustees
+	} else if len(s.TokenFileWindowsACL) > 0 {
+		return errors.New("--token-file-windows-acl is only supported on Windows")
+	}
+	if err := s.parseFetchUserContext(); err != nil {
+		return err
+	}
+	if s.AdoptExistingToken && (!s.DaemonMode || s.JWTFileName == "" || s.OutputFormat == "kubeconfig") {
+		return errors.New("--adopt-existing-token requires daemon mode and --jwt-file-name with --output-format=raw")
+	}
+	if s.WatchBundleChanges && !s.DaemonMode {
+		return errors.New("--watch-bundle-changes is only supported in daemon mode")
+	}
+	if s.TokenIncludeRawHeader && (s.JWTFileName == "" || s.OutputFormat == "kubeconfig") {
+		return errors.New("--token-include-raw-header requires --jwt-file-name with --output-format=raw")
+	}
+	if s.TokenReadAudit && (!s.DaemonMode || s.JWTFileName == "") {
+		return errors.New("--token-read-audit requires daemon mode and --jwt-file-name")
+	}
+	if s.HealthReadHeaderTimeout < 0 {
+		return errors.New("--health-read-header-timeout must not be negative")
+	}
+	if s.EventsMaxSubscribers < 0 {
+		return errors.New("--events-max-subscribers must not be negative")
+	}
+	if s.ExitAfterRotations < 0 {
+		return errors.New("--exit-after-rotations must not be negative")
+	}
+	if s.ExitAfterRotations > 0 && !s.DaemonMode {
+		return errors.New("--exit-after-rotations is only supported in daemon mode")
+	}
+	if s.FetchTimeout <= 0 {
+		return errors.New("--fetch-timeout must be positive")
+	}
+	if s.BundleRefreshInterval <= 0 {
+		return errors.New("--bundle-refresh-interval must be positive")
+	}
+	if s.InitialFetchRetries < 0 {
+		return errors.New("--initial-fetch-retries must not be negative")
+	}
+	if s.InitialFetchSync && s.InitialFetchTimeout <= 0 {
+		return errors.New("--initial-fetch-timeout must be positive")
+	}
+	if s.ExpiredResponseCode < 200 || s.ExpiredResponseCode > 599 {
+		return fmt.Errorf("invalid --http-response-code-on-expired %d, expected an HTTP status code", s.ExpiredResponseCode)
+	}
+	if err := s.validateReadinessPolicy(); err != nil {
+		return err
+	}
+	if err := s.validateExpiryThresholds(); err != nil {
+		return err
+	}
+
+	var refreshCron cron.Schedule
+	if s.RefreshCron != "" {
+		if !s.DaemonMode {
+			return errors.New("--refresh-cron is only supported in daemon mode")
+		}
+		var err error
+		if refreshCron, err = s.parseRefreshCron(); err != nil {
+			return err
+		}
+	}
+
+	if s.JSONOutput {
+		if s.DaemonMode {
+			return errors.New("--json-output is only supported in one-shot mode, set --daemon-mode=false")
+		}
+		if len(s.Tokens) > 0 || s.TokenMapFile != "" || s.OutputFormat != "raw" || s.encryptionKey != nil {
+			return errors.New("--json-output cannot be combined with --token, --token-map-file, --output-format=kubeconfig or encrypted persistence, which write files")
+		}
+	}
+
+	if s.OutputFormat == "kubeconfig" && s.encryptionKey != nil {
+		return errors.New("--output-format=kubeconfig cannot be used in encrypted persistence mode")
+	}
+
+	if s.AudienceFromJWT != "" {
+		aud, err := audienceFromJWT(s.AudienceFromJWT, s.encryptionKey)
+		if err != nil {
+			return err
+		}
+		logrus.Infof("Using audience %q from %s", aud, s.AudienceFromJWT)
+		s.JWTAudience = aud
+	}
+
+	if len(s.AudienceWeights) > 0 && len(s.AudienceRoundRobin) == 0 {
+		return errors.New("--audience-weight requires --audience-round-robin")
+	}
+	if len(s.AudienceRoundRobin) > 0 {
+		if err := s.startAudienceRoundRobin(); err != nil {
+			return err
+		}
+	}
+
+	if s.TokenMapFile != "" {
+		if err := s.validateTokenMap(); err != nil {
+			return err
+		}
+		s.loadTokenMap()
+	}
+
+	if s.FileWriteMode == "atomic-per-dir" {
+		s.removeStaleTempFiles()
+	}
+
+	if s.AdminTokenFile != "" {
+		token, err := os.ReadFile(s.AdminTokenFile)
+		if err != nil {
+			return fmt.Errorf("failed to read admin token file: %w", err)
+		}
+		s.adminToken = strings.TrimSpace(string(token))
+		if s.adminToken == "" {
+			return fmt.Errorf("admin token file %s is empty", s.AdminTokenFile)
+		}
+	}
+
+	s.fetchBudget = newFetchBudget(s.MaxFetchesPerMinute)
+	if s.TokenRefreshLockFile != "" {
+		var err error
+		if s.refreshLock, err = newRefreshLock(s.TokenRefreshLockFile); err != nil {
+			return err
+		}
+	}
+
+	if s.TokenFileACL != "" {
+		if _, err := exec.LookPath("setfacl"); err != nil {
+			logrus.WithError(err).Warn("setfacl is not in PATH, --token-file-acl is skipped")
+			s.TokenFileACL = ""
+		}
+	}
+
+	if s.RequireHardenedMount {
+		if err := s.checkHardenedMounts(); err != nil {
+			return err
+		}
+	}
+
+	if s.AuditLogFile != "" {
+		s.audit = &auditLog{path: s.AuditLogFile, maxSize: s.AuditLogMaxSize}
+	}
+
+	if err := s.setupSinks(context.Background()); err != nil {
+		return fmt.Errorf("unable to set up output targets: %w", err)
+	}
+
+	if s.IntrospectionAudience != "" {
+		if err := s.setupIntrospection(); err != nil {
+			return err
+		}
+		defer s.introspectBundles.Close()
+	}
+
+	var tokenAPI net.Listener
+	if s.TokenAPISocket != "" {
+		var err error
+		if tokenAPI, err = s.listenTokenAPI(); err != nil {
+			return err
+		}
+	}
+	var admin net.Listener
+	if s.EnableAdminAPI {
+		var err error
+		if admin, err = s.listenAdmin(); err != nil {
+			return err
+		}
+	}
+
+	if s.Sandbox {
+		policy := s.sandboxPolicy()
+		if err := applySandbox(policy); err != nil {
+			return fmt.Errorf("failed to apply --sandbox: %w", err)
+		}
+		logSandbox(policy)
+	}
 
 	if s.DaemonMode {
-		logrus.Info("Running in daemon mode")
-		go s.run()
-		s.startHealthServer()
+		if !s.InitialFetchSync {
+			logrus.Info("Running in daemon mode")
+		}
+		s.refreshCh = make(chan struct{}, 1)
+		s.initTokenStates()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		fetchCtx, cancelFetches := context.WithCancel(context.Background())
+		defer cancelFetches()
+		s.fetchCtx = fetchCtx
+		if s.ExitAfter > 0 {
+			logrus.Infof("Exiting after %s", s.ExitAfter)
+			ctx, cancel = context.WithTimeout(ctx, s.ExitAfter)
+			defer cancel()
+		}
+		if s.ExitAfterRotations > 0 {
+			logrus.Infof("Exiting after %d rotations", s.ExitAfterRotations)
+			s.stats.changed = make(chan struct{}, 1)
+			go s.exitAfterRotations(ctx, cancel)
+		}
+
+		go s.handleSignals(cancel)
+		if s.agentExited != nil {
+			go s.watchAgent(ctx)
+		}
+		if s.InitialFetchSync {
+			jwt := s.syncInitialFetch(ctx)
+			go s.refreshLoop(ctx, jwt)
+		} else {
+			go func() {
+				s.refreshLoop(ctx, s.initialFetch(ctx))
+			}()
+		}
+		for _, t := range s.Tokens {
+			go s.tokenLoop(ctx, t)
+		}
+		if refreshCron != nil {
+			go s.cronLoop(ctx, refreshCron)
+		}
+		if s.WatchBundleChanges {
+			go s.watchBundleChanges(ctx)
+		}
+		if s.ExpiryWarningThreshold > 0 || s.ExpiryCriticalThreshold > 0 {
+			go s.expiryLoop(ctx)
+		}
+		if s.TokenReadAudit {
+			go s.watchTokenReads(ctx)
+		}
+		if s.EnableAdminAPI {
+			go s.startAdminServer(ctx, admin)
+		}
+		if s.TokenAPISocket != "" {
+			go s.serveTokenAPI(ctx, tokenAPI)
+		}
+		if s.ReadyFile != "" {
+			// Fatal errors exit without returning from Run
+			logrus.RegisterExitHandler(s.removeReadyFile)
+			go s.readyFileLoop(ctx)
+		}
+		s.startHealthServer(ctx)
+		s.finishInflight(cancelFetches)
+		if s.ReadyFile != "" {
+			s.removeReadyFile()
+		}
+
+		if s.ExitAfter > 0 || s.ExitAfterRotations > 0 {
+			s.stats.logSummary()
+			if n := s.stats.totalFailures(); n > s.ExitAfterMaxFailures {
+				return fmt.Errorf("%d failures exceed the tolerated %d", n, s.ExitAfterMaxFailures)
+			}
+		}
+	} else if len(s.Tokens) > 0 {
+		logrus.Info("Running in one-shot mode")
+		return s.fetchAndWriteTokens()
 	} else {
 		logrus.Info("Running in one-shot mode")
-		jwt, err := s.fetchAndWriteJWTSVID()
+		jwt, err := s.fetchAndWriteJWTSVID(context.Background())
 		if err != nil {
-			logrus.WithError(err).Fatal("unable to fetch or write JWT SVID, shutting down")
+			failureLog(err).Fatal("unable to fetch or write JWT SVID, shutting down")
+		}
+		if s.wantsBundle() {
+			if err := s.fetchAndWriteJWTBundle(context.Background(), jwt.ID.TrustDomain()); err != nil {
+				logrus.WithError(err).WithField(failureClassField, failureBundle).Fatal("unable to fetch or write JWT bundle, shutting down")
+			}
 		}
 		logrus.Infof("JWT SVID fetched and written, it expires in %s", time.Until(jwt.Expiry))
 	}
+	return nil
+}
+
+// syncInitialFetch runs initialFetch in the foreground, so that a valid JWT
+// SVID is on disk before the health server starts. It shuts down when the
+// first fetch does not complete within --initial-fetch-timeout.
+func (s *SpiffeJWT) syncInitialFetch(ctx context.Context) *jwtsvid.SVID {
+	logrus.Infof("Fetching the first JWT SVID before starting, waiting up to %s", s.InitialFetchTimeout)
+	done := make(chan *jwtsvid.SVID, 1)
+	go func() {
+		done <- s.initialFetch(ctx)
+	}()
+
+	timer := time.NewTimer(s.InitialFetchTimeout)
+	defer timer.Stop()
+	select {
+	case jwt := <-done:
+		logrus.Info("Running in daemon mode")
+		return jwt
+	case <-timer.C:
+		logrus.WithField(failureClassField, failureFetch).Fatalf("first JWT SVID not written within %s, shutting down", s.InitialFetchTimeout)
+		return nil
+	}
 }
 
-// run is the main loop of SpiffeJWT. It fetches a JWT SVID from the SPIFFE agent,
-// writes it to a file and refreshes it periodically.
-func (s *SpiffeJWT) run() {
-	jwt, err := s.fetchAndWriteJWTSVID()
-	if err != nil {
-		logrus.WithError(err).Fatal("unable to fetch or write JWT SVID, shutting down")
+// initialFetch fetches and writes the first JWT SVID, retrying up to
+// --initial-fetch-retries times, and the first bundle if one is written.
+// Failures past the retries are fatal. It returns nil when shutdown
+// interrupts the fetch. With --adopt-existing-token a valid JWT SVID on disk
+// stands in for the first one.
+func (s *SpiffeJWT) initialFetch(ctx context.Context) *jwtsvid.SVID {
+	if s.AdoptExistingToken {
+		if jwt := s.adoptExistingToken(); jwt != nil {
+			s.recordToken(primaryTokenName, jwt, nil)
+			s.initialBundle(ctx, jwt)
+			return jwt
+		}
+	}
+
+	wait := tokenRetryMin
+	for attempt := 0; ; attempt++ {
+		jwt, err := s.fetchAndWriteJWTSVID(s.fetchCtx)
+		if err != nil && ctx.Err() != nil {
+			logrus.WithError(err).Info("First JWT SVID fetch interrupted by shutdown")
+			return nil
+		}
+		s.publishRefresh(jwt, err)
+		s.recordToken(primaryTokenName, jwt, err)
+		if err == nil {
+			s.initialBundle(ctx, jwt)
+			return jwt
+		}
+		if attempt >= s.InitialFetchRetries {
+			failureLog(err).Fatal("unable to fetch or write JWT SVID, shutting down")
+		}
+		failureLog(err).Warnf("unable to fetch or write the first JWT SVID, retrying in %s", wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			logrus.Info("First JWT SVID fetch interrupted by shutdown")
+			return nil
+		case <-timer.C:
+		}
+		wait = min(2*wait, tokenRetryMax)
+	}
+}
+
+// initialBundle writes the first bundle and starts the bundle loop.
+// The first bundle must be on disk before the health check reports started
+func (s *SpiffeJWT) initialBundle(ctx context.Context, jwt *jwtsvid.SVID) {
+	if !s.wantsBundle() {
+		return
+	}
+	td := jwt.ID.TrustDomain()
+	if err := s.fetchAndWriteJWTBundle(ctx, td); err != nil {
+		logrus.WithError(err).WithField(failureClassField, failureBundle).Fatal("unable to fetch or write JWT bundle, shutting down")
+	}
+	if !s.RefreshOnStartupOnly {
+		go s.bundleLoop(ctx, td)
+	}
+}
+
+// refreshLoop is the main loop of SpiffeJWT. Starting from the first JWT
+// SVID, it refreshes the JWT SVID from the SPIFFE agent and writes it to a
+// file periodically until ctx is done.
+func (s *SpiffeJWT) refreshLoop(ctx context.Context, jwt *jwtsvid.SVID) {
+	// The first fetch was interrupted by shutdown
+	if jwt == nil {
+		return
 	}
 
 	// Set started flag atomically (for health check)
 	atomic.StoreInt32(&s.started, 1)
 
+	if s.RefreshOnStartupOnly {
+		s.awaitExpiry(ctx, jwt)
+		return
+	}
+
 	// Calculate and set initial refresh interval
 	intv := s.getRefreshInterval(jwt)
 	logrus.Infof("Ticker started, refreshing JWT SVID in %s", intv)
 	ticker := time.NewTicker(intv)
 	defer ticker.Stop()
+	lastRefresh := time.Now()
+	rejections := 0
 
 	for {
 		select {
+		case <-ctx.Done():
+			logrus.Info("Refresh loop stopped")
+			return
 		case <-ticker.C:
-			jwt, err := s.fetchAndWriteJWTSVID()
-			if err != nil {
-				logrus.WithError(err).Fatal("unable to fetch or write JWT SVID, shutting down")
+		case <-s.refreshCh:
+			// Forced refreshes within the cooldown are dropped to avoid refresh storms
+			if wait := time.Until(lastRefresh.Add(s.ForcedRefreshCooldown)); wait > 0 {
+				logrus.Warnf("Ignoring forced refresh, cooldown active for another %s", wait.Round(time.Millisecond))
+				continue
 			}
+			logrus.Info("Forcing JWT SVID refresh")
+		}
+		if s.paused() {
+			logrus.Warn("Skipping JWT SVID refresh, refreshes are paused")
+			continue
+		}
+
+		if len(s.AudienceRoundRobin) > 0 {
+			s.nextAudience()
+		}
+
+		next, err := s.fetchAndWriteJWTSVID(s.fetchCtx)
+		if err != nil && ctx.Err() != nil {
+			logrus.WithError(err).Info("JWT SVID refresh interrupted by shutdown")
+			return
+		}
+		s.publishRefresh(next, err)
+		s.recordToken(primaryTokenName, next, err)
+		var rejected *rejectedTokenError
+		if errors.As(err, &rejected) {
+			// The last known good JWT SVID stays in place until it nears expiry
+			wait, ok := s.rejectedRetry(jwt, rejections)
+			rejections++
+			if !ok {
+				failureLog(err).Fatalf("JWT SVID rejected and the last known good one expires within %s, shutting down", s.RejectedFatalBefore)
+			}
+			failureLog(err).Warnf("JWT SVID rejected, keeping the last known good one and retrying in %s", wait)
+			ticker.Reset(wait)
+			continue
+		}
+		if err != nil {
+			failureLog(err).Fatal("unable to fetch or write JWT SVID, shutting down")
+		}
+		jwt, rejections, lastRefresh = next, 0, time.Now()
 
-			// Update refresh interval based on new token expiry
-			intv := s.getRefreshInterval(jwt)
-			logrus.Infof("JWT SVID will be refreshed in %s", intv)
-			ticker.Reset(intv)
+		// A new signing key may be in the bundle before the next bundle refresh
+		if s.SigningKeyJWKFile != "" && s.signingKeyChanged(jwt) {
+			if err := s.fetchAndWriteJWTBundle(s.fetchCtx, jwt.ID.TrustDomain()); err != nil {
+				s.stats.recordFailure(failureBundle)
+				logrus.WithError(err).WithField(failureClassField, failureBundle).Error("unable to refresh JWT signing key")
+			}
 		}
+
+		// Update refresh interval based on new token expiry, which also
+		// reschedules the timer after a forced refresh
+		intv := s.getRefreshInterval(jwt)
+		logrus.Infof("JWT SVID will be refreshed in %s", intv)
+		ticker.Reset(intv)
+	}
+}
+
+// awaitExpiry stands in for the refresh loop with --token-refresh-on-startup-only.
+// The health server keeps running, but the daemon exits once the token expires.
+func (s *SpiffeJWT) awaitExpiry(ctx context.Context, jwt *jwtsvid.SVID) {
+	logrus.Infof("Not refreshing the JWT SVID, exiting when it expires in %s", time.Until(jwt.Expiry).Round(time.Second))
+	timer := time.NewTimer(time.Until(jwt.Expiry))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		logrus.Info("Refresh loop stopped")
+	case <-timer.C:
+		logrus.Fatal("JWT SVID expired and --token-refresh-on-startup-only is set, shutting down")
+	}
+}
+
+// fetchAndWriteJWTSVID refreshes the JWT SVID under a new correlation ID,
+// logged as refresh_id and reported by /status. Its errors carry the ID.
+func (s *SpiffeJWT) fetchAndWriteJWTSVID(ctx context.Context) (*jwtsvid.SVID, error) {
+	ctx, id := withRefreshID(ctx)
+	s.mu.Lock()
+	s.lastRefreshID = id
+	s.mu.Unlock()
+
+	jwt, err := s.refreshJWTSVID(ctx)
+	if err != nil {
+		return nil, &refreshError{id: id, err: err}
 	}
+	return jwt, nil
 }
 
-// fetchAndWriteJWTSVID fetches a JWT SVID from the SPIFFE agent and writes it to a file
-func (s *SpiffeJWT) fetchAndWriteJWTSVID() (*jwtsvid.SVID, error) {
-	jwt, err := s.fetchJWTSVID()
+// refreshJWTSVID fetches a JWT SVID from the SPIFFE agent and writes it to a
+// file and the output targets. With --fetch-deadline-propagation the whole
+// of it is bounded by --fetch-timeout.
+func (s *SpiffeJWT) refreshJWTSVID(ctx context.Context) (*jwtsvid.SVID, error) {
+	s.inflight.RLock()
+	defer s.inflight.RUnlock()
+
+	if s.DeadlinePropagation {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.FetchTimeout)
+		defer cancel()
+	}
+
+	jwt, err := s.fetchJWTSVID(ctx)
+	var rejected *rejectedTokenError
+	if errors.As(err, &rejected) {
+		return nil, s.rejected(rejected)
+	}
+	if err != nil {
+		s.stats.recordFailure(failureFetch)
+		return nil, &classifiedError{class: failureFetch, err: fmt.Errorf("failed to fetch JWT: %w", err)}
+	}
+
+	if err := s.validateJWTSVID(jwt, s.JWTAudience); err != nil {
+		return nil, s.rejected(err)
+	}
+
+	previousID, err := s.checkIdentityChange(ctx, jwt)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch JWT: %w", err)
+		return nil, err
 	}
 
-	if err := s.writeJWTSVID(jwt); err != nil {
-		return nil, fmt.Errorf("failed to write JWT: %w", err)
+	start := time.Now()
+	err = s.writeJWTSVID(ctx, jwt)
+	took := time.Since(start)
+	writeDuration.Observe(took.Seconds())
+	logrus.WithContext(ctx).Debugf("JWT SVID write took %s", took)
+	if err != nil {
+		s.stats.recordFailure(failureWrite)
+		writeErrors.WithLabelValues("file").Inc()
+		return nil, &classifiedError{class: failureWrite, err: fmt.Errorf("failed to write JWT: %w", err)}
+	}
+	s.stats.recordRotation(time.Until(jwt.Expiry))
+	s.mu.Lock()
+	s.current, s.lastRefresh, s.rejections = jwt, time.Now(), 0
+	s.mu.Unlock()
+	rotations.WithLabelValues(s.identityLabels(jwt.ID)...).Inc()
+	s.recordExpiry(jwt)
+	s.clearExpiryAlert()
+	s.updateTokenMap(ctx, s.JWTAudience, jwt)
+	if s.audit != nil {
+		if err := s.audit.append(newTokenAuditRecord(jwt)); err != nil {
+			writeErrors.WithLabelValues("audit").Inc()
+			logrus.WithContext(ctx).WithError(err).Error("unable to record JWT SVID in the audit log")
+		}
+	}
+	s.writeSinks(ctx, jwt)
+	s.tokenWatchers.publish(jwt)
+	if s.NotifyPIDFile != "" {
+		s.notifyCompanion(ctx)
+	}
+	if previousID != "" && s.IdentityChangeHook != "" {
+		s.runIdentityChangeHook(ctx, previousID, jwt)
 	}
 
 	return jwt, nil
 }
 
 // fetchJWTSVID fetches a JWT SVID from the SPIFFE agent
-func (s *SpiffeJWT) fetchJWTSVID() (*jwtsvid.SVID, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (s *SpiffeJWT) fetchJWTSVID(ctx context.Context) (*jwtsvid.SVID, error) {
+	return s.fetchJWTSVIDForAudience(ctx, s.JWTAudience)
+}
+
+// fetchJWTSVIDForAudience fetches a JWT SVID for an audience from the SPIFFE agent
+func (s *SpiffeJWT) fetchJWTSVIDForAudience(ctx context.Context, audience string) (*jwtsvid.SVID, error) {
+	if err := s.awaitFetchBudget(ctx, "JWT SVID fetch"); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.FetchTimeout)
 	defer cancel()
 
-	// Create connection to SPIFFE agent
-	jwtSource, err := workloadapi.NewJWTSource(ctx,
-		workloadapi.WithClientOptions(workloadapi.WithAddr("unix://"+s.SpiffeAgentSocket)),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create JWT source: %w", err)
+	if s.refreshLock != nil {
+		if err := s.refreshLock.acquire(ctx); err != nil {
+			return nil, err
+		}
+		defer s.refreshLock.release()
+	}
+
+	// Reuse the shared connection to the SPIFFE agent, if any
+	jwtSource := s.source
+	if jwtSource == nil {
+		var err error
+		if jwtSource, err = s.newJWTSource(ctx); err != nil {
+			return nil, err
+		}
+		defer jwtSource.Close()
 	}
-	logrus.Info("JWT source created")
-	defer jwtSource.Close()
 
 	// Fetch validated JWT SVID
-	jwt, err := jwtSource.FetchJWTSVID(ctx, jwtsvid.Params{Audience: s.JWTAudience})
+	params := jwtsvid.Params{Audience: audience}
+	if s.fetchSelectors != nil {
+		setExtraSelectors(&params, s.fetchSelectors)
+	}
+	if s.SVIDHint != "" {
+		svids, err := jwtSource.FetchJWTSVIDs(ctx, params)
+		if err != nil {
+			return nil, fmt.Errorf("unable to fetch JWT SVIDs: %w", err)
+		}
+		logrus.WithContext(ctx).Infof("%d JWT SVIDs fetched and validated", len(svids))
+		return s.selectHint(ctx, svids)
+	}
+	jwt, err := jwtSource.FetchJWTSVID(ctx, params)
 	if err != nil {
 		return nil, fmt.Errorf("unable to fetch JWT SVID: %w", err)
 	}
-	logrus.Info("JWT SVID fetched and validated")
+	logrus.WithContext(ctx).Info("JWT SVID fetched and validated")
 
 	return jwt, nil
 }
 
-// writeJWTSVID writes a JWT SVID to a file with secure permissions
-func (s *SpiffeJWT) writeJWTSVID(jwt *jwtsvid.SVID) error {
-	err := os.WriteFile(s.JWTFileName, []byte(jwt.Marshal()), 0644)
+// newJWTSource connects to the SPIFFE agent
+func (s *SpiffeJWT) newJWTSource(ctx context.Context) (*workloadapi.JWTSource, error) {
+	jwtSource, err := workloadapi.NewJWTSource(ctx, workloadapi.WithClientOptions(s.clientOptions()...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JWT source: %w", err)
+	}
+	logrus.WithContext(ctx).Info("JWT source created")
+	return jwtSource, nil
+}
+
+// clientOptions returns the options used to connect to the SPIFFE agent
+func (s *SpiffeJWT) clientOptions() []workloadapi.ClientOption {
+	opts := []workloadapi.ClientOption{
+		workloadapi.WithAddr(workloadAPIAddr(s.SpiffeAgentSocket)),
+	}
+	if s.WorkloadAPIUserAgent != "" {
+		opts = append(opts, workloadapi.WithDialOptions(grpc.WithUserAgent(s.WorkloadAPIUserAgent)))
+	}
+	if s.workloadTLS != nil {
+		opts = append(opts, workloadapi.WithDialOptions(grpc.WithContextDialer(s.dialWorkloadAPI)))
+	}
+	return opts
+}
+
+// connectCheck fetches the JWT bundles from the SPIFFE agent. The Workload
+// API only serves attested workloads, so success proves that the agent is up
+// and recognises this workload, without minting a JWT SVID.
+func (s *SpiffeJWT) connectCheck() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	bundles, err := workloadapi.FetchJWTBundles(ctx, s.clientOptions()...)
+	if err != nil {
+		return fmt.Errorf("SPIFFE agent connectivity check failed: %w", err)
+	}
+	tds := make([]string, 0, bundles.Len())
+	for _, bundle := range bundles.Bundles() {
+		tds = append(tds, bundle.TrustDomain().String())
+	}
+	logrus.Infof("SPIFFE agent at %s is reachable, JWT bundles of %s", s.SpiffeAgentSocket, strings.Join(tds, ", "))
+	return nil
+}
+
+// validPort reports whether port is a TCP port number
+func validPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n >= 1 && n <= 65535
+}
+
+// workloadAPIAddr turns --spiffe-agent-socket into a Workload API address,
+// plain paths are unix sockets
+func workloadAPIAddr(socket string) string {
+	if strings.Contains(socket, "://") {
+		return socket
+	}
+	return "unix://" + socket
+}
+
+// checkAgentSocket makes sure a unix agent socket is actually a socket, as a
+// regular file or directory mounted in its place fails later with a cryptic
+// dial error. Other address schemes are not checked.
+func checkAgentSocket(socket string) error {
+	path, ok := agentSocketPath(socket)
+	if !ok {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		// The agent may create it later, fetching will retry until then
+		logrus.Warnf("SPIFFE agent socket %s does not exist yet", path)
+		return nil
+	case err != nil:
+		return fmt.Errorf("unable to stat SPIFFE agent socket: %w", err)
+	case info.IsDir():
+		return fmt.Errorf("SPIFFE agent socket %s is a directory, check the volume mount", path)
+	case info.Mode()&fs.ModeSocket == 0:
+		return fmt.Errorf("SPIFFE agent socket %s is a %s, not a unix socket, check the volume mount", path, fileKind(info.Mode()))
+	}
+	return nil
+}
+
+// fileKind describes the type of a file for error messages
+func fileKind(mode fs.FileMode) string {
+	switch {
+	case mode.IsRegular():
+		return "regular file"
+	case mode&fs.ModeNamedPipe != 0:
+		return "named pipe"
+	case mode&fs.ModeDevice != 0:
+		return "device"
+	default:
+		return mode.Type().String()
+	}
+}
+
+// writeJWTSVID writes a JWT SVID to a file with secure permissions, or to
+// stdout with --json-output. Nothing is written once ctx is done.
+func (s *SpiffeJWT) writeJWTSVID(ctx context.Context, jwt *jwtsvid.SVID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.JSONOutput {
+		return writeJSONOutput(ctx, os.Stdout, jwt)
+	}
+	if s.OutputFormat == "kubeconfig" {
+		return s.writeKubeconfig(ctx, jwt)
+	}
+	if err := s.writeTokenFile(ctx, s.JWTFileName, jwt); err != nil {
+		return err
+	}
+	if s.TokenIncludeRawHeader {
+		return s.writeTokenHeader(ctx, s.JWTFileName, jwt)
+	}
+	return nil
+}
+
+// writeTokenFile writes a JWT SVID to path, encrypted in encrypted persistence mode
+func (s *SpiffeJWT) writeTokenFile(ctx context.Context, path string, jwt *jwtsvid.SVID) error {
+	data := jwt.Marshal()
+	if s.encryptionKey != nil {
+		var err error
+		if data, err = encryptToken(s.encryptionKey, data); err != nil {
+			return fmt.Errorf("failed to encrypt JWT: %w", err)
+		}
+	}
+
+	err := s.writeTokenOutputFile(path, []byte(data), 0644)
 	if err != nil {
 		return fmt.Errorf("failed to write JWT file: %w", err)
 	}
-	logrus.Infof("JWT SVID written to %s", s.JWTFileName)
+	if s.Xattr {
+		setTokenXattrs(ctx, path, jwt)
+	}
+	if s.TokenFileACL != "" {
+		setFileACL(ctx, path, s.TokenFileACL)
+	}
+	logrus.WithContext(ctx).WithFields(svidFields(jwt)).Infof("JWT SVID written to %s", path)
+	return nil
+}
+
+// jsonOutput is the object printed by --json-output
+type jsonOutput struct {
+	Token    string    `json:"token"`
+	SpiffeID string    `json:"spiffe_id"`
+	Audience []string  `json:"audience"`
+	Expiry   time.Time `json:"expiry"`
+}
+
+// writeJSONOutput prints a JWT SVID to w as a single JSON object
+func writeJSONOutput(ctx context.Context, w io.Writer, jwt *jwtsvid.SVID) error {
+	err := json.NewEncoder(w).Encode(jsonOutput{
+		Token:    jwt.Marshal(),
+		SpiffeID: jwt.ID.String(),
+		Audience: jwt.Audience,
+		Expiry:   jwt.Expiry,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to print JWT: %w", err)
+	}
+	logrus.WithContext(ctx).WithFields(svidFields(jwt)).Info("JWT SVID printed to stdout")
+	return nil
+}
+
+// svidFields returns the log fields identifying a JWT SVID
+func svidFields(jwt *jwtsvid.SVID) logrus.Fields {
+	return logrus.Fields{
+		"spiffe_id": jwt.ID.String(),
+		"audience":  strings.Join(jwt.Audience, ","),
+	}
+}
+
+// writeKubeconfig writes the JWT SVID as the user token of a kubeconfig,
+// creating it if missing
+func (s *SpiffeJWT) writeKubeconfig(ctx context.Context, jwt *jwtsvid.SVID) error {
+	existing, err := readFileLimited(s.JWTFileName, maxReadBackSize)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to read kubeconfig: %w", err)
+	}
+
+	var data []byte
+	if len(existing) == 0 {
+		data, err = newKubeconfig(s.Kubeconfig, jwt.Marshal())
+	} else {
+		data, err = updateKubeconfig(existing, s.Kubeconfig, jwt.Marshal())
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := s.writeTokenOutputFile(s.JWTFileName, data, 0600); err != nil {
+		return fmt.Errorf("failed to write kubeconfig: %w", err)
+	}
+	logrus.WithContext(ctx).WithFields(svidFields(jwt)).Infof("JWT SVID written to kubeconfig %s", s.JWTFileName)
 	return nil
 }
 
@@ -132,8 +1247,11 @@ func (s *SpiffeJWT) writeJWTSVID(jwt *jwtsvid.SVID) error {
 // 1. Use override if set and valid
 // 2. Never exceed 80% of token lifetime
 // 3. Default to 50% of remaining lifetime
+//
+// The remaining lifetime is measured against the expiry plus TokenExpirySlack,
+// so a local clock slightly ahead of the issuer does not cut it short.
 func (s *SpiffeJWT) getRefreshInterval(svid *jwtsvid.SVID) time.Duration {
-	remaining := time.Until(svid.Expiry)
+	remaining := time.Until(svid.Expiry.Add(s.TokenExpirySlack))
 	maxAllowed := time.Duration(float64(remaining) * 0.8) // Use 80% of total lifetime
 
 	// Calculate proposed interval
@@ -155,27 +1273,3 @@ func (s *SpiffeJWT) getRefreshInterval(svid *jwtsvid.SVID) time.Duration {
 
 	return intv
 }
-
-// startHealthServer runs HTTP server for health checks
-func (s *SpiffeJWT) startHealthServer() {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/started", func(w http.ResponseWriter, r *http.Request) {
-		if atomic.LoadInt32(&s.started) == 1 {
-			w.WriteHeader(http.StatusOK)
-		} else {
-			w.WriteHeader(http.StatusServiceUnavailable)
-		}
-	})
-
-	server := &http.Server{
-		Addr:         ":" + s.HealthPort,
-		Handler:      mux,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-	}
-
-	logrus.Infof("Starting health server on port %s", s.HealthPort)
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		logrus.WithError(err).Fatal("Health server failed")
-	}
-}
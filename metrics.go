@@ -0,0 +1,202 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// writeErrors counts failed writes of the JWT SVID by output target
+	writeErrors = limitedCounterVec{promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "spiffe_jwt_write_errors_total",
+		Help: "Number of failed writes of the JWT SVID by output target.",
+	}, []string{"target"}), "spiffe_jwt_write_errors_total"}
+
+	// writeDuration observes the time taken by writes of the JWT SVID
+	writeDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "spiffe_jwt_write_duration_seconds",
+		Help:    "Time taken to write the JWT SVID to its output file or stdout.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 4, 9),
+	})
+
+	// sinkUp reports whether the last write to each sink succeeded
+	sinkUp = limitedGaugeVec{promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "spiffe_jwt_sink_up",
+		Help: "Whether the last write of the JWT SVID to the sink succeeded.",
+	}, []string{"sink"}), "spiffe_jwt_sink_up"}
+
+	// sinkPluginRestarts counts the restarts of sink plugins after they exited
+	// or failed their handshake
+	sinkPluginRestarts = limitedCounterVec{promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "spiffe_jwt_sink_plugin_restarts_total",
+		Help: "Number of restarts of sink plugins by sink.",
+	}, []string{"sink"}), "spiffe_jwt_sink_plugin_restarts_total"}
+
+	// rejectedTokens counts fetched JWT SVIDs that failed validation and were
+	// never written
+	rejectedTokens = limitedCounterVec{promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "spiffe_jwt_rejected_total",
+		Help: "Number of fetched JWT SVIDs rejected by validation, by reason.",
+	}, []string{"reason"}), "spiffe_jwt_rejected_total"}
+
+	// hintMismatches counts the fetches without a JWT SVID of --svid-hint
+	hintMismatches = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "spiffe_jwt_svid_hint_mismatch_total",
+		Help: "Number of fetches where no JWT SVID carried --svid-hint.",
+	})
+
+	// activeAudience reports the current audience of --audience-round-robin
+	activeAudience = limitedGaugeVec{promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "spiffe_jwt_active_audience",
+		Help: "Whether the audience is the one the JWT SVID is currently fetched for with --audience-round-robin.",
+	}, []string{"audience"}), "spiffe_jwt_active_audience"}
+
+	// expiryAlerts reports the raised expiry warning by level
+	expiryAlerts = limitedGaugeVec{promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "spiffe_jwt_expiry_warning",
+		Help: "Whether the JWT SVID is within the expiry threshold of the level without having been refreshed.",
+	}, []string{"level"}), "spiffe_jwt_expiry_warning"}
+
+	// tokenReads counts the opens of the token file by other processes with
+	// --token-read-audit, by executable
+	tokenReads = limitedCounterVec{promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "spiffe_jwt_token_reads_total",
+		Help: "Number of opens of the JWT file by other processes, by executable.",
+	}, []string{"exe"}), "spiffe_jwt_token_reads_total"}
+
+	// healthRejectedConnections counts health server connections turned away
+	// by --health-max-connections
+	healthRejectedConnections = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "spiffe_jwt_health_rejected_connections_total",
+		Help: "Number of health server connections rejected over --health-max-connections.",
+	})
+
+	// bundleChanges counts the changes of the X.509 bundles seen with
+	// --watch-bundle-changes
+	bundleChanges = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "spiffe_jwt_bundle_changes_total",
+		Help: "Number of X.509 bundle changes that forced a JWT SVID refresh.",
+	})
+
+	// rotations counts the written JWT SVIDs by identity labels
+	rotations = limitedCounterVec{promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "spiffe_jwt_rotations_total",
+		Help: "Number of JWT SVIDs written, by trust domain and first path segment of the SPIFFE ID.",
+	}, identityLabelNames), "spiffe_jwt_rotations_total"}
+
+	// tokenExpiry reports the expiry of the current JWT SVID, only for its
+	// current identity labels
+	tokenExpiry = limitedGaugeVec{promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "spiffe_jwt_expiry_timestamp_seconds",
+		Help: "Expiry of the current JWT SVID as a Unix timestamp, by trust domain and first path segment of the SPIFFE ID.",
+	}, identityLabelNames), "spiffe_jwt_expiry_timestamp_seconds"}
+
+	// identityChanges counts fetched JWT SVIDs whose SPIFFE ID differs from
+	// the previously written one
+	identityChanges = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "spiffe_jwt_identity_changes_total",
+		Help: "Number of times the SPIFFE ID of the JWT SVID changed between refreshes.",
+	})
+
+	// introspections counts the calls of /introspect by outcome: active,
+	// inactive, unauthorized or invalid_request
+	introspections = limitedCounterVec{promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "spiffe_jwt_introspections_total",
+		Help: "Number of token introspections by outcome.",
+	}, []string{"outcome"}), "spiffe_jwt_introspections_total"}
+
+	// workloadAPIThrottled counts Workload API calls deferred by
+	// --max-fetches-per-minute
+	workloadAPIThrottled = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "spiffe_jwt_workload_api_throttled_total",
+		Help: "Number of Workload API calls deferred by --max-fetches-per-minute.",
+	})
+)
+
+// metricsCardinality bounds the label value combinations of all labelled
+// metrics, per --metrics-cardinality-limit
+var metricsCardinality = &cardinalityLimit{}
+
+// Unregistered metrics standing in for the series dropped over the limit
+var (
+	droppedCounter = prometheus.NewCounter(prometheus.CounterOpts{Name: "dropped"})
+	droppedGauge   = prometheus.NewGauge(prometheus.GaugeOpts{Name: "dropped"})
+)
+
+// cardinalityLimit counts the label value combinations in use across
+// metrics. Once the limit is reached new combinations are dropped, with a
+// warning per metric, while the existing series keep being updated.
+type cardinalityLimit struct {
+	mu      sync.Mutex
+	limit   int
+	series  map[string]bool
+	dropped map[string]bool
+}
+
+// allow reports whether the series of metric with the label values may be used
+func (c *cardinalityLimit) allow(metric string, lvs []string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := metric + "\x00" + strings.Join(lvs, "\x00")
+	if c.series[key] {
+		return true
+	}
+	if c.limit > 0 && len(c.series) >= c.limit {
+		if !c.dropped[metric] {
+			if c.dropped == nil {
+				c.dropped = map[string]bool{}
+			}
+			c.dropped[metric] = true
+			logrus.Warnf("Metric cardinality limit of %d reached, dropping new series of %s", c.limit, metric)
+		}
+		return false
+	}
+	if c.series == nil {
+		c.series = map[string]bool{}
+	}
+	c.series[key] = true
+	return true
+}
+
+// forget releases the series of metric with the label values, once deleted
+func (c *cardinalityLimit) forget(metric string, lvs []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.series, metric+"\x00"+strings.Join(lvs, "\x00"))
+}
+
+// limitedCounterVec is a CounterVec subject to metricsCardinality
+type limitedCounterVec struct {
+	*prometheus.CounterVec
+	name string
+}
+
+func (v limitedCounterVec) WithLabelValues(lvs ...string) prometheus.Counter {
+	if !metricsCardinality.allow(v.name, lvs) {
+		return droppedCounter
+	}
+	return v.CounterVec.WithLabelValues(lvs...)
+}
+
+// limitedGaugeVec is a GaugeVec subject to metricsCardinality
+type limitedGaugeVec struct {
+	*prometheus.GaugeVec
+	name string
+}
+
+func (v limitedGaugeVec) WithLabelValues(lvs ...string) prometheus.Gauge {
+	if !metricsCardinality.allow(v.name, lvs) {
+		return droppedGauge
+	}
+	return v.GaugeVec.WithLabelValues(lvs...)
+}
+
+func (v limitedGaugeVec) DeleteLabelValues(lvs ...string) bool {
+	metricsCardinality.forget(v.name, lvs)
+	return v.GaugeVec.DeleteLabelValues(lvs...)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// allocLocked maps anonymous memory of at least n bytes, locked so that it
+// is never swapped out and excluded from core dumps
+func allocLocked(n int) ([]byte, error) {
+	page := unix.Getpagesize()
+	mem, err := unix.Mmap(-1, 0, (n+page-1)/page*page, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Mlock(mem); err != nil {
+		unix.Munmap(mem)
+		return nil, err
+	}
+	_ = unix.Madvise(mem, unix.MADV_DONTDUMP)
+	return mem, nil
+}
+
+// freeLocked unmaps memory of allocLocked
+func freeLocked(mem []byte) {
+	_ = unix.Munmap(mem)
+}
+
+// lockAllMemory locks every current and future page of the process with
+// mlockall. Under a finite RLIMIT_MEMLOCK without CAP_IPC_LOCK, locking
+// future mappings would make the Go runtime fail to grow the heap, so it
+// is not attempted and the limit is returned in the error.
+func lockAllMemory() error {
+	var limit unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_MEMLOCK, &limit); err != nil {
+		return fmt.Errorf("failed to get RLIMIT_MEMLOCK: %w", err)
+	}
+	if limit.Cur != unix.RLIM_INFINITY && !hasCapability(unix.CAP_IPC_LOCK) {
+		return fmt.Errorf("RLIMIT_MEMLOCK of %d bytes is too low to lock the whole process, raise it to unlimited or grant CAP_IPC_LOCK", limit.Cur)
+	}
+	return unix.Mlockall(unix.MCL_CURRENT | unix.MCL_FUTURE | unix.MCL_ONFAULT)
+}
+
+// hasCapability reports whether the process has an effective capability
+func hasCapability(capability uint) bool {
+	header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capget(&header, &data[0]); err != nil {
+		return false
+	}
+	return data[capability/32].Effective&(1<<(capability%32)) != 0
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// allocLocked is not supported outside of Linux
+func allocLocked(n int) ([]byte, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// freeLocked is not supported outside of Linux
+func freeLocked(mem []byte) {}
+
+// lockAllMemory is not supported outside of Linux
+func lockAllMemory() error {
+	return errors.ErrUnsupported
+}
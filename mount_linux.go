@@ -0,0 +1,13 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+// mountFlags reports whether the filesystem holding path is mounted noexec
+// and nosuid
+func mountFlags(path string) (noexec, nosuid bool, err error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return false, false, err
+	}
+	return st.Flags&unix.ST_NOEXEC != 0, st.Flags&unix.ST_NOSUID != 0, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// mountFlags is not supported outside of Linux
+func mountFlags(path string) (noexec, nosuid bool, err error) {
+	return false, false, errors.ErrUnsupported
+}
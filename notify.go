@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// notifyCompanion signals the process whose PID is in --notify-pid-file
+// that the JWT SVID was rotated, for proxies that reload their credentials
+// on a signal. The file is read every time, the companion may have
+// restarted. A failure is only logged.
+func (s *SpiffeJWT) notifyCompanion(ctx context.Context) {
+	log := logrus.WithContext(ctx).WithField("pid_file", s.NotifyPIDFile)
+	pid, err := readPIDFile(s.NotifyPIDFile)
+	if err != nil {
+		log.WithError(err).Warn("unable to notify companion process")
+		return
+	}
+	proc, err := os.FindProcess(pid)
+	if err == nil {
+		err = proc.Signal(s.notifySignal)
+	}
+	if err != nil {
+		log.WithError(err).Warnf("unable to send %s to companion process %d", s.NotifySignal, pid)
+		return
+	}
+	log.Debugf("Sent %s to companion process %d", s.NotifySignal, pid)
+}
+
+// readPIDFile reads the PID of a process from its PID file
+func readPIDFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid PID %q in %s", strings.TrimSpace(string(data)), path)
+	}
+	return pid, nil
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// parseSignal is not supported outside of Unix systems
+func parseSignal(name string) (syscall.Signal, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// peerCredentials is not supported outside of Linux
+func peerCredentials(conn net.Conn) (uid, pid int, err error) {
+	return 0, 0, errors.ErrUnsupported
+}
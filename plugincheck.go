@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/CentML/spiffe-jwt/sinkplugin"
+)
+
+// SinkPluginCheckCmd runs the conformance checks of the sinkplugin protocol
+// against a plugin, the way the daemon drives it
+type SinkPluginCheckCmd struct {
+	Plugin  string        `arg:"" help:"Executable of the sink plugin." type:"existingfile"`
+	Timeout time.Duration `help:"Time the plugin has to answer each request." default:"10s"`
+}
+
+// pluginCheck is one conformance check, run against the plugin started by
+// the handshake check
+type pluginCheck struct {
+	name string
+	run  func(*pluginProcess) error
+}
+
+// Run starts the plugin, prints the outcome of every check and fails if any
+// of them failed
+func (c *SinkPluginCheckCmd) Run() error {
+	token := conformanceToken()
+	checks := []pluginCheck{
+		{"write is acknowledged", func(p *pluginProcess) error {
+			return c.expect(p, sinkplugin.Request{ID: 1, Type: sinkplugin.TypeWrite, Token: token}, false)
+		}},
+		{"second write is acknowledged", func(p *pluginProcess) error {
+			return c.expect(p, sinkplugin.Request{ID: 2, Type: sinkplugin.TypeWrite, Token: token}, false)
+		}},
+		{"unknown request type is answered with an error", func(p *pluginProcess) error {
+			return c.expect(p, sinkplugin.Request{ID: 3, Type: "conformance-unknown"}, true)
+		}},
+		{"write after an error is acknowledged", func(p *pluginProcess) error {
+			return c.expect(p, sinkplugin.Request{ID: 4, Type: sinkplugin.TypeWrite, Token: token}, false)
+		}},
+		{"exits once stdin is closed", func(p *pluginProcess) error {
+			return p.stop()
+		}},
+	}
+
+	proc, err := startPlugin(c.Plugin, "check")
+	if err == nil && proc.handshake.Name == "" {
+		proc.kill()
+		err = errors.New("the handshake has no name")
+	}
+	if !report("handshake", err) {
+		return errors.New("the plugin failed its handshake")
+	}
+
+	failed := 0
+	for _, check := range checks {
+		if !report(check.name, check.run(proc)) {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks)+1)
+	}
+	fmt.Printf("Plugin %s conforms to version %d of the protocol\n", proc.handshake.Name, sinkplugin.ProtocolVersion)
+	return nil
+}
+
+// expect sends a request and checks that the plugin answers it, with an
+// error or not
+func (c *SinkPluginCheckCmd) expect(p *pluginProcess, req sinkplugin.Request, wantError bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+	resp, err := p.call(ctx, req)
+	switch {
+	case err != nil:
+		return err
+	case wantError && resp.Error == "":
+		return errors.New("the response has no error")
+	case !wantError && resp.Error != "":
+		return fmt.Errorf("the response has an error: %s", resp.Error)
+	}
+	return nil
+}
+
+// report prints the outcome of a check and whether it passed
+func report(name string, err error) bool {
+	if err != nil {
+		fmt.Printf("FAIL %s: %v\n", name, err)
+		return false
+	}
+	fmt.Printf("PASS %s\n", name)
+	return true
+}
+
+// conformanceToken is the unsigned JWT delivered by the checks. Plugins are
+// handed validated JWT SVIDs and must not verify them again.
+func conformanceToken() *sinkplugin.Token {
+	enc := base64.RawURLEncoding
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	header := enc.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	claims := enc.EncodeToString([]byte(fmt.Sprintf(`{"sub":"spiffe://example.org/conformance","aud":["conformance"],"exp":%d}`, expiry.Unix())))
+	return &sinkplugin.Token{
+		Token:    header + "." + claims + ".",
+		SpiffeID: "spiffe://example.org/conformance",
+		Audience: []string{"conformance"},
+		Expiry:   expiry,
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// buildExamplePlugin builds the reference sink plugin into a temporary
+// directory and returns its path
+func buildExamplePlugin(t *testing.T) string {
+	t.Helper()
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("the go tool is not available to build the example plugin")
+	}
+	path := filepath.Join(t.TempDir(), "file-sink")
+	if runtime.GOOS == "windows" {
+		path += ".exe"
+	}
+	if out, err := exec.Command(goTool, "build", "-o", path, "./sinkplugin/example").CombinedOutput(); err != nil {
+		t.Fatalf("failed to build the example plugin: %v\n%s", err, out)
+	}
+	return path
+}
+
+// TestSinkPluginCheckExample runs the conformance checks against the
+// reference plugin, which must pass them and write the delivered token
+func TestSinkPluginCheckExample(t *testing.T) {
+	plugin := buildExamplePlugin(t)
+
+	t.Run("conforms", func(t *testing.T) {
+		sink := filepath.Join(t.TempDir(), "token")
+		t.Setenv("SINK_FILE", sink)
+		c := &SinkPluginCheckCmd{Plugin: plugin, Timeout: 10 * time.Second}
+		if err := c.Run(); err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		got, err := os.ReadFile(sink)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Count(string(got), ".") != 2 {
+			t.Errorf("sink file holds %q, want a compact JWT", got)
+		}
+	})
+
+	// Without SINK_FILE the plugin exits before its handshake
+	t.Run("no handshake", func(t *testing.T) {
+		t.Setenv("SINK_FILE", "")
+		c := &SinkPluginCheckCmd{Plugin: plugin, Timeout: 10 * time.Second}
+		if err := c.Run(); err == nil {
+			t.Fatal("Run succeeded against a plugin that exits at start")
+		}
+	})
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+
+	"github.com/CentML/spiffe-jwt/sinkplugin"
+)
+
+// Supervision of sink plugins
+const (
+	// pluginHandshakeTimeout bounds the wait for the handshake of a plugin
+	pluginHandshakeTimeout = 10 * time.Second
+	// pluginStopTimeout bounds the wait for a plugin to exit once its stdin
+	// is closed, it is killed afterwards
+	pluginStopTimeout = 5 * time.Second
+	// A plugin that exits is restarted after a backoff between
+	// pluginRestartMin and pluginRestartMax, reset once it ran for
+	// pluginRestartMax
+	pluginRestartMin = time.Second
+	pluginRestartMax = time.Minute
+	// pluginMaxAttempts is the number of attempts of a write to a plugin
+	pluginMaxAttempts = 3
+)
+
+// pluginProcess is a running sink plugin
+type pluginProcess struct {
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	responses chan sinkplugin.Response
+	handshake sinkplugin.Handshake
+	// exited is closed once the process exited, err is its exit error
+	exited chan struct{}
+	err    error
+}
+
+// startPlugin starts the plugin at path and waits for its handshake. Its
+// stderr is logged with the plugin field set to name.
+func startPlugin(path, name string) (*pluginProcess, error) {
+	cmd := exec.Command(path)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start plugin: %w", err)
+	}
+
+	p := &pluginProcess{
+		cmd:       cmd,
+		stdin:     stdin,
+		responses: make(chan sinkplugin.Response, 16),
+		exited:    make(chan struct{}),
+	}
+	log := logrus.WithField("plugin", name)
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			log.Info(scanner.Text())
+		}
+	}()
+
+	handshake := make(chan error, 1)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 0, 64<<10), sinkplugin.MaxMessageSize)
+		if !scanner.Scan() {
+			handshake <- errors.New("plugin closed stdout before the handshake")
+		} else if err := json.Unmarshal(scanner.Bytes(), &p.handshake); err != nil {
+			handshake <- fmt.Errorf("invalid handshake: %w", err)
+		} else {
+			handshake <- nil
+		}
+		for scanner.Scan() {
+			var resp sinkplugin.Response
+			if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+				log.WithError(err).Warn("Ignoring invalid response of sink plugin")
+				continue
+			}
+			// Responses nobody waits for anymore are dropped
+			select {
+			case p.responses <- resp:
+			default:
+			}
+		}
+		// Wait closes the pipes, it must follow the reads
+		p.err = cmd.Wait()
+		close(p.exited)
+	}()
+
+	timer := time.NewTimer(pluginHandshakeTimeout)
+	defer timer.Stop()
+	select {
+	case err = <-handshake:
+	case <-timer.C:
+		err = fmt.Errorf("no handshake within %s", pluginHandshakeTimeout)
+	}
+	if err == nil {
+		err = checkHandshake(p.handshake)
+	}
+	if err != nil {
+		p.kill()
+		return nil, err
+	}
+	return p, nil
+}
+
+// checkHandshake verifies that a plugin speaks our protocol
+func checkHandshake(h sinkplugin.Handshake) error {
+	if h.Protocol != sinkplugin.Protocol {
+		return fmt.Errorf("unknown plugin protocol %q, expected %q", h.Protocol, sinkplugin.Protocol)
+	}
+	if h.Version != sinkplugin.ProtocolVersion {
+		return fmt.Errorf("unsupported plugin protocol version %d, expected %d", h.Version, sinkplugin.ProtocolVersion)
+	}
+	return nil
+}
+
+// call sends a request to the plugin and waits for its response
+func (p *pluginProcess) call(ctx context.Context, req sinkplugin.Request) (sinkplugin.Response, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return sinkplugin.Response{}, err
+	}
+	if _, err := p.stdin.Write(append(data, '\n')); err != nil {
+		return sinkplugin.Response{}, fmt.Errorf("failed to send request to plugin: %w", err)
+	}
+	for {
+		select {
+		case resp := <-p.responses:
+			// Left over from a request that timed out
+			if resp.ID != req.ID {
+				continue
+			}
+			return resp, nil
+		case <-p.exited:
+			return sinkplugin.Response{}, fmt.Errorf("plugin exited: %v", p.err)
+		case <-ctx.Done():
+			return sinkplugin.Response{}, ctx.Err()
+		}
+	}
+}
+
+// stop closes the stdin of the plugin and waits for it to exit, killing it
+// after pluginStopTimeout
+func (p *pluginProcess) stop() error {
+	p.stdin.Close()
+	timer := time.NewTimer(pluginStopTimeout)
+	defer timer.Stop()
+	select {
+	case <-p.exited:
+		return p.err
+	case <-timer.C:
+		p.kill()
+		return fmt.Errorf("plugin did not exit within %s of closing its stdin", pluginStopTimeout)
+	}
+}
+
+// kill terminates the plugin and waits for it to exit
+func (p *pluginProcess) kill() {
+	p.cmd.Process.Kill()
+	p.stdin.Close()
+	<-p.exited
+}
+
+// pluginSink delivers JWT SVIDs to a supervised sink plugin of --sink-plugin
+type pluginSink struct {
+	name string
+	path string
+
+	// calls serializes the requests to the plugin
+	calls  sync.Mutex
+	nextID uint64
+
+	mu sync.Mutex
+	// The running plugin, nil while it is restarting
+	proc *pluginProcess
+	// changed is closed and replaced whenever proc changes
+	changed chan struct{}
+}
+
+// newPluginSink parses a --sink-plugin of the form [name=]path. The name
+// defaults to the base name of the executable.
+func newPluginSink(spec string) (*pluginSink, error) {
+	name, path, ok := strings.Cut(spec, "=")
+	if !ok {
+		name, path = filepath.Base(spec), spec
+	}
+	if name == "" || path == "" {
+		return nil, fmt.Errorf("invalid sink plugin %q, expected [name=]path", spec)
+	}
+	path, err := exec.LookPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("sink plugin %s: %w", name, err)
+	}
+	return &pluginSink{name: name, path: path, changed: make(chan struct{})}, nil
+}
+
+// Name implements Sink
+func (p *pluginSink) Name() string {
+	return "plugin:" + p.name
+}
+
+// supervise keeps the plugin running until ctx is done, restarting it with
+// backoff whenever it exits or fails its handshake. Plugin failures never
+// take the daemon down.
+func (p *pluginSink) supervise(ctx context.Context) {
+	log := logrus.WithField("sink", p.Name())
+	backoff := pluginRestartMin
+	for {
+		started := time.Now()
+		proc, err := startPlugin(p.path, p.name)
+		if err == nil {
+			log.Infof("Sink plugin %s started with PID %d", proc.handshake.Name, proc.cmd.Process.Pid)
+			p.setProcess(proc)
+			select {
+			case <-proc.exited:
+				err = fmt.Errorf("plugin exited: %v", proc.err)
+			case <-ctx.Done():
+				p.setProcess(nil)
+				if err := proc.stop(); err != nil {
+					log.WithError(err).Warn("Sink plugin did not stop cleanly")
+				}
+				return
+			}
+			p.setProcess(nil)
+			if time.Since(started) >= pluginRestartMax {
+				backoff = pluginRestartMin
+			}
+		}
+
+		sinkPluginRestarts.WithLabelValues(p.Name()).Inc()
+		log.WithError(err).Errorf("Sink plugin failed, restarting it in %s", backoff)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		backoff = min(2*backoff, pluginRestartMax)
+	}
+}
+
+// setProcess records the running plugin and wakes up the writes waiting
+// for it
+func (p *pluginSink) setProcess(proc *pluginProcess) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.proc = proc
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// process waits until the plugin runs or ctx is done
+func (p *pluginSink) process(ctx context.Context) (*pluginProcess, error) {
+	for {
+		p.mu.Lock()
+		proc, changed := p.proc, p.changed
+		p.mu.Unlock()
+		if proc != nil {
+			return proc, nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("plugin not running: %w", ctx.Err())
+		}
+	}
+}
+
+// Write implements Sink, retrying failed deliveries up to pluginMaxAttempts
+// times within ctx
+func (p *pluginSink) Write(ctx context.Context, jwt *jwtsvid.SVID) error {
+	p.calls.Lock()
+	defer p.calls.Unlock()
+
+	token := &sinkplugin.Token{
+		Token:    jwt.Marshal(),
+		SpiffeID: jwt.ID.String(),
+		Audience: jwt.Audience,
+		Expiry:   jwt.Expiry,
+	}
+	for attempt := 1; ; attempt++ {
+		err := p.write(ctx, token)
+		if err == nil || attempt == pluginMaxAttempts || ctx.Err() != nil {
+			return err
+		}
+		logrus.WithContext(ctx).WithError(err).WithField("sink", p.Name()).Warnf("Write to sink plugin failed, retrying (attempt %d of %d)", attempt+1, pluginMaxAttempts)
+		timer := time.NewTimer(time.Second)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// write makes a single delivery attempt
+func (p *pluginSink) write(ctx context.Context, token *sinkplugin.Token) error {
+	proc, err := p.process(ctx)
+	if err != nil {
+		return err
+	}
+	p.nextID++
+	resp, err := proc.call(ctx, sinkplugin.Request{ID: p.nextID, Type: sinkplugin.TypeWrite, Token: token, RefreshID: refreshID(ctx)})
+	if err != nil {
+		if ctx.Err() != nil {
+			// The plugin hangs, the supervisor restarts it
+			proc.kill()
+		}
+		return err
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("plugin error: %s", resp.Error)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.1
+// 	protoc        v5.29.3
+// source: callback/v1/callback.proto
+
+package callbackv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type PushTokenRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The JWT SVID in compact serialization.
+	Token string `protobuf:"bytes,1,opt,name=token,proto3" json:"token,omitempty"`
+	// The SPIFFE ID the JWT SVID was issued to.
+	SpiffeId string `protobuf:"bytes,2,opt,name=spiffe_id,json=spiffeId,proto3" json:"spiffe_id,omitempty"`
+	// The audiences of the JWT SVID.
+	Audience []string `protobuf:"bytes,3,rep,name=audience,proto3" json:"audience,omitempty"`
+	// The expiry of the JWT SVID in seconds since the Unix epoch.
+	ExpiresAt     int64 `protobuf:"varint,4,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PushTokenRequest) Reset() {
+	*x = PushTokenRequest{}
+	mi := &file_callback_v1_callback_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PushTokenRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PushTokenRequest) ProtoMessage() {}
+
+func (x *PushTokenRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_callback_v1_callback_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PushTokenRequest.ProtoReflect.Descriptor instead.
+func (*PushTokenRequest) Descriptor() ([]byte, []int) {
+	return file_callback_v1_callback_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *PushTokenRequest) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+func (x *PushTokenRequest) GetSpiffeId() string {
+	if x != nil {
+		return x.SpiffeId
+	}
+	return ""
+}
+
+func (x *PushTokenRequest) GetAudience() []string {
+	if x != nil {
+		return x.Audience
+	}
+	return nil
+}
+
+func (x *PushTokenRequest) GetExpiresAt() int64 {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return 0
+}
+
+type PushTokenResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PushTokenResponse) Reset() {
+	*x = PushTokenResponse{}
+	mi := &file_callback_v1_callback_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PushTokenResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PushTokenResponse) ProtoMessage() {}
+
+func (x *PushTokenResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_callback_v1_callback_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PushTokenResponse.ProtoReflect.Descriptor instead.
+func (*PushTokenResponse) Descriptor() ([]byte, []int) {
+	return file_callback_v1_callback_proto_rawDescGZIP(), []int{1}
+}
+
+var File_callback_v1_callback_proto protoreflect.FileDescriptor
+
+var file_callback_v1_callback_proto_rawDesc = []byte{
+	0x0a, 0x1a, 0x63, 0x61, 0x6c, 0x6c, 0x62, 0x61, 0x63, 0x6b, 0x2f, 0x76, 0x31, 0x2f, 0x63, 0x61,
+	0x6c, 0x6c, 0x62, 0x61, 0x63, 0x6b, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x15, 0x73, 0x70,
+	0x69, 0x66, 0x66, 0x65, 0x6a, 0x77, 0x74, 0x2e, 0x63, 0x61, 0x6c, 0x6c, 0x62, 0x61, 0x63, 0x6b,
+	0x2e, 0x76, 0x31, 0x22, 0x80, 0x01, 0x0a, 0x10, 0x50, 0x75, 0x73, 0x68, 0x54, 0x6f, 0x6b, 0x65,
+	0x6e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x74, 0x6f, 0x6b, 0x65,
+	0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x74, 0x6f, 0x6b, 0x65, 0x6e, 0x12, 0x1b,
+	0x0a, 0x09, 0x73, 0x70, 0x69, 0x66, 0x66, 0x65, 0x5f, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x08, 0x73, 0x70, 0x69, 0x66, 0x66, 0x65, 0x49, 0x64, 0x12, 0x1a, 0x0a, 0x08, 0x61,
+	0x75, 0x64, 0x69, 0x65, 0x6e, 0x63, 0x65, 0x18, 0x03, 0x20, 0x03, 0x28, 0x09, 0x52, 0x08, 0x61,
+	0x75, 0x64, 0x69, 0x65, 0x6e, 0x63, 0x65, 0x12, 0x1d, 0x0a, 0x0a, 0x65, 0x78, 0x70, 0x69, 0x72,
+	0x65, 0x73, 0x5f, 0x61, 0x74, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x65, 0x78, 0x70,
+	0x69, 0x72, 0x65, 0x73, 0x41, 0x74, 0x22, 0x13, 0x0a, 0x11, 0x50, 0x75, 0x73, 0x68, 0x54, 0x6f,
+	0x6b, 0x65, 0x6e, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x32, 0x6f, 0x0a, 0x0d, 0x54,
+	0x6f, 0x6b, 0x65, 0x6e, 0x43, 0x61, 0x6c, 0x6c, 0x62, 0x61, 0x63, 0x6b, 0x12, 0x5e, 0x0a, 0x09,
+	0x50, 0x75, 0x73, 0x68, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x12, 0x27, 0x2e, 0x73, 0x70, 0x69, 0x66,
+	0x66, 0x65, 0x6a, 0x77, 0x74, 0x2e, 0x63, 0x61, 0x6c, 0x6c, 0x62, 0x61, 0x63, 0x6b, 0x2e, 0x76,
+	0x31, 0x2e, 0x50, 0x75, 0x73, 0x68, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x1a, 0x28, 0x2e, 0x73, 0x70, 0x69, 0x66, 0x66, 0x65, 0x6a, 0x77, 0x74, 0x2e, 0x63,
+	0x61, 0x6c, 0x6c, 0x62, 0x61, 0x63, 0x6b, 0x2e, 0x76, 0x31, 0x2e, 0x50, 0x75, 0x73, 0x68, 0x54,
+	0x6f, 0x6b, 0x65, 0x6e, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x42, 0x3b, 0x5a, 0x39,
+	0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x43, 0x65, 0x6e, 0x74, 0x4d,
+	0x4c, 0x2f, 0x73, 0x70, 0x69, 0x66, 0x66, 0x65, 0x2d, 0x6a, 0x77, 0x74, 0x2f, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x2f, 0x63, 0x61, 0x6c, 0x6c, 0x62, 0x61, 0x63, 0x6b, 0x2f, 0x76, 0x31, 0x3b, 0x63,
+	0x61, 0x6c, 0x6c, 0x62, 0x61, 0x63, 0x6b, 0x76, 0x31, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x33,
+}
+
+var (
+	file_callback_v1_callback_proto_rawDescOnce sync.Once
+	file_callback_v1_callback_proto_rawDescData = file_callback_v1_callback_proto_rawDesc
+)
+
+func file_callback_v1_callback_proto_rawDescGZIP() []byte {
+	file_callback_v1_callback_proto_rawDescOnce.Do(func() {
+		file_callback_v1_callback_proto_rawDescData = protoimpl.X.CompressGZIP(file_callback_v1_callback_proto_rawDescData)
+	})
+	return file_callback_v1_callback_proto_rawDescData
+}
+
+var file_callback_v1_callback_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_callback_v1_callback_proto_goTypes = []any{
+	(*PushTokenRequest)(nil),  // 0: spiffejwt.callback.v1.PushTokenRequest
+	(*PushTokenResponse)(nil), // 1: spiffejwt.callback.v1.PushTokenResponse
+}
+var file_callback_v1_callback_proto_depIdxs = []int32{
+	0, // 0: spiffejwt.callback.v1.TokenCallback.PushToken:input_type -> spiffejwt.callback.v1.PushTokenRequest
+	1, // 1: spiffejwt.callback.v1.TokenCallback.PushToken:output_type -> spiffejwt.callback.v1.PushTokenResponse
+	1, // [1:2] is the sub-list for method output_type
+	0, // [0:1] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_callback_v1_callback_proto_init() }
+func file_callback_v1_callback_proto_init() {
+	if File_callback_v1_callback_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_callback_v1_callback_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_callback_v1_callback_proto_goTypes,
+		DependencyIndexes: file_callback_v1_callback_proto_depIdxs,
+		MessageInfos:      file_callback_v1_callback_proto_msgTypes,
+	}.Build()
+	File_callback_v1_callback_proto = out.File
+	file_callback_v1_callback_proto_rawDesc = nil
+	file_callback_v1_callback_proto_goTypes = nil
+	file_callback_v1_callback_proto_depIdxs = nil
+}
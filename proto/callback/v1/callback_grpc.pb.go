@@ -0,0 +1,131 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.29.3
+// source: callback/v1/callback.proto
+
+package callbackv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	TokenCallback_PushToken_FullMethodName = "/spiffejwt.callback.v1.TokenCallback/PushToken"
+)
+
+// TokenCallbackClient is the client API for TokenCallback service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// TokenCallback is implemented by consumers that want refreshed JWT SVIDs
+// pushed to them by spiffe-jwt.
+type TokenCallbackClient interface {
+	// PushToken delivers a refreshed JWT SVID. It is called once per refresh
+	// and retried on transient failures, so it must be idempotent.
+	PushToken(ctx context.Context, in *PushTokenRequest, opts ...grpc.CallOption) (*PushTokenResponse, error)
+}
+
+type tokenCallbackClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTokenCallbackClient(cc grpc.ClientConnInterface) TokenCallbackClient {
+	return &tokenCallbackClient{cc}
+}
+
+func (c *tokenCallbackClient) PushToken(ctx context.Context, in *PushTokenRequest, opts ...grpc.CallOption) (*PushTokenResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PushTokenResponse)
+	err := c.cc.Invoke(ctx, TokenCallback_PushToken_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// TokenCallbackServer is the server API for TokenCallback service.
+// All implementations must embed UnimplementedTokenCallbackServer
+// for forward compatibility.
+//
+// TokenCallback is implemented by consumers that want refreshed JWT SVIDs
+// pushed to them by spiffe-jwt.
+type TokenCallbackServer interface {
+	// PushToken delivers a refreshed JWT SVID. It is called once per refresh
+	// and retried on transient failures, so it must be idempotent.
+	PushToken(context.Context, *PushTokenRequest) (*PushTokenResponse, error)
+	mustEmbedUnimplementedTokenCallbackServer()
+}
+
+// UnimplementedTokenCallbackServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedTokenCallbackServer struct{}
+
+func (UnimplementedTokenCallbackServer) PushToken(context.Context, *PushTokenRequest) (*PushTokenResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PushToken not implemented")
+}
+func (UnimplementedTokenCallbackServer) mustEmbedUnimplementedTokenCallbackServer() {}
+func (UnimplementedTokenCallbackServer) testEmbeddedByValue()                       {}
+
+// UnsafeTokenCallbackServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TokenCallbackServer will
+// result in compilation errors.
+type UnsafeTokenCallbackServer interface {
+	mustEmbedUnimplementedTokenCallbackServer()
+}
+
+func RegisterTokenCallbackServer(s grpc.ServiceRegistrar, srv TokenCallbackServer) {
+	// If the following call pancis, it indicates UnimplementedTokenCallbackServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&TokenCallback_ServiceDesc, srv)
+}
+
+func _TokenCallback_PushToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PushTokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenCallbackServer).PushToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TokenCallback_PushToken_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenCallbackServer).PushToken(ctx, req.(*PushTokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// TokenCallback_ServiceDesc is the grpc.ServiceDesc for TokenCallback service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var TokenCallback_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "spiffejwt.callback.v1.TokenCallback",
+	HandlerType: (*TokenCallbackServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "PushToken",
+			Handler:    _TokenCallback_PushToken_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "callback/v1/callback.proto",
+}
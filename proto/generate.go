@@ -0,0 +1,6 @@
+// Package proto holds the protobuf definitions of the spiffe-jwt APIs and
+// their generated Go code.
+package proto
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative callback/v1/callback.proto
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative token/v1/token.proto
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/md5"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/spiffe/go-spiffe/v2/bundle/jwtbundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+)
+
+// Google Cloud Storage API endpoints used by the JWKS publisher
+const (
+	gcsEndpoint       = "https://storage.googleapis.com/storage/v1"
+	gcsUploadEndpoint = "https://storage.googleapis.com/upload/storage/v1"
+)
+
+// publishRetryInterval is how long a failed publication waits before being
+// retried, unless a newer bundle arrives first
+const publishRetryInterval = 30 * time.Second
+
+// PublishJWKSCmd hosts the OIDC discovery document and JWKS of a trust
+// domain in an S3 or GCS bucket, for federation with cloud providers that
+// fetch them from the issuer URL, without running a discovery service. The
+// documents are uploaded whenever the bundle changes.
+type PublishJWKSCmd struct {
+	SpiffeAgentSocket string        `env:"SPIFFE_AGENT_SOCKET" help:"File name of the SPIFFE agent socket, or a Workload API address such as unix:///path or tcp://host:port" required:""`
+	TrustDomain       string        `env:"TRUST_DOMAIN" help:"Trust domain whose JWT bundle is published." required:""`
+	Issuer            string        `env:"ISSUER" help:"Public HTTPS URL the documents are served from, which must be the issuer of the JWT SVIDs (e.g., https://bucket.s3.amazonaws.com/prefix)." required:""`
+	Destination       string        `env:"DESTINATION" help:"Bucket and prefix to upload to, s3://bucket/prefix or gs://bucket/prefix." required:""`
+	JWKSPath          string        `name:"jwks-path" env:"JWKS_PATH" help:"Path of the JWKS document under the issuer." default:"keys"`
+	CacheControl      string        `env:"CACHE_CONTROL" help:"Cache-Control header of the uploaded documents." default:"public, max-age=300"`
+	FetchTimeout      time.Duration `env:"FETCH_TIMEOUT" help:"Timeout of the JWT SVID fetches of the cloud credentials." default:"10s"`
+
+	AWSRegion              string `name:"aws-region" env:"AWS_REGION" help:"Region of the S3 bucket, resolved by the AWS SDK if unset." group:"S3 destination"`
+	AWSEndpoint            string `name:"aws-endpoint" env:"AWS_ENDPOINT" help:"Endpoint URL of an S3-compatible object store." group:"S3 destination"`
+	AWSRoleARN             string `name:"aws-role-arn" env:"AWS_ROLE_ARN" help:"IAM role to assume with a JWT SVID as web identity, the standard AWS SDK chain is used if unset." group:"S3 destination"`
+	AWSWebIdentityAudience string `name:"aws-web-identity-audience" env:"AWS_WEB_IDENTITY_AUDIENCE" help:"Audience of the JWT SVID presented to AWS STS." default:"sts.amazonaws.com" group:"S3 destination"`
+
+	GCPWorkloadIdentityProvider string `name:"gcp-workload-identity-provider" env:"GCP_WORKLOAD_IDENTITY_PROVIDER" help:"Full resource name of the workload identity pool provider the JWT SVID is exchanged with." group:"GCS destination"`
+	GCPAudience                 string `name:"gcp-audience" env:"GCP_AUDIENCE" help:"Audience of the JWT SVID exchanged with Google STS, https: followed by the provider name if unset." group:"GCS destination"`
+	GCPServiceAccount           string `name:"gcp-service-account" env:"GCP_SERVICE_ACCOUNT" help:"Email of the service account to impersonate, the federated identity is used directly if unset." group:"GCS destination"`
+}
+
+// objectStore is a bucket the JWKS publisher uploads documents to
+type objectStore interface {
+	// md5 returns the hex encoded MD5 of the object, empty if it does not exist
+	md5(ctx context.Context, key string) (string, error)
+	// put uploads the object
+	put(ctx context.Context, key string, data []byte, contentType, cacheControl string) error
+}
+
+// oidcDiscovery is the OpenID Connect discovery document of the issuer
+type oidcDiscovery struct {
+	Issuer                           string   `json:"issuer"`
+	JWKSURI                          string   `json:"jwks_uri"`
+	AuthorizationEndpoint            string   `json:"authorization_endpoint"`
+	ResponseTypesSupported           []string `json:"response_types_supported"`
+	SubjectTypesSupported            []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// jwksWatcher passes the latest bundle of a trust domain to the publisher,
+// dropping older ones that were not published yet
+type jwksWatcher struct {
+	td      spiffeid.TrustDomain
+	updates chan *jwtbundle.Bundle
+}
+
+func (w *jwksWatcher) OnJWTBundlesUpdate(set *jwtbundle.Set) {
+	bundle, ok := set.Get(w.td)
+	if !ok {
+		logrus.Warnf("No JWT bundle for %s in the update", w.td)
+		return
+	}
+	select {
+	case <-w.updates:
+	default:
+	}
+	w.updates <- bundle
+}
+
+func (w *jwksWatcher) OnJWTBundlesWatchError(err error) {
+	logrus.WithError(err).Warn("JWT bundle watch failed, retrying")
+}
+
+// Run publishes the documents on every bundle change until the process is interrupted
+func (c *PublishJWKSCmd) Run() error {
+	td, err := spiffeid.TrustDomainFromString(c.TrustDomain)
+	if err != nil {
+		return fmt.Errorf("invalid --trust-domain: %w", err)
+	}
+	if u, err := url.Parse(c.Issuer); err != nil || u.Scheme != "https" || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("invalid --issuer %q, expected an https URL without query or fragment", c.Issuer)
+	}
+	if c.FetchTimeout <= 0 {
+		return errors.New("--fetch-timeout must be positive")
+	}
+	c.Issuer = strings.TrimSuffix(c.Issuer, "/")
+	c.JWKSPath = strings.Trim(c.JWKSPath, "/")
+	if err := checkAgentSocket(c.SpiffeAgentSocket); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	s := &SpiffeJWT{SpiffeAgentSocket: c.SpiffeAgentSocket, FetchTimeout: c.FetchTimeout}
+	store, prefix, err := c.objectStore(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	watcher := &jwksWatcher{td: td, updates: make(chan *jwtbundle.Bundle, 1)}
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- workloadapi.WatchJWTBundles(ctx, watcher, s.clientOptions()...)
+	}()
+	logrus.Infof("Publishing the JWT bundle of %s to %s", td, c.Destination)
+
+	var pending *jwtbundle.Bundle
+	var retry <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			logrus.Info("Publisher stopped")
+			return nil
+		case err := <-watchErr:
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("unable to watch JWT bundles: %w", err)
+		case pending = <-watcher.updates:
+		case <-retry:
+		}
+
+		if err := c.publish(ctx, store, prefix, pending); err != nil {
+			logrus.WithError(err).Errorf("unable to publish the JWT bundle, retrying in %s", publishRetryInterval)
+			retry = time.After(publishRetryInterval)
+			continue
+		}
+		retry = nil
+	}
+}
+
+// objectStore returns the store of --destination and the key prefix in it
+func (c *PublishJWKSCmd) objectStore(ctx context.Context, s *SpiffeJWT) (objectStore, string, error) {
+	u, err := url.Parse(c.Destination)
+	if err != nil || u.Host == "" {
+		return nil, "", fmt.Errorf("invalid --destination %q, expected s3://bucket/prefix or gs://bucket/prefix", c.Destination)
+	}
+	prefix := strings.Trim(u.Path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	switch u.Scheme {
+	case "s3":
+		opts := []func(*config.LoadOptions) error{}
+		if c.AWSRegion != "" {
+			opts = append(opts, config.WithRegion(c.AWSRegion))
+		}
+		cfg, err := config.LoadDefaultConfig(ctx, opts...)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to load AWS configuration: %w", err)
+		}
+		if c.AWSRoleARN != "" {
+			cfg.Credentials = s.webIdentityCredentials(cfg, c.AWSRoleARN, c.AWSWebIdentityAudience, "spiffe-jwt-publish-jwks")
+		}
+		client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+			if c.AWSEndpoint != "" {
+				o.BaseEndpoint = aws.String(c.AWSEndpoint)
+				o.UsePathStyle = true
+			}
+		})
+		return &s3Store{client: client, bucket: u.Host}, prefix, nil
+	case "gs":
+		if c.GCPWorkloadIdentityProvider == "" {
+			return nil, "", errors.New("--gcp-workload-identity-provider is required with a gs:// destination")
+		}
+		client := &http.Client{Timeout: 10 * time.Second}
+		return &gcsStore{
+			bucket:         u.Host,
+			client:         client,
+			credentials:    s.newGCPCredentials(client, c.GCPWorkloadIdentityProvider, c.GCPAudience, c.GCPServiceAccount),
+			endpoint:       gcsEndpoint,
+			uploadEndpoint: gcsUploadEndpoint,
+		}, prefix, nil
+	}
+	return nil, "", fmt.Errorf("unsupported --destination scheme %q, expected s3 or gs", u.Scheme)
+}
+
+// publish uploads the discovery document and the JWKS of the bundle, each
+// only if it differs from the object in the bucket
+func (c *PublishJWKSCmd) publish(ctx context.Context, store objectStore, prefix string, bundle *jwtbundle.Bundle) error {
+	jwks, algs, err := publicJWKS(bundle)
+	if err != nil {
+		return err
+	}
+	discovery, err := json.Marshal(oidcDiscovery{
+		Issuer:                           c.Issuer,
+		JWKSURI:                          c.Issuer + "/" + c.JWKSPath,
+		ResponseTypesSupported:           []string{"id_token"},
+		SubjectTypesSupported:            []string{"public"},
+		IDTokenSigningAlgValuesSupported: algs,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode discovery document: %w", err)
+	}
+
+	// The keys go first, so that the discovery document never points at a
+	// JWKS without the keys it advertises algorithms for
+	for _, doc := range []struct {
+		key  string
+		data []byte
+	}{
+		{prefix + c.JWKSPath, jwks},
+		{prefix + ".well-known/openid-configuration", discovery},
+	} {
+		ctx, cancel := context.WithTimeout(ctx, sinkWriteTimeout)
+		changed, err := putIfChanged(ctx, store, doc.key, doc.data, c.CacheControl)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to upload %s: %w", doc.key, err)
+		}
+		if changed {
+			logrus.Infof("Published %s to %s", doc.key, c.Destination)
+		} else {
+			logrus.Debugf("%s is up to date", doc.key)
+		}
+	}
+	return nil
+}
+
+// putIfChanged uploads data unless the object already has the same content
+func putIfChanged(ctx context.Context, store objectStore, key string, data []byte, cacheControl string) (bool, error) {
+	sum := md5.Sum(data)
+	current, err := store.md5(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	if current == hex.EncodeToString(sum[:]) {
+		return false, nil
+	}
+	return true, store.put(ctx, key, data, "application/json", cacheControl)
+}
+
+// publicJWKS returns the JWKS of the bundle in the form expected from an
+// OIDC issuer, sorted by key ID so that unchanged keys give identical
+// documents, and the signing algorithms of its keys
+func publicJWKS(bundle *jwtbundle.Bundle) ([]byte, []string, error) {
+	authorities := bundle.JWTAuthorities()
+	kids := make([]string, 0, len(authorities))
+	for kid := range authorities {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	var set jose.JSONWebKeySet
+	seen := map[string]bool{}
+	var algs []string
+	for _, kid := range kids {
+		alg, err := keyAlgorithm(authorities[kid])
+		if err != nil {
+			return nil, nil, fmt.Errorf("key %s: %w", kid, err)
+		}
+		set.Keys = append(set.Keys, jose.JSONWebKey{Key: authorities[kid], KeyID: kid, Algorithm: alg, Use: "sig"})
+		if !seen[alg] {
+			seen[alg] = true
+			algs = append(algs, alg)
+		}
+	}
+	sort.Strings(algs)
+
+	data, err := json.Marshal(set)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode JWKS: %w", err)
+	}
+	return data, algs, nil
+}
+
+// keyAlgorithm returns the JWS algorithm SPIRE signs JWT SVIDs with for a key
+func keyAlgorithm(key crypto.PublicKey) (string, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return string(jose.RS256), nil
+	case *ecdsa.PublicKey:
+		switch k.Curve.Params().BitSize {
+		case 256:
+			return string(jose.ES256), nil
+		case 384:
+			return string(jose.ES384), nil
+		}
+		return "", fmt.Errorf("unsupported curve %s", k.Curve.Params().Name)
+	}
+	return "", fmt.Errorf("unsupported key type %T", key)
+}
+
+// s3Store uploads documents to an S3 bucket
+type s3Store struct {
+	client *s3.Client
+	bucket string
+}
+
+func (s *s3Store) md5(ctx context.Context, key string) (string, error) {
+	out, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key)})
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	// The ETag of a single part upload without KMS is the MD5 of the object,
+	// anything else only causes a redundant upload
+	return strings.Trim(aws.ToString(out.ETag), `"`), nil
+}
+
+func (s *s3Store) put(ctx context.Context, key string, data []byte, contentType, cacheControl string) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:       aws.String(s.bucket),
+		Key:          aws.String(key),
+		Body:         bytes.NewReader(data),
+		ContentType:  aws.String(contentType),
+		CacheControl: aws.String(cacheControl),
+	})
+	return err
+}
+
+// gcsStore uploads documents to a Google Cloud Storage bucket
+type gcsStore struct {
+	bucket      string
+	client      *http.Client
+	credentials *gcpCredentials
+
+	endpoint       string
+	uploadEndpoint string
+}
+
+func (g *gcsStore) md5(ctx context.Context, key string) (string, error) {
+	token, err := g.credentials.token(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var object struct {
+		MD5Hash string `json:"md5Hash"`
+	}
+	err = gcpDo(ctx, g.client, http.MethodGet,
+		fmt.Sprintf("%s/b/%s/o/%s?fields=md5Hash", g.endpoint, url.PathEscape(g.bucket), url.PathEscape(key)), token, nil, &object)
+	var apiErr *gcpAPIError
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	sum, err := base64.StdEncoding.DecodeString(object.MD5Hash)
+	if err != nil {
+		return "", fmt.Errorf("invalid md5Hash of %s: %w", key, err)
+	}
+	return hex.EncodeToString(sum), nil
+}
+
+// put uploads the object with its metadata in a multipart upload, the only
+// kind that sets the Cache-Control of the object in a single request
+func (g *gcsStore) put(ctx context.Context, key string, data []byte, contentType, cacheControl string) error {
+	token, err := g.credentials.token(ctx)
+	if err != nil {
+		return err
+	}
+
+	metadata, err := json.Marshal(map[string]string{
+		"name":         key,
+		"contentType":  contentType,
+		"cacheControl": cacheControl,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode object metadata: %w", err)
+	}
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		data        []byte
+	}{
+		{"application/json; charset=UTF-8", metadata},
+		{contentType, data},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType}})
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(part.data); err != nil {
+			return err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("%s/b/%s/o?uploadType=multipart", g.uploadEndpoint, url.PathEscape(g.bucket)), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "multipart/related; boundary="+mw.Boundary())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return gcpSend(g.client, req, nil)
+}
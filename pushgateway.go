@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/sirupsen/logrus"
+)
+
+// pushJob is the job the metrics are grouped under on the Pushgateway
+const pushJob = "spiffe_jwt"
+
+// Gauges only added to the final push of --report-metrics-on-exit, they are
+// meaningless while running
+var (
+	// lastExitTimestamp reports when the process exited
+	lastExitTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "spiffe_jwt_last_exit_timestamp_seconds",
+		Help: "Unix time at which spiffe-jwt last exited.",
+	})
+
+	// exitCode reports the exit code of the process
+	exitCode = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "spiffe_jwt_exit_code",
+		Help: "Exit code of the last run of spiffe-jwt.",
+	})
+)
+
+// reportMetricsOnExit pushes the metrics with --report-metrics-on-exit as
+// the process exits, with exit code 1 when err is set
+func (s *SpiffeJWT) reportMetricsOnExit(err error) {
+	if !s.ReportMetricsOnExit || s.PrometheusPushGateway == "" {
+		return
+	}
+	code := 0
+	if err != nil {
+		code = 1
+	}
+	s.pushMetrics(code)
+}
+
+// pushMetrics replaces the metrics of this instance on the Pushgateway with
+// the final ones
+func (s *SpiffeJWT) pushMetrics(code int) {
+	lastExitTimestamp.SetToCurrentTime()
+	exitCode.Set(float64(code))
+
+	var gatherer prometheus.Gatherer = prometheus.DefaultGatherer
+	if s.metricLabels != nil {
+		gatherer = newLabelGatherer(gatherer, s.metricLabels)
+	}
+	pusher := push.New(s.PrometheusPushGateway, pushJob).
+		Gatherer(gatherer).
+		Collector(lastExitTimestamp).
+		Collector(exitCode)
+	if hostname, err := os.Hostname(); err == nil {
+		pusher = pusher.Grouping("instance", hostname)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := pusher.PushContext(ctx); err != nil {
+		logrus.WithError(err).Warn("unable to push the metrics to the Pushgateway")
+		return
+	}
+	logrus.Infof("Metrics pushed to %s with exit code %d", s.PrometheusPushGateway, code)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"slices"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// tokenReadRecord is the audit log entry of an open of the token file by
+// another process with --token-read-audit
+type tokenReadRecord struct {
+	Time  time.Time `json:"time"`
+	Event string    `json:"event"`
+	File  string    `json:"file"`
+	PID   int       `json:"pid"`
+	Exe   string    `json:"exe"`
+	UID   int       `json:"uid"`
+}
+
+// recordTokenRead reports an open of the token file by another process,
+// unless its executable is in --token-read-audit-allow
+func (s *SpiffeJWT) recordTokenRead(pid int, exe string, uid int) {
+	if slices.Contains(s.TokenReadAuditAllow, exe) {
+		logrus.Debugf("Token file %s opened by allowed %s (pid %d)", s.JWTFileName, exe, pid)
+		return
+	}
+	tokenReads.WithLabelValues(exe).Inc()
+	logrus.WithFields(logrus.Fields{"pid": pid, "exe": exe, "uid": uid}).Warnf("Token file %s opened by another process", s.JWTFileName)
+	if s.audit == nil {
+		return
+	}
+	record := tokenReadRecord{
+		Time:  time.Now().UTC(),
+		Event: "token_read",
+		File:  s.JWTFileName,
+		PID:   pid,
+		Exe:   exe,
+		UID:   uid,
+	}
+	if err := s.audit.append(record); err != nil {
+		writeErrors.WithLabelValues("audit").Inc()
+		logrus.WithError(err).Error("unable to record the token file read in the audit log")
+	}
+}
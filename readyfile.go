@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// readyFileInterval is how often --ready-file is brought in line with /readyz
+const readyFileInterval = time.Second
+
+// readyFileLoop keeps --ready-file present while /readyz reports ready, so
+// that consumers without an HTTP probe can wait on the file, until ctx is
+// done. A file left by a previous run is removed first.
+func (s *SpiffeJWT) readyFileLoop(ctx context.Context) {
+	s.removeReadyFile()
+	ticker := time.NewTicker(readyFileInterval)
+	defer ticker.Stop()
+
+	present := false
+	for {
+		_, body := s.readiness()
+		if body.Ready != present {
+			present = body.Ready
+			if present {
+				s.createReadyFile()
+			} else {
+				logrus.Infof("Not ready (%s), removing ready file %s", body.Reason, s.ReadyFile)
+				s.removeReadyFile()
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// createReadyFile creates --ready-file empty
+func (s *SpiffeJWT) createReadyFile() {
+	if err := os.WriteFile(s.ReadyFile, nil, 0644); err != nil {
+		logrus.WithError(err).Errorf("unable to create ready file %s", s.ReadyFile)
+		return
+	}
+	logrus.Infof("Ready, created ready file %s", s.ReadyFile)
+}
+
+// removeReadyFile removes --ready-file if present
+func (s *SpiffeJWT) removeReadyFile() {
+	if err := os.Remove(s.ReadyFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		logrus.WithError(err).Errorf("unable to remove ready file %s", s.ReadyFile)
+	}
+}
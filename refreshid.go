@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// refreshIDField is the log field of the correlation ID of a refresh
+const refreshIDField = "refresh_id"
+
+// refreshIDKey is the context key of the correlation ID of a refresh
+type refreshIDKey struct{}
+
+// withRefreshID returns a context carrying a new correlation ID for a
+// refresh attempt, and the ID
+func withRefreshID(ctx context.Context) (context.Context, string) {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	id := hex.EncodeToString(b)
+	return context.WithValue(ctx, refreshIDKey{}, id), id
+}
+
+// refreshID returns the correlation ID of the refresh ctx belongs to, if any
+func refreshID(ctx context.Context) string {
+	id, _ := ctx.Value(refreshIDKey{}).(string)
+	return id
+}
+
+// refreshIDHook adds the correlation ID to the entries logged with the
+// context of a refresh, through logrus.WithContext
+type refreshIDHook struct{}
+
+func (refreshIDHook) Levels() []logrus.Level { return logrus.AllLevels }
+
+func (refreshIDHook) Fire(entry *logrus.Entry) error {
+	if entry.Context == nil {
+		return nil
+	}
+	if id := refreshID(entry.Context); id != "" {
+		entry.Data[refreshIDField] = id
+	}
+	return nil
+}
+
+// refreshError is the failure of a refresh, prefixed with its correlation
+// ID so that it can be found in the logs from the error alone
+type refreshError struct {
+	id  string
+	err error
+}
+
+func (e *refreshError) Error() string { return fmt.Sprintf("refresh %s: %v", e.id, e.err) }
+func (e *refreshError) Unwrap() error { return e.err }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// refreshLockPoll is the interval at which a lock file held by another
+// process is retried
+const refreshLockPoll = 50 * time.Millisecond
+
+// refreshLock serializes the JWT SVID fetches of --token-refresh-lock-file,
+// between the goroutines of this process through sem, and between the
+// processes sharing the file through a lock on it
+type refreshLock struct {
+	sem  chan struct{}
+	file *os.File
+}
+
+// newRefreshLock opens the lock file, checking that it can be locked
+func newRefreshLock(path string) (*refreshLock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open --token-refresh-lock-file: %w", err)
+	}
+	locked, err := tryLockFile(f)
+	if errors.Is(err, errors.ErrUnsupported) {
+		f.Close()
+		return nil, errors.New("--token-refresh-lock-file is only supported on Linux")
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	if locked {
+		unlockFile(f)
+	}
+	return &refreshLock{sem: make(chan struct{}, 1), file: f}, nil
+}
+
+// acquire takes the lock, giving up when ctx is done
+func (l *refreshLock) acquire(ctx context.Context) error {
+	start := time.Now()
+	select {
+	case l.sem <- struct{}{}:
+	case <-ctx.Done():
+		return fmt.Errorf("timed out waiting for the refresh lock: %w", ctx.Err())
+	}
+	for {
+		locked, err := tryLockFile(l.file)
+		if err != nil {
+			<-l.sem
+			return fmt.Errorf("failed to lock %s: %w", l.file.Name(), err)
+		}
+		if locked {
+			break
+		}
+		select {
+		case <-time.After(refreshLockPoll):
+		case <-ctx.Done():
+			<-l.sem
+			return fmt.Errorf("timed out waiting for the lock of %s: %w", l.file.Name(), ctx.Err())
+		}
+	}
+	logrus.Debugf("Refresh lock acquired after %s", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// release lets the next fetch through
+func (l *refreshLock) release() {
+	if err := unlockFile(l.file); err != nil {
+		logrus.WithError(err).Warnf("unable to unlock %s", l.file.Name())
+	}
+	<-l.sem
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// startAudienceRoundRobin validates --audience-round-robin and makes its
+// first audience the current one
+func (s *SpiffeJWT) startAudienceRoundRobin() error {
+	if s.TokenMapFile != "" {
+		return errors.New("--audience-round-robin cannot be used with --token-map-file, the audience of the token changes on every refresh")
+	}
+	if len(s.AudienceWeights) > 0 {
+		weights, err := parseAudienceWeights(s.AudienceWeights, s.AudienceRoundRobin)
+		if err != nil {
+			return err
+		}
+		s.audienceWeights = weights
+		s.audienceIndex = s.weightedAudience()
+	}
+	s.setAudience(s.AudienceRoundRobin[s.audienceIndex])
+	return nil
+}
+
+// parseAudienceWeights returns the weight of every audience of
+// --audience-round-robin from the audience:weight entries of
+// --audience-weight. Audiences without an entry weigh 1.
+func parseAudienceWeights(entries, audiences []string) ([]int, error) {
+	weights := make([]int, len(audiences))
+	for i := range weights {
+		weights[i] = 1
+	}
+	for _, entry := range entries {
+		// Audiences such as api://name contain colons, the weight follows the last one
+		sep := strings.LastIndex(entry, ":")
+		if sep < 0 {
+			return nil, fmt.Errorf("invalid --audience-weight %q, expected audience:weight", entry)
+		}
+		aud, value := strings.TrimSpace(entry[:sep]), entry[sep+1:]
+		weight, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || weight < 1 {
+			return nil, fmt.Errorf("invalid --audience-weight %q, the weight must be a positive integer", entry)
+		}
+		i := slices.Index(audiences, aud)
+		if i < 0 {
+			return nil, fmt.Errorf("--audience-weight %q is not for an audience of --audience-round-robin", entry)
+		}
+		weights[i] = weight
+	}
+	return weights, nil
+}
+
+// nextAudience moves to the next audience of --audience-round-robin, before
+// a refresh. With --audience-weight, it is drawn at random by weight.
+func (s *SpiffeJWT) nextAudience() {
+	if s.audienceWeights != nil {
+		s.audienceIndex = s.weightedAudience()
+	} else {
+		s.audienceIndex = (s.audienceIndex + 1) % len(s.AudienceRoundRobin)
+	}
+	s.setAudience(s.AudienceRoundRobin[s.audienceIndex])
+}
+
+// weightedAudience draws the index of an audience with a probability
+// proportional to its weight
+func (s *SpiffeJWT) weightedAudience() int {
+	total := 0
+	for _, w := range s.audienceWeights {
+		total += w
+	}
+	n := rand.IntN(total)
+	for i, w := range s.audienceWeights {
+		if n < w {
+			return i
+		}
+		n -= w
+	}
+	return len(s.audienceWeights) - 1
+}
+
+// setAudience makes aud the audience of the primary token
+func (s *SpiffeJWT) setAudience(aud string) {
+	if s.JWTAudience != "" {
+		activeAudience.WithLabelValues(s.JWTAudience).Set(0)
+	}
+	s.JWTAudience = aud
+	activeAudience.WithLabelValues(aud).Set(1)
+
+	s.mu.Lock()
+	if len(s.tokenStates) > 0 {
+		s.tokenStates[0].Audience = aud
+	}
+	s.mu.Unlock()
+	logrus.WithField("audience", aud).Infof("Active audience %d of %d", s.audienceIndex+1, len(s.AudienceRoundRobin))
+}
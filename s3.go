@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+)
+
+// S3Config configures the S3-compatible object store output.
+// Credentials are resolved through the standard AWS SDK chain (environment,
+// shared config, web identity, container and instance metadata).
+type S3Config struct {
+	Bucket         string `env:"BUCKET" help:"Bucket to upload the JWT SVID to, enables the S3 output."`
+	Key            string `env:"KEY" help:"Object key to upload the JWT SVID to." default:"jwt-svid"`
+	Region         string `env:"REGION" help:"Region of the bucket, resolved by the AWS SDK if unset."`
+	Endpoint       string `env:"ENDPOINT" help:"Endpoint URL of an S3-compatible object store."`
+	ForcePathStyle bool   `env:"FORCE_PATH_STYLE" help:"Use path-style addressing, required by most S3-compatible stores."`
+	MaxAttempts    int    `env:"MAX_ATTEMPTS" help:"Maximum number of attempts per upload." default:"5"`
+}
+
+// s3Sink uploads JWT SVIDs to a bucket/key of an S3-compatible object store
+type s3Sink struct {
+	client *s3.Client
+	bucket string
+	key    string
+}
+
+// newS3Sink creates an S3 client from the ambient AWS configuration
+func newS3Sink(ctx context.Context, c S3Config) (*s3Sink, error) {
+	opts := []func(*config.LoadOptions) error{
+		config.WithRetryMaxAttempts(c.MaxAttempts),
+	}
+	if c.Region != "" {
+		opts = append(opts, config.WithRegion(c.Region))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if c.Endpoint != "" {
+			o.BaseEndpoint = aws.String(c.Endpoint)
+		}
+		o.UsePathStyle = c.ForcePathStyle
+	})
+
+	return &s3Sink{client: client, bucket: c.Bucket, key: c.Key}, nil
+}
+
+func (s *s3Sink) Name() string {
+	return fmt.Sprintf("s3://%s/%s", s.bucket, s.key)
+}
+
+// Write uploads the JWT SVID, retrying transient failures per the SDK retryer
+func (s *s3Sink) Write(ctx context.Context, jwt *jwtsvid.SVID) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.key),
+		Body:        strings.NewReader(jwt.Marshal()),
+		ContentType: aws.String("application/jwt"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload JWT SVID: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sandboxPolicy is what the daemon may still access once --sandbox is
+// applied, derived from the effective configuration
+type sandboxPolicy struct {
+	// readOnly are files and directories read after startup
+	readOnly []string
+	// readWrite are the directories of the files the daemon writes, and
+	// /dev/null for the programs it runs
+	readWrite []string
+	// executable are the directories of the programs the daemon runs, only
+	// set when exec is allowed
+	executable []string
+	// exec allows running programs, for the hooks, plugins and setfacl
+	exec bool
+	// bindPorts are the TCP ports the HTTP servers listen on
+	bindPorts []uint16
+}
+
+// sandboxSystemPaths are read by the Go runtime and standard library after
+// startup: TLS roots, DNS resolution and time zones
+var sandboxSystemPaths = []string{
+	"/etc/ssl", "/etc/pki", "/etc/ca-certificates", "/usr/share/ca-certificates",
+	"/etc/resolv.conf", "/etc/hosts", "/etc/nsswitch.conf", "/etc/services",
+	"/etc/localtime", "/usr/share/zoneinfo",
+}
+
+// sandboxExecPaths hold the shell and the tools run by hooks when exec is
+// allowed
+var sandboxExecPaths = []string{"/bin", "/sbin", "/usr", "/lib", "/lib64", "/etc"}
+
+// sandboxPolicy derives the paths and ports of --sandbox from the options
+func (s *SpiffeJWT) sandboxPolicy() sandboxPolicy {
+	p := sandboxPolicy{
+		readOnly: slices.Clone(sandboxSystemPaths),
+		exec:     s.IdentityChangeHook != "" || len(s.SinkPlugins) > 0 || s.TokenFileACL != "",
+	}
+	if path, ok := agentSocketPath(s.SpiffeAgentSocket); ok {
+		p.readOnly = append(p.readOnly, path)
+	}
+	// Certificates are reloaded when they change
+	for _, f := range []string{s.HealthTLSCertFile, s.HealthTLSKeyFile, s.WorkloadAPICAFile, s.WorkloadAPICertFile, s.WorkloadAPIKeyFile, s.Kubeconfig.CAFile} {
+		if f != "" {
+			p.readOnly = append(p.readOnly, f)
+		}
+	}
+	if s.NotifyPIDFile != "" {
+		p.readOnly = append(p.readOnly, s.NotifyPIDFile)
+	}
+	if s.TokenReadAudit {
+		p.readOnly = append(p.readOnly, "/proc")
+	}
+
+	// Files are written atomically next to their destination
+	files := s.outputFiles()
+	for _, f := range []string{s.AuditLogFile, s.ReadyFile, s.TokenRefreshLockFile, s.TokenAPISocket, s.AdminSocket} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	for _, f := range files {
+		if dir := filepath.Dir(f); !slices.Contains(p.readWrite, dir) {
+			p.readWrite = append(p.readWrite, dir)
+		}
+	}
+
+	if p.exec {
+		p.readWrite = append(p.readWrite, "/dev/null")
+		p.executable = slices.Clone(sandboxExecPaths)
+		for _, spec := range s.SinkPlugins {
+			if plugin, err := newPluginSink(spec); err == nil {
+				p.executable = append(p.executable, filepath.Dir(plugin.path))
+			}
+		}
+	}
+
+	for _, port := range []string{s.HealthPort, s.MetricsPort} {
+		if n, err := strconv.ParseUint(port, 10, 16); err == nil {
+			p.bindPorts = append(p.bindPorts, uint16(n))
+		}
+	}
+	if s.EnableAdminAPI && s.AdminSocket == "" {
+		if n, err := strconv.ParseUint(s.AdminPort, 10, 16); err == nil {
+			p.bindPorts = append(p.bindPorts, uint16(n))
+		}
+	}
+	return p
+}
+
+// logSandbox logs the policy of --sandbox once it is applied
+func logSandbox(p sandboxPolicy) {
+	logrus.WithFields(logrus.Fields{
+		"read_only":  p.readOnly,
+		"read_write": p.readWrite,
+		"exec":       p.exec,
+	}).Info("Sandbox applied")
+}
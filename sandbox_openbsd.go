@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// applySandbox unveils the paths of the policy and pledges the promises the
+// daemon needs, proc and exec only when running programs is allowed
+func applySandbox(p sandboxPolicy) error {
+	for _, rule := range []struct {
+		paths       []string
+		permissions string
+	}{
+		{p.readOnly, "r"},
+		{p.readWrite, "rwc"},
+		{p.executable, "rx"},
+	} {
+		for _, path := range rule.paths {
+			if err := unix.Unveil(path, rule.permissions); err != nil && err != unix.ENOENT {
+				return fmt.Errorf("failed to unveil %s: %w", path, err)
+			}
+		}
+	}
+	if err := unix.UnveilBlock(); err != nil {
+		return fmt.Errorf("failed to lock unveil: %w", err)
+	}
+
+	promises := "stdio rpath wpath cpath fattr flock inet unix dns"
+	if p.exec {
+		promises += " proc exec"
+	}
+	if err := unix.PledgePromises(promises); err != nil {
+		return fmt.Errorf("failed to pledge: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !openbsd
+
+package main
+
+import "errors"
+
+// applySandbox is only supported on Linux and OpenBSD
+func applySandbox(p sandboxPolicy) error {
+	return errors.ErrUnsupported
+}
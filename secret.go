@@ -0,0 +1,71 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// mlockSecrets allocates secret buffers in locked memory, set by --mlock
+// before any secret is read
+var mlockSecrets bool
+
+// warnUnlocked warns once that secret buffers could not be locked
+var warnUnlocked sync.Once
+
+// secretBuffer holds secret material owned by the daemon, such as a cached
+// access token, outside of the Go heap in locked memory with --mlock so that
+// it is never swapped out. It is zeroed when released. Go still makes
+// short-lived copies of it to write files and call APIs, which only
+// mlockall covers.
+type secretBuffer struct {
+	data []byte
+	// Locked mapping backing data, nil if data is ordinary memory
+	mem []byte
+}
+
+// newSecretBuffer copies secret into a new buffer and zeroes secret
+func newSecretBuffer(secret []byte) *secretBuffer {
+	b := &secretBuffer{}
+	if mlockSecrets && len(secret) > 0 {
+		mem, err := allocLocked(len(secret))
+		if err != nil {
+			warnUnlocked.Do(func() {
+				logrus.WithError(err).Warn("unable to lock secret buffers in memory, RLIMIT_MEMLOCK is likely too low, keeping them in ordinary memory")
+			})
+		} else {
+			b.mem, b.data = mem, mem[:len(secret)]
+		}
+	}
+	if b.data == nil {
+		b.data = make([]byte, len(secret))
+	}
+	copy(b.data, secret)
+	clear(secret)
+	return b
+}
+
+// Bytes returns the secret, valid until the buffer is released
+func (b *secretBuffer) Bytes() []byte {
+	if b == nil {
+		return nil
+	}
+	return b.data
+}
+
+// String returns a copy of the secret on the Go heap
+func (b *secretBuffer) String() string {
+	return string(b.Bytes())
+}
+
+// release zeroes the secret and frees its locked memory, if any
+func (b *secretBuffer) release() {
+	if b == nil {
+		return
+	}
+	clear(b.data)
+	if b.mem != nil {
+		freeLocked(b.mem)
+	}
+	b.data, b.mem = nil, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+)
+
+// SecretsManagerConfig configures the AWS Secrets Manager output. With a role
+// ARN, AWS credentials come from AssumeRoleWithWebIdentity using a JWT SVID
+// of the web identity audience, so the whole chain is SPIFFE-rooted.
+// Otherwise the standard AWS SDK chain is used.
+type SecretsManagerConfig struct {
+	SecretID            string `name:"secret-id" env:"SECRET_ID" help:"Name or ARN of the secret to store the JWT SVID in, enables the Secrets Manager output."`
+	Create              bool   `env:"CREATE" help:"Create the secret if it does not exist. Requires a secret name rather than an ARN."`
+	RoleARN             string `name:"role-arn" env:"ROLE_ARN" help:"IAM role to assume with a JWT SVID as web identity."`
+	WebIdentityAudience string `env:"WEB_IDENTITY_AUDIENCE" help:"Audience of the JWT SVID presented to AWS STS." default:"sts.amazonaws.com"`
+	RoleSessionName     string `env:"ROLE_SESSION_NAME" help:"Session name of the assumed role." default:"spiffe-jwt"`
+	Region              string `env:"REGION" help:"Region of the secret, resolved by the AWS SDK if unset."`
+	Endpoint            string `env:"ENDPOINT" help:"Endpoint URL of Secrets Manager."`
+	MaxAttempts         int    `env:"MAX_ATTEMPTS" help:"Maximum number of attempts per call, throttling included." default:"5"`
+}
+
+// secretsManagerSink stores JWT SVIDs as new versions of a secret
+type secretsManagerSink struct {
+	client   *secretsmanager.Client
+	secretID string
+	create   bool
+}
+
+// webIdentityToken supplies JWT SVIDs to the STS web identity provider
+type webIdentityToken func() ([]byte, error)
+
+func (f webIdentityToken) GetIdentityToken() ([]byte, error) {
+	return f()
+}
+
+// webIdentityCredentials assumes a role with JWT SVIDs of the audience as
+// web identity tokens, fetched with the context of the credential retrieval
+func (s *SpiffeJWT) webIdentityCredentials(cfg aws.Config, roleARN, audience, sessionName string) aws.CredentialsProvider {
+	client := sts.NewFromConfig(cfg)
+	provider := aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		token := webIdentityToken(func() ([]byte, error) {
+			jwt, err := s.fetchJWTSVIDForAudience(ctx, audience)
+			if err != nil {
+				return nil, err
+			}
+			return []byte(jwt.Marshal()), nil
+		})
+		return stscreds.NewWebIdentityRoleProvider(client, roleARN, token,
+			func(o *stscreds.WebIdentityRoleOptions) {
+				o.RoleSessionName = sessionName
+			}).Retrieve(ctx)
+	})
+	return aws.NewCredentialsCache(provider)
+}
+
+// newSecretsManagerSink creates a Secrets Manager client, assuming the role
+// with a JWT SVID when one is configured
+func (s *SpiffeJWT) newSecretsManagerSink(ctx context.Context, c SecretsManagerConfig) (*secretsManagerSink, error) {
+	opts := []func(*config.LoadOptions) error{
+		config.WithRetryMaxAttempts(c.MaxAttempts),
+	}
+	if c.Region != "" {
+		opts = append(opts, config.WithRegion(c.Region))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
+	}
+
+	if c.RoleARN != "" {
+		cfg.Credentials = s.webIdentityCredentials(cfg, c.RoleARN, c.WebIdentityAudience, c.RoleSessionName)
+	}
+
+	client := secretsmanager.NewFromConfig(cfg, func(o *secretsmanager.Options) {
+		if c.Endpoint != "" {
+			o.BaseEndpoint = aws.String(c.Endpoint)
+		}
+	})
+
+	return &secretsManagerSink{client: client, secretID: c.SecretID, create: c.Create}, nil
+}
+
+func (s *secretsManagerSink) Name() string {
+	return "secretsmanager:" + s.secretID
+}
+
+// Write stores the JWT SVID as a new version of the secret. Secrets Manager
+// moves AWSCURRENT to it and AWSPREVIOUS to the version it replaces. The
+// version ID is derived from the token, so that retries of the same token
+// do not create extra versions.
+func (s *secretsManagerSink) Write(ctx context.Context, jwt *jwtsvid.SVID) error {
+	token := jwt.Marshal()
+	sum := sha256.Sum256([]byte(token))
+	version := hex.EncodeToString(sum[:16])
+
+	_, err := s.client.PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{
+		SecretId:           aws.String(s.secretID),
+		SecretString:       aws.String(token),
+		ClientRequestToken: aws.String(version),
+	})
+	var notFound *types.ResourceNotFoundException
+	if errors.As(err, &notFound) && s.create {
+		_, err = s.client.CreateSecret(ctx, &secretsmanager.CreateSecretInput{
+			Name:               aws.String(s.secretID),
+			Description:        aws.String("JWT SVID maintained by spiffe-jwt"),
+			SecretString:       aws.String(token),
+			ClientRequestToken: aws.String(version),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create secret: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to put secret value: %w", err)
+	}
+	return nil
+}
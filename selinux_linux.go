@@ -0,0 +1,9 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+// setSELinuxLabel sets the SELinux context of a file, as chcon would
+func setSELinuxLabel(path, label string) error {
+	// The kernel expects the context NUL terminated, as libselinux writes it
+	return unix.Setxattr(path, "security.selinux", append([]byte(label), 0), 0)
+}
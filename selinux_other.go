@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// setSELinuxLabel fails outside of Linux, SELinux is Linux only
+func setSELinuxLabel(path, label string) error {
+	return errors.New("SELinux labels are only supported on Linux")
+}
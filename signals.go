@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// handleSignals reacts to operational signals in daemon mode.
+// SIGHUP forces a refresh of the JWT SVID. SIGTERM and SIGINT drain, wait
+// for --drain-on-sigterm-delay and then shut down through shutdown. SIGUSR1
+// logs the status, SIGUSR2 the status and the goroutine stacks.
+func (s *SpiffeJWT) handleSignals(shutdown context.CancelFunc) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, append([]os.Signal{syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT}, dumpSignals...)...)
+
+	for sig := range sigs {
+		switch sig {
+		case statusSignal:
+			s.dumpState(false)
+		case stacksSignal:
+			s.dumpState(true)
+		case syscall.SIGHUP:
+			s.requestRefresh("SIGHUP")
+		case syscall.SIGTERM, syscall.SIGINT:
+			s.drain(sig.String())
+			if s.DrainOnSigtermDelay > 0 {
+				logrus.Infof("Shutting down in %s", s.DrainOnSigtermDelay)
+				time.Sleep(s.DrainOnSigtermDelay)
+			}
+			logrus.Info("Shutting down")
+			shutdown()
+			return
+		}
+	}
+}
+
+// finishInflight deals with a JWT SVID fetch still in flight on shutdown.
+// With --shutdown-finish-inflight it waits that long for the fetch and its
+// write to complete before cancelling it, otherwise it cancels it right away.
+func (s *SpiffeJWT) finishInflight(cancelFetches context.CancelFunc) {
+	if s.inflight.TryLock() {
+		return
+	}
+	if s.ShutdownFinishInflight <= 0 {
+		logrus.Info("Cancelling the JWT SVID fetch in flight")
+		cancelFetches()
+		return
+	}
+
+	logrus.Infof("Waiting up to %s for the JWT SVID fetch in flight", s.ShutdownFinishInflight)
+	done := make(chan struct{})
+	go func() {
+		// Also holds off the fetches that would start after this one
+		s.inflight.Lock()
+		close(done)
+	}()
+	timer := time.NewTimer(s.ShutdownFinishInflight)
+	defer timer.Stop()
+	select {
+	case <-done:
+		logrus.Info("JWT SVID fetch in flight completed")
+	case <-timer.C:
+		logrus.Warnf("JWT SVID fetch in flight did not complete within %s, cancelling it", s.ShutdownFinishInflight)
+		cancelFetches()
+	}
+}
+
+// requestRefresh asks the refresh loop for an immediate refresh. Requests
+// made while one is already pending are coalesced.
+func (s *SpiffeJWT) requestRefresh(reason string) {
+	select {
+	case s.refreshCh <- struct{}{}:
+		logrus.Infof("Forced refresh requested by %s", reason)
+	default:
+		logrus.Infof("Forced refresh requested by %s, one is already pending", reason)
+	}
+}
+
+// maxStackDump bounds the goroutine stacks logged on SIGUSR2
+const maxStackDump = 1 << 20
+
+// dumpState logs the same data as /status and optionally all goroutine
+// stacks, for when the HTTP endpoints cannot be reached
+func (s *SpiffeJWT) dumpState(stacks bool) {
+	data, err := json.Marshal(s.status())
+	if err != nil {
+		logrus.WithError(err).Error("unable to encode status")
+		return
+	}
+	logrus.WithField("status", string(data)).Info("State dump")
+
+	if stacks {
+		buf := make([]byte, maxStackDump)
+		n := runtime.Stack(buf, true)
+		logrus.WithFields(logrus.Fields{
+			"stacks":    string(buf[:n]),
+			"truncated": n == len(buf),
+		}).Info("Goroutine dump")
+	}
+}
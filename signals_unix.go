@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// Signals that dump the state of the daemon to the log
+var (
+	statusSignal os.Signal = syscall.SIGUSR1
+	stacksSignal os.Signal = syscall.SIGUSR2
+	dumpSignals            = []os.Signal{statusSignal, stacksSignal}
+)
@@ -0,0 +1,10 @@
+package main
+
+import "os"
+
+// Windows has no user signals, state dumps are only available over /status
+var (
+	statusSignal os.Signal
+	stacksSignal os.Signal
+	dumpSignals  []os.Signal
+)
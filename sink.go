@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+)
+
+// sinkWriteTimeout bounds how long a single sink may take to accept a JWT SVID
+const sinkWriteTimeout = 30 * time.Second
+
+// Sink is an additional output target that receives every refreshed JWT SVID
+// once it has been written to the local file.
+type Sink interface {
+	// Name identifies the sink in logs.
+	Name() string
+	// Write delivers the JWT SVID to the output target.
+	Write(ctx context.Context, jwt *jwtsvid.SVID) error
+}
+
+// setupSinks builds the configured output targets
+func (s *SpiffeJWT) setupSinks(ctx context.Context) error {
+	if s.S3.Bucket != "" {
+		sink, err := newS3Sink(ctx, s.S3)
+		if err != nil {
+			return fmt.Errorf("failed to set up S3 output: %w", err)
+		}
+		s.sinks = append(s.sinks, sink)
+	}
+	if s.SecretsManager.SecretID != "" {
+		sink, err := s.newSecretsManagerSink(ctx, s.SecretsManager)
+		if err != nil {
+			return fmt.Errorf("failed to set up Secrets Manager output: %w", err)
+		}
+		s.sinks = append(s.sinks, sink)
+	}
+	if s.GCPSecretManager.Project != "" {
+		sink, err := s.newGCPSecretManagerSink(s.GCPSecretManager)
+		if err != nil {
+			return fmt.Errorf("failed to set up GCP Secret Manager output: %w", err)
+		}
+		s.sinks = append(s.sinks, sink)
+	}
+	if s.AzureKeyVault.VaultURL != "" {
+		sink, err := s.newAzureKeyVaultSink(s.AzureKeyVault)
+		if err != nil {
+			return fmt.Errorf("failed to set up Azure Key Vault output: %w", err)
+		}
+		s.sinks = append(s.sinks, sink)
+	}
+	if s.GRPCCallback.Address != "" {
+		sink, err := newGRPCCallbackSink(s.GRPCCallback)
+		if err != nil {
+			return fmt.Errorf("failed to set up gRPC callback output: %w", err)
+		}
+		s.sinks = append(s.sinks, sink)
+	}
+
+	if s.TokenMetadataBackend == "datadog" {
+		sink, err := newDatadogSink(s.Datadog)
+		if err != nil {
+			return fmt.Errorf("failed to set up Datadog events: %w", err)
+		}
+		s.sinks = append(s.sinks, sink)
+	}
+
+	for _, spec := range s.SinkPlugins {
+		sink, err := newPluginSink(spec)
+		if err != nil {
+			return fmt.Errorf("failed to set up sink plugin: %w", err)
+		}
+		go sink.supervise(ctx)
+		s.sinks = append(s.sinks, sink)
+	}
+
+	for _, sink := range s.sinks {
+		logrus.Infof("Output target %s enabled", sink.Name())
+	}
+	return nil
+}
+
+// writeSinks writes a JWT SVID to every configured output target. Failures are
+// logged but not returned, as the local file remains the source of truth.
+func (s *SpiffeJWT) writeSinks(ctx context.Context, jwt *jwtsvid.SVID) {
+	for _, sink := range s.sinks {
+		ctx, cancel := context.WithTimeout(ctx, sinkWriteTimeout)
+		err := sink.Write(ctx, jwt)
+		cancel()
+		s.recordSinkWrite(sink.Name(), err)
+		if err != nil {
+			s.stats.recordFailure(failureSink)
+			writeErrors.WithLabelValues(sink.Name()).Inc()
+			logrus.WithContext(ctx).WithError(err).WithField(failureClassField, failureSink).Errorf("failed to write JWT SVID to %s", sink.Name())
+			continue
+		}
+		logrus.WithContext(ctx).Infof("JWT SVID written to %s", sink.Name())
+	}
+}
+
+// sinkStatus is the outcome of the last write to a sink. Sinks are reported
+// apart from the local file, so that an outage of a remote target does not
+// mask the health of the rotation itself.
+type sinkStatus struct {
+	Healthy     bool       `json:"healthy"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// recordSinkWrite records the outcome of a write to a sink
+func (s *SpiffeJWT) recordSinkWrite(name string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sinkHealth == nil {
+		s.sinkHealth = make(map[string]sinkStatus)
+	}
+	st := s.sinkHealth[name]
+	if err != nil {
+		st.Healthy, st.LastError = false, err.Error()
+		sinkUp.WithLabelValues(name).Set(0)
+	} else {
+		now := time.Now()
+		st.Healthy, st.LastSuccess, st.LastError = true, &now, ""
+		sinkUp.WithLabelValues(name).Set(1)
+	}
+	s.sinkHealth[name] = st
+}
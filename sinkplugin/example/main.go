@@ -0,0 +1,56 @@
+// Command example is the reference sink plugin of spiffe-jwt. It writes
+// every JWT SVID it receives to the file named by SINK_FILE, atomically.
+//
+//	go build -o file-sink ./sinkplugin/example
+//	SINK_FILE=/var/run/copy/token spiffe-jwt --sink-plugin file=./file-sink ...
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/CentML/spiffe-jwt/sinkplugin"
+)
+
+func main() {
+	// stdout carries the protocol, logs go to stderr
+	log.SetFlags(0)
+
+	path := os.Getenv("SINK_FILE")
+	if path == "" {
+		log.Fatal("SINK_FILE is not set")
+	}
+
+	err := sinkplugin.Serve("file", func(t *sinkplugin.Token) error {
+		if err := writeAtomic(path, []byte(t.Token)); err != nil {
+			return err
+		}
+		log.Printf("wrote JWT SVID of %s expiring at %s to %s", t.SpiffeID, t.Expiry, path)
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// writeAtomic writes data to a temporary file next to path and renames it
+// over path
+func writeAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err = errors.Join(err, tmp.Chmod(0o600), tmp.Close()); err != nil {
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to rename temporary file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+// Package sinkplugin implements the protocol between spiffe-jwt and
+// out-of-process sinks, configured with --sink-plugin.
+//
+// A plugin is an executable started by spiffe-jwt. It writes a Handshake as
+// the first line of its stdout, then reads one Request per line on its stdin
+// and answers each one with a Response line on its stdout, in order. All
+// messages are JSON. The plugin must exit once its stdin is closed. Its
+// stderr is relayed to the logs of spiffe-jwt.
+//
+// Plugins written in Go only need Serve:
+//
+//	func main() {
+//		err := sinkplugin.Serve("my-store", func(t *sinkplugin.Token) error {
+//			return store.Put(t.SpiffeID, t.Token)
+//		})
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//	}
+package sinkplugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Protocol identifies the protocol in the handshake
+const Protocol = "spiffe-jwt-sink"
+
+// ProtocolVersion is the version of the protocol implemented by this package
+const ProtocolVersion = 1
+
+// TypeWrite is the type of the requests delivering a rotated JWT SVID
+const TypeWrite = "write"
+
+// MaxMessageSize bounds the size of a single message line
+const MaxMessageSize = 1 << 20
+
+// Handshake is the first line written by a plugin
+type Handshake struct {
+	Protocol string `json:"protocol"`
+	Version  int    `json:"version"`
+	// Name identifies the plugin in logs
+	Name string `json:"name"`
+}
+
+// Request is a message from spiffe-jwt to a plugin
+type Request struct {
+	ID   uint64 `json:"id"`
+	Type string `json:"type"`
+	// Token is the JWT SVID of write requests
+	Token *Token `json:"token,omitempty"`
+	// RefreshID is the correlation ID of the refresh a write belongs to, as
+	// logged by the daemon
+	RefreshID string `json:"refresh_id,omitempty"`
+}
+
+// Token is a JWT SVID delivered to a plugin
+type Token struct {
+	// Token is the JWT SVID in compact serialization
+	Token    string    `json:"token"`
+	SpiffeID string    `json:"spiffe_id"`
+	Audience []string  `json:"audience"`
+	Expiry   time.Time `json:"expiry"`
+}
+
+// Response is the answer of a plugin to the request with the same ID. An
+// empty Error means success.
+type Response struct {
+	ID    uint64 `json:"id"`
+	Error string `json:"error,omitempty"`
+}
+
+// Serve runs a plugin on stdin and stdout, calling write for every JWT SVID
+// delivered. It returns nil once stdin is closed.
+func Serve(name string, write func(*Token) error) error {
+	return ServeIO(os.Stdin, os.Stdout, name, write)
+}
+
+// ServeIO is Serve on arbitrary streams
+func ServeIO(r io.Reader, w io.Writer, name string, write func(*Token) error) error {
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(Handshake{Protocol: Protocol, Version: ProtocolVersion, Name: name}); err != nil {
+		return fmt.Errorf("failed to write handshake: %w", err)
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), MaxMessageSize)
+	for scanner.Scan() {
+		var req Request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			return fmt.Errorf("invalid request: %w", err)
+		}
+		resp := Response{ID: req.ID}
+		switch {
+		case req.Type != TypeWrite:
+			resp.Error = fmt.Sprintf("unknown request type %q", req.Type)
+		case req.Token == nil:
+			resp.Error = "write request without token"
+		default:
+			if err := write(req.Token); err != nil {
+				resp.Error = err.Error()
+			}
+		}
+		if err := enc.Encode(resp); err != nil {
+			return fmt.Errorf("failed to write response: %w", err)
+		}
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to read request: %w", err)
+	}
+	return nil
+}
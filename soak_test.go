@@ -0,0 +1,335 @@
+//go:build soak
+
+// The soak test drives rotations against an in-process agent with short
+// TTLs for a long time and fails when resources grow. It is excluded from
+// regular runs:
+//
+//	go test -tags soak -run TestSoak -timeout 0 -soak.duration 2h .
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/spiffe/go-spiffe/v2/proto/spiffe/workload"
+	"google.golang.org/grpc"
+)
+
+var (
+	soakDuration   = flag.Duration("soak.duration", 5*time.Minute, "Duration of the soak test.")
+	soakTTL        = flag.Duration("soak.ttl", 4*time.Second, "TTL of the JWT SVIDs issued by the test agent.")
+	soakInterval   = flag.Duration("soak.interval", time.Second, "Interval of the forced refreshes.")
+	soakCheckpoint = flag.Duration("soak.checkpoint", time.Minute, "Interval of the resource checks, the first one is the baseline.")
+)
+
+// Growth tolerated over the first soak checkpoint before a leak is reported
+const (
+	soakGoroutineSlack = 10
+	soakFDSlack        = 10
+	soakHeapSlack      = 16 << 20
+)
+
+// soakSubsystems attributes goroutines to the subsystem of the first frame
+// of their stack matching one of the prefixes
+var soakSubsystems = []struct {
+	name     string
+	prefixes []string
+}{
+	{"watcher", []string{"github.com/fsnotify/"}},
+	{"workload API client", []string{"google.golang.org/grpc", "github.com/spiffe/go-spiffe/v2/workloadapi"}},
+	{"health server", []string{"net/http."}},
+}
+
+// soakSample is the resource usage at a soak checkpoint, by subsystem where
+// it can be attributed
+type soakSample struct {
+	goroutines map[string]int
+	fds        map[string]int
+	heap       uint64
+	tempFiles  int
+}
+
+// TestSoak runs the daemon against a test agent, forces a refresh every
+// -soak.interval and compares the resource usage at every -soak.checkpoint
+// with the first one, failing with the leaking subsystem when it grows
+func TestSoak(t *testing.T) {
+	socket := startSoakAgent(t)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+
+	cli := &CLI{}
+	parser, err := kong.New(cli, kong.Bind(&configReport{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.Parse([]string{
+		"--spiffe-agent-socket", socket,
+		"--jwt-audience", "soak",
+		"--jwt-file-name", tokenFile,
+		"--health-port", "0",
+		"--exit-after", soakDuration.String(),
+		// Every forced refresh of the soak must go through
+		"--forced-refresh-cooldown", "0",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s := &cli.Run
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Run()
+	}()
+	for {
+		if _, err := os.Stat(tokenFile); err == nil {
+			break
+		}
+		select {
+		case err := <-done:
+			t.Fatalf("daemon exited before writing the first token: %v", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	refresh := time.NewTicker(*soakInterval)
+	defer refresh.Stop()
+	checkpoint := time.NewTicker(*soakCheckpoint)
+	defer checkpoint.Stop()
+
+	var baseline *soakSample
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("daemon failed: %v", err)
+			}
+			if baseline == nil {
+				t.Fatal("the soak ended before its first checkpoint")
+			}
+			return
+		case <-refresh.C:
+			s.requestRefresh("soak test")
+		case <-checkpoint.C:
+			sample := takeSoakSample(s.outputFiles())
+			rotations, _ := s.stats.snapshot()
+			summary := fmt.Sprintf("rotations=%d goroutines=%d fds=%d heap=%d temp_files=%d",
+				rotations, total(sample.goroutines), total(sample.fds), sample.heap, sample.tempFiles)
+			if baseline == nil {
+				baseline = sample
+				t.Logf("Soak baseline taken: %s", summary)
+				continue
+			}
+			if leaks := sample.leaks(baseline, len(s.outputFiles())); len(leaks) > 0 {
+				t.Errorf("Soak checkpoint failed: %s: %s", summary, strings.Join(leaks, "; "))
+				continue
+			}
+			t.Logf("Soak checkpoint passed: %s", summary)
+		}
+	}
+}
+
+// soakAgent is a Workload API serving JWT SVIDs of -soak.ttl signed with a
+// key of its own
+type soakAgent struct {
+	workload.UnimplementedSpiffeWorkloadAPIServer
+	key  *ecdsa.PrivateKey
+	jwks []byte
+}
+
+// startSoakAgent serves a soakAgent on a unix socket until the end of the test
+func startSoakAgent(t *testing.T) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: key.Public(), KeyID: "soak", Use: "jwt-svid", Algorithm: "ES256"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	workload.RegisterSpiffeWorkloadAPIServer(server, &soakAgent{key: key, jwks: jwks})
+	go server.Serve(l)
+	t.Cleanup(server.Stop)
+	return socket
+}
+
+func (a *soakAgent) FetchJWTSVID(_ context.Context, req *workload.JWTSVIDRequest) (*workload.JWTSVIDResponse, error) {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: jose.JSONWebKey{Key: a.key, KeyID: "soak"}}, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		return nil, err
+	}
+	const id = "spiffe://example.org/soak"
+	now := time.Now()
+	token, err := jwt.Signed(signer).Claims(jwt.Claims{
+		Subject:  id,
+		Audience: req.Audience,
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(now.Add(*soakTTL)),
+	}).Serialize()
+	if err != nil {
+		return nil, err
+	}
+	return &workload.JWTSVIDResponse{Svids: []*workload.JWTSVID{{SpiffeId: id, Svid: token}}}, nil
+}
+
+func (a *soakAgent) FetchJWTBundles(_ *workload.JWTBundlesRequest, stream workload.SpiffeWorkloadAPI_FetchJWTBundlesServer) error {
+	if err := stream.Send(&workload.JWTBundlesResponse{Bundles: map[string][]byte{"example.org": a.jwks}}); err != nil {
+		return err
+	}
+	<-stream.Context().Done()
+	return nil
+}
+
+// leaks describes the growth of the sample over the baseline beyond the
+// tolerated slack. At most one temporary file per output file may exist, for
+// a write in flight.
+func (s *soakSample) leaks(baseline *soakSample, outputs int) []string {
+	var leaks []string
+	for _, sub := range sortedKeys(s.goroutines) {
+		if n, base := s.goroutines[sub], baseline.goroutines[sub]; n > base+soakGoroutineSlack {
+			leaks = append(leaks, fmt.Sprintf("%s goroutines grew from %d to %d", sub, base, n))
+		}
+	}
+	for _, kind := range sortedKeys(s.fds) {
+		if n, base := s.fds[kind], baseline.fds[kind]; n > base+soakFDSlack {
+			leaks = append(leaks, fmt.Sprintf("%s file descriptors grew from %d to %d", kind, base, n))
+		}
+	}
+	if s.heap > 2*baseline.heap+soakHeapSlack {
+		leaks = append(leaks, fmt.Sprintf("heap grew from %d to %d bytes", baseline.heap, s.heap))
+	}
+	if s.tempFiles > outputs {
+		leaks = append(leaks, fmt.Sprintf("%d temporary files left in the output directories", s.tempFiles))
+	}
+	return leaks
+}
+
+// takeSoakSample measures the resource usage of the process
+func takeSoakSample(outputs []string) *soakSample {
+	runtime.GC()
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	sample := &soakSample{goroutines: goroutinesBySubsystem(), fds: fdsByKind(), heap: mem.HeapInuse}
+	for _, path := range outputs {
+		matches, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp.*"))
+		sample.tempFiles += len(matches)
+	}
+	return sample
+}
+
+// goroutinesBySubsystem counts the goroutines by subsystem from the
+// goroutine profile
+func goroutinesBySubsystem() map[string]int {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+		return map[string]int{"all": runtime.NumGoroutine()}
+	}
+
+	counts := map[string]int{}
+	// Records start with "<count> @ <pcs>" followed by "#\t<pc>\t<func>+<off>\t<file>" frames
+	count, sub := 0, ""
+	flush := func() {
+		if count > 0 {
+			if sub == "" {
+				sub = "other"
+			}
+			counts[sub] += count
+		}
+		count, sub = 0, ""
+	}
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			flush()
+		case count == 0 && strings.Contains(line, " @ "):
+			count, _ = strconv.Atoi(strings.Fields(line)[0])
+		case sub == "" && strings.HasPrefix(line, "#"):
+			fields := strings.Fields(line)
+			if len(fields) < 3 {
+				continue
+			}
+			for _, candidate := range soakSubsystems {
+				for _, prefix := range candidate.prefixes {
+					if strings.HasPrefix(fields[2], prefix) {
+						sub = candidate.name
+					}
+				}
+			}
+		}
+	}
+	flush()
+	return counts
+}
+
+// fdsByKind counts the open file descriptors by kind, sockets, inotify
+// watches and files. It returns nil where /proc/self/fd is not available.
+func fdsByKind() map[string]int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return nil
+	}
+	counts := map[string]int{}
+	for _, entry := range entries {
+		target, err := os.Readlink(filepath.Join("/proc/self/fd", entry.Name()))
+		if err != nil {
+			// Closed since the directory was read, as the one of ReadDir itself
+			continue
+		}
+		switch {
+		case strings.HasPrefix(target, "socket:"):
+			counts["socket"]++
+		case strings.Contains(target, "inotify"):
+			counts["watcher"]++
+		default:
+			counts["file"]++
+		}
+	}
+	return counts
+}
+
+// total sums the counts of a map
+func total(counts map[string]int) int {
+	n := 0
+	for _, c := range counts {
+		n += c
+	}
+	return n
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
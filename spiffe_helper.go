@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/kong"
+	"github.com/hashicorp/hcl"
+	"gopkg.in/yaml.v3"
+)
+
+// unsupportedHelperOptions lists the spiffe-helper options without an
+// equivalent, with the reason reported to the user
+var unsupportedHelperOptions = map[string]string{
+	"cmd":                         "running a companion process is not supported",
+	"cmd_args":                    "running a companion process is not supported",
+	"exit_when_ready":             "use daemon_mode = false for a one-shot run",
+	"svid_file_name":              "X.509 SVIDs are not supported",
+	"svid_key_file_name":          "X.509 SVIDs are not supported",
+	"svid_bundle_file_name":       "X.509 SVIDs are not supported",
+	"add_intermediates_to_bundle": "X.509 SVIDs are not supported",
+	"include_federated_domains":   "only the bundle of the workload's trust domain is written",
+	"cert_file_mode":              "file modes are not configurable",
+	"key_file_mode":               "file modes are not configurable",
+	"jwt_bundle_file_mode":        "file modes are not configurable",
+}
+
+// spiffeHelperConfig is a flag loading a spiffe-helper configuration file,
+// mapping its settings onto the equivalent flags of this tool. Unsupported
+// settings are errors rather than being silently ignored.
+type spiffeHelperConfig string
+
+// BeforeResolve translates the spiffe-helper configuration into flag values
+func (c spiffeHelperConfig) BeforeResolve(ctx *kong.Context, trace *kong.Path) error {
+	path := string(ctx.FlagValue(trace.Flag).(spiffeHelperConfig))
+	values, err := loadSpiffeHelperConfig(path)
+	if err != nil {
+		return err
+	}
+
+	ctx.AddResolver(kong.ResolverFunc(func(_ *kong.Context, _ *kong.Path, flag *kong.Flag) (any, error) {
+		if v, ok := values[flag.Name]; ok {
+			return fmt.Sprint(v), nil
+		}
+		return nil, nil
+	}))
+	return nil
+}
+
+// loadSpiffeHelperConfig reads a spiffe-helper HCL configuration file and
+// returns the equivalent native flag values
+func loadSpiffeHelperConfig(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spiffe-helper configuration: %w", err)
+	}
+
+	var helper map[string]any
+	if err := hcl.Decode(&helper, string(data)); err != nil {
+		return nil, fmt.Errorf("invalid spiffe-helper configuration %s: %w", path, err)
+	}
+
+	values, errs := translateSpiffeHelperConfig(helper)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("spiffe-helper configuration %s: %w", path, errors.Join(errs...))
+	}
+	return values, nil
+}
+
+// translateSpiffeHelperConfig maps decoded spiffe-helper settings to flag values
+func translateSpiffeHelperConfig(helper map[string]any) (map[string]any, []error) {
+	certDir, _ := helper["cert_dir"].(string)
+	inCertDir := func(name string) string {
+		if certDir == "" || filepath.IsAbs(name) {
+			return name
+		}
+		return filepath.Join(certDir, name)
+	}
+
+	keys := make([]string, 0, len(helper))
+	for k := range helper {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	values := map[string]any{}
+	var errs []error
+	for _, key := range keys {
+		v := helper[key]
+		switch key {
+		case "cert_dir":
+			// Applied to the file names
+
+		case "agent_address":
+			addr := fmt.Sprint(v)
+			if strings.Contains(addr, "://") && !strings.HasPrefix(addr, "unix://") {
+				errs = append(errs, fmt.Errorf("agent_address %q: only unix sockets are supported", addr))
+				continue
+			}
+			values["spiffe-agent-socket"] = strings.TrimPrefix(addr, "unix://")
+
+		case "daemon_mode":
+			values["daemon-mode"] = v
+
+		case "hint":
+			values["svid-hint"] = v
+
+		case "pid_file_name":
+			values["notify-pid-file"] = v
+
+		case "renew_signal":
+			values["notify-signal"] = v
+
+		case "jwt_svid_file_mode":
+			// JWT SVID files are always written with mode 0600, which
+			// --token-file-mode-refresh enforces like spiffe-helper does
+			mode, err := helperFileMode(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("jwt_svid_file_mode: %w", err))
+				continue
+			}
+			if mode != 0o600 {
+				errs = append(errs, fmt.Errorf("jwt_svid_file_mode %#o: only 0600 is supported", mode))
+				continue
+			}
+			values["token-file-mode-refresh"] = true
+
+		case "jwt_bundle_file_name":
+			values["jwt-bundle-file-name"] = inCertDir(fmt.Sprint(v))
+
+		case "jwt_svids":
+			svids, _ := v.([]any)
+			if len(svids) != 1 {
+				errs = append(errs, fmt.Errorf("jwt_svids: exactly one JWT SVID is supported, got %d", len(svids)))
+				continue
+			}
+			svid, _ := svids[0].(map[string]any)
+			for k, sv := range svid {
+				switch k {
+				case "jwt_audience":
+					values["jwt-audience"] = sv
+				case "jwt_svid_file_name":
+					values["jwt-file-name"] = inCertDir(fmt.Sprint(sv))
+				case "jwt_extra_audiences":
+					errs = append(errs, errors.New("jwt_svids.jwt_extra_audiences: extra audiences are not supported"))
+				default:
+					errs = append(errs, fmt.Errorf("jwt_svids.%s: unknown option", k))
+				}
+			}
+
+		case "health_checks":
+			blocks, _ := v.([]map[string]any)
+			for _, block := range blocks {
+				for k, hv := range block {
+					switch k {
+					case "bind_port":
+						values["health-port"] = hv
+					case "listener_enabled", "liveness_path", "readiness_path":
+						// The health server always runs in daemon mode on fixed paths
+					default:
+						errs = append(errs, fmt.Errorf("health_checks.%s: unknown option", k))
+					}
+				}
+			}
+
+		default:
+			if reason, ok := unsupportedHelperOptions[key]; ok {
+				errs = append(errs, fmt.Errorf("%s: unsupported, %s", key, reason))
+			} else {
+				errs = append(errs, fmt.Errorf("%s: unknown option", key))
+			}
+		}
+	}
+	return values, errs
+}
+
+// helperFileMode parses a spiffe-helper file mode, an octal number that HCL
+// decodes as an integer, or a string
+func helperFileMode(v any) (fs.FileMode, error) {
+	switch v := v.(type) {
+	case int:
+		return fs.FileMode(v), nil
+	case string:
+		mode, err := strconv.ParseUint(v, 8, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid file mode %q", v)
+		}
+		return fs.FileMode(mode), nil
+	}
+	return 0, fmt.Errorf("invalid file mode %v", v)
+}
+
+// TranslateSpiffeHelperConfigCmd prints the native configuration equivalent
+// to a spiffe-helper configuration file, to help migrating off spiffe-helper
+type TranslateSpiffeHelperConfigCmd struct {
+	File string `arg:"" help:"spiffe-helper configuration file." type:"existingfile"`
+}
+
+// Run writes the translated configuration as YAML to stdout
+func (c *TranslateSpiffeHelperConfigCmd) Run(ctx *kong.Context) error {
+	values, err := loadSpiffeHelperConfig(c.File)
+	if err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(values)
+	if err != nil {
+		return fmt.Errorf("failed to encode configuration: %w", err)
+	}
+	fmt.Fprintf(ctx.Stdout, "# Translated from spiffe-helper configuration %s\n", c.File)
+	_, err = ctx.Stdout.Write(data)
+	return err
+}
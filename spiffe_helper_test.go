@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestLoadSpiffeHelperConfig checks the translation of spiffe-helper
+// settings, including companion notification and the JWT SVID file mode
+func TestLoadSpiffeHelperConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		config  string
+		want    map[string]any
+		wantErr string
+	}{
+		{
+			name: "jwt svid",
+			config: `
+agent_address = "unix:///run/spire/agent.sock"
+cert_dir = "/certs"
+jwt_svids = [{ jwt_audience = "aud", jwt_svid_file_name = "token" }]
+`,
+			want: map[string]any{"spiffe-agent-socket": "/run/spire/agent.sock", "jwt-audience": "aud", "jwt-file-name": filepath.Join("/certs", "token")},
+		},
+		{
+			name: "companion notification",
+			config: `
+pid_file_name = "/run/envoy.pid"
+renew_signal = "SIGUSR1"
+`,
+			want: map[string]any{"notify-pid-file": "/run/envoy.pid", "notify-signal": "SIGUSR1"},
+		},
+		{
+			name:   "jwt svid file mode",
+			config: `jwt_svid_file_mode = 0600`,
+			want:   map[string]any{"token-file-mode-refresh": true},
+		},
+		{
+			name:   "jwt svid file mode as a string",
+			config: `jwt_svid_file_mode = "0600"`,
+			want:   map[string]any{"token-file-mode-refresh": true},
+		},
+		{
+			name:    "other jwt svid file mode",
+			config:  `jwt_svid_file_mode = 0644`,
+			wantErr: "jwt_svid_file_mode 0644: only 0600 is supported",
+		},
+		{
+			name:    "unsupported option",
+			config:  `cmd = "envoy"`,
+			wantErr: "cmd: unsupported",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "helper.conf")
+			if err := os.WriteFile(path, []byte(tc.config), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			values, err := loadSpiffeHelperConfig(path)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(values) != len(tc.want) {
+				t.Errorf("values = %v, want %v", values, tc.want)
+			}
+			for k, v := range tc.want {
+				if values[k] != v {
+					t.Errorf("%s = %v, want %v", k, values[k], v)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Failure classes recorded in the run statistics
+const (
+	failureFetch  = "fetch"
+	failureWrite  = "write"
+	failureSink   = "sink"
+	failureBundle = "bundle"
+	failureReject = "rejected"
+)
+
+// failureClassField is the log field carrying the failure class
+const failureClassField = "failure_class"
+
+// classifiedError is an error tagged with its failure class
+type classifiedError struct {
+	class string
+	err   error
+}
+
+func (e *classifiedError) Error() string { return e.err.Error() }
+func (e *classifiedError) Unwrap() error { return e.err }
+
+// failureLog returns a log entry for err, with its failure class and the
+// correlation ID of its refresh if it has them
+func failureLog(err error) *logrus.Entry {
+	entry := logrus.WithError(err)
+	var ce *classifiedError
+	if errors.As(err, &ce) {
+		entry = entry.WithField(failureClassField, ce.class)
+	}
+	var re *refreshError
+	if errors.As(err, &re) {
+		entry = entry.WithField(refreshIDField, re.id)
+	}
+	return entry
+}
+
+// runStats summarizes the activity of the daemon over its lifetime
+type runStats struct {
+	mu        sync.Mutex
+	rotations int
+	failures  map[string]int
+	minTTL    time.Duration
+	maxTTL    time.Duration
+
+	// Signalled after every record when set, for --exit-after-rotations
+	changed chan struct{}
+}
+
+// notify signals changed without blocking
+func (r *runStats) notify() {
+	if r.changed == nil {
+		return
+	}
+	select {
+	case r.changed <- struct{}{}:
+	default:
+	}
+}
+
+// recordRotation records a successful rotation and the TTL of the new token
+func (r *runStats) recordRotation(ttl time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.rotations == 0 || ttl < r.minTTL {
+		r.minTTL = ttl
+	}
+	if ttl > r.maxTTL {
+		r.maxTTL = ttl
+	}
+	r.rotations++
+	r.notify()
+}
+
+// recordFailure records a failure of the given class
+func (r *runStats) recordFailure(class string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.failures == nil {
+		r.failures = map[string]int{}
+	}
+	r.failures[class]++
+	r.notify()
+}
+
+// totalFailures returns the number of failures across all classes
+func (r *runStats) totalFailures() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	total := 0
+	for _, n := range r.failures {
+		total += n
+	}
+	return total
+}
+
+// snapshot returns the number of rotations and a copy of the failures by class
+func (r *runStats) snapshot() (int, map[string]int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	failures := make(map[string]int, len(r.failures))
+	for class, n := range r.failures {
+		failures[class] = n
+	}
+	return r.rotations, failures
+}
+
+// logSummary logs the rotations, failures by class and observed TTLs
+func (r *runStats) logSummary() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	classes := make([]string, 0, len(r.failures))
+	for class, n := range r.failures {
+		classes = append(classes, fmt.Sprintf("%s=%d", class, n))
+	}
+	sort.Strings(classes)
+
+	logrus.WithFields(logrus.Fields{
+		"rotations": r.rotations,
+		"failures":  strings.Join(classes, ","),
+		"min_ttl":   r.minTTL.Round(time.Second).String(),
+		"max_ttl":   r.maxTTL.Round(time.Second).String(),
+	}).Info("Run summary")
+}
+
+// exitAfterRotations stops the daemon through cancel once --exit-after-rotations
+// rotations succeeded, or as soon as the failures exceed
+// --exit-after-max-failures
+func (s *SpiffeJWT) exitAfterRotations(ctx context.Context, cancel context.CancelFunc) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stats.changed:
+		}
+		rotations, _ := s.stats.snapshot()
+		if n := s.stats.totalFailures(); n > s.ExitAfterMaxFailures {
+			logrus.Warnf("%d failures exceed the tolerated %d before %d rotations, exiting", n, s.ExitAfterMaxFailures, s.ExitAfterRotations)
+			cancel()
+			return
+		}
+		if rotations >= s.ExitAfterRotations {
+			logrus.Infof("%d rotations done, exiting", rotations)
+			cancel()
+			return
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// maxReadBackSize caps the files read back from the output volume. Anyone
+// able to write the volume controls their content, and real token files,
+// kubeconfigs and token maps are far smaller.
+const maxReadBackSize = 1 << 20
+
+// parsedToken is an unverified view of a JWT, used for inspection only
+type parsedToken struct {
+	Header map[string]any
+	Claims map[string]any
+}
+
+// readTokenFile reads a token file and returns the compact JWT it contains.
+// key decrypts files written with --token-persistence-mode=encrypted and may
+// be nil otherwise.
+func readTokenFile(path string, key []byte) (string, error) {
+	data, err := readFileLimited(path, maxReadBackSize)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file: %w", err)
+	}
+	token, err := decodeToken(data, key)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode token file %s: %w", path, err)
+	}
+	return token, nil
+}
+
+// readFileLimited reads a file, failing if it is larger than max bytes
+func readFileLimited(path string, max int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > max {
+		return nil, fmt.Errorf("%s is larger than %d bytes", path, max)
+	}
+	return data, nil
+}
+
+// decodeToken extracts a compact JWT from the contents of a token file.
+// Besides the raw compact serialization it accepts a JSON object carrying the
+// token in a "token" field, a base64 encoded token and an encrypted token.
+func decodeToken(data []byte, key []byte) (string, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return "", errors.New("token file is empty")
+	}
+
+	if bytes.HasPrefix(data, []byte(encryptedTokenPrefix)) {
+		token, err := decryptToken(key, string(data))
+		if err != nil {
+			return "", err
+		}
+		return decodeToken([]byte(token), nil)
+	}
+
+	if data[0] == '{' {
+		var doc struct {
+			Token string `json:"token"`
+		}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return "", fmt.Errorf("invalid JSON token document: %w", err)
+		}
+		if doc.Token == "" {
+			return "", errors.New(`JSON token document has no "token" field`)
+		}
+		if strings.Count(doc.Token, ".") != 2 {
+			return "", errors.New(`"token" field of the JSON token document is not a compact JWT`)
+		}
+		return doc.Token, nil
+	}
+
+	token := string(data)
+	if strings.Count(token, ".") != 2 {
+		decoded, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			return "", errors.New("content is neither a compact JWT nor base64 encoded")
+		}
+		token = strings.TrimSpace(string(decoded))
+	}
+	if strings.Count(token, ".") != 2 {
+		return "", errors.New("content is not a compact JWT")
+	}
+	return token, nil
+}
+
+// parseTokenInsecure decodes the header and claims of a compact JWT without
+// verifying its signature
+func parseTokenInsecure(token string) (*parsedToken, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token is not a compact JWT")
+	}
+
+	p := &parsedToken{}
+	if err := decodeSegment(parts[0], &p.Header); err != nil {
+		return nil, fmt.Errorf("invalid JWT header: %w", err)
+	}
+	if err := decodeSegment(parts[1], &p.Claims); err != nil {
+		return nil, fmt.Errorf("invalid JWT claims: %w", err)
+	}
+	return p, nil
+}
+
+// decodeSegment decodes a base64url encoded JSON segment of a JWT
+func decodeSegment(seg string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(seg, "="))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// subject returns the sub claim
+func (p *parsedToken) subject() string {
+	sub, _ := p.Claims["sub"].(string)
+	return sub
+}
+
+// audience returns the aud claim, which may be a single string or a list
+func (p *parsedToken) audience() []string {
+	switch aud := p.Claims["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []any:
+		var out []string
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// expiry returns the exp claim, or the zero time if it is missing
+func (p *parsedToken) expiry() time.Time {
+	exp, ok := p.Claims["exp"].(float64)
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(int64(exp), 0)
+}
+
+// audienceFromJWT returns the first audience of the JWT in a token file
+func audienceFromJWT(path string, key []byte) (string, error) {
+	token, err := readTokenFile(path, key)
+	if err != nil {
+		return "", err
+	}
+	p, err := parseTokenInsecure(token)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	aud := p.audience()
+	if len(aud) == 0 || strings.TrimSpace(aud[0]) == "" {
+		return "", fmt.Errorf("JWT in %s has no audience", path)
+	}
+	return aud[0], nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testJWT builds an unsigned compact JWT from raw JSON header and claims
+func testJWT(header, claims string) string {
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(header)) + "." + enc.EncodeToString([]byte(claims)) + ".sig"
+}
+
+// FuzzReadTokenFile feeds arbitrary file contents through the read back path
+// of token files, which must fail cleanly rather than panic
+func FuzzReadTokenFile(f *testing.F) {
+	token := testJWT(`{"alg":"ES256","kid":"k"}`, `{"sub":"spiffe://example.org/w","aud":"a","exp":1700000000}`)
+	for _, seed := range []string{
+		token,
+		token + "\n",
+		`{"token":"` + token + `"}`,
+		base64.StdEncoding.EncodeToString([]byte(token)),
+		token[:len(token)/2],
+		"a.b",
+		"..",
+		"{",
+		`{"token":""}`,
+		encryptedTokenPrefix + "!!",
+		"\xff\xfe.\x00.\xc3",
+		strings.Repeat("A", maxReadBackSize+1),
+	} {
+		f.Add([]byte(seed))
+	}
+
+	dir := f.TempDir()
+	f.Fuzz(func(t *testing.T, data []byte) {
+		path := filepath.Join(dir, "token")
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		token, err := readTokenFile(path, nil)
+		if err != nil {
+			return
+		}
+		if len(data) > maxReadBackSize {
+			t.Fatalf("read %d bytes, over the %d byte cap", len(data), maxReadBackSize)
+		}
+		if strings.Count(token, ".") != 2 {
+			t.Fatalf("decoded token %q is not a compact JWT", token)
+		}
+		if p, err := parseTokenInsecure(token); err == nil {
+			p.subject()
+			p.audience()
+			p.expiry()
+		}
+	})
+}
+
+// FuzzInspectToken parses arbitrary tokens and renders their header and
+// claims the way compare does, checking that the rendering stays bounded
+func FuzzInspectToken(f *testing.F) {
+	deep := strings.Repeat(`{"a":`, 64) + "1" + strings.Repeat("}", 64)
+	for _, seed := range []string{
+		testJWT(`{"alg":"ES256"}`, `{"sub":"spiffe://example.org/w","aud":["a","b"],"exp":1700000000}`),
+		testJWT(`{"alg":"ES256"}`, `{"nested":`+deep+`}`),
+		testJWT(`{"alg":"ES256"}`, `{"big":"`+strings.Repeat("x", 4096)+`"}`),
+		testJWT(`{"alg":"ES256"}`, `{"exp":1e300,"iat":-1e300,"nbf":"now"}`),
+		testJWT(`{"alg":"ES256"}`, `{"s":"é世"}`)[:30],
+		"eyJhbGciOiJFUzI1NiJ9.eyJzdWIiOiJ4In0=.sig",
+		"e30.W10.",
+		"..",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, token string) {
+		p, err := parseTokenInsecure(token)
+		if err != nil {
+			return
+		}
+		p.subject()
+		p.audience()
+		p.expiry()
+		for _, m := range []map[string]any{p.Header, p.Claims} {
+			for k, v := range m {
+				// The truncation suffix adds at most a few dozen bytes
+				if out := formatValue(k, v); len(out) > maxValueLength+64 {
+					t.Fatalf("rendering of %q is %d bytes long", k, len(out))
+				}
+			}
+		}
+	})
+}
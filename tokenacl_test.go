@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestWriteTokenOutputFile checks the token writes shared by every platform:
+// the content lands in place or atomically, without a leftover temporary file.
+func TestWriteTokenOutputFile(t *testing.T) {
+	for _, mode := range []string{"direct", "atomic-per-dir"} {
+		t.Run(mode, func(t *testing.T) {
+			s := &SpiffeJWT{FileWriteMode: mode}
+			if trustees, err := resolveTrustees(nil); err == nil {
+				s.tokenFileTrustees = trustees
+			}
+			path := filepath.Join(t.TempDir(), "token")
+			for _, token := range []string{"first-token", "second"} {
+				if err := s.writeTokenOutputFile(path, []byte(token), 0600); err != nil {
+					t.Fatalf("writeTokenOutputFile: %v", err)
+				}
+				got, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != token {
+					t.Errorf("content = %q, want %q", got, token)
+				}
+			}
+			if _, err := os.Stat(tempFileName(path, os.Getpid())); !os.IsNotExist(err) {
+				t.Errorf("temporary file left behind: %v", err)
+			}
+		})
+	}
+}
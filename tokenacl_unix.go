@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// resolveTrustees is only supported on Windows
+func resolveTrustees(accounts []string) ([]string, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// protectTokenFile is only supported on Windows, POSIX modes apply elsewhere
+func protectTokenFile(path string, trustees []string) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestResolveTrusteesUnsupported(t *testing.T) {
+	if _, err := resolveTrustees(nil); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("resolveTrustees error = %v, want ErrUnsupported", err)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// resolveTrustees returns the SIDs of the accounts of
+// --token-file-windows-acl, accounts given either by name or as SIDs. Without
+// any, they are the current user and SYSTEM.
+func resolveTrustees(accounts []string) ([]string, error) {
+	if len(accounts) == 0 {
+		user, err := windows.GetCurrentProcessToken().GetTokenUser()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the current user: %w", err)
+		}
+		system, err := windows.CreateWellKnownSid(windows.WinLocalSystemSid)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the SYSTEM SID: %w", err)
+		}
+		return []string{user.User.Sid.String(), system.String()}, nil
+	}
+
+	var sids []string
+	for _, account := range accounts {
+		var sid *windows.SID
+		var err error
+		if strings.HasPrefix(account, "S-") {
+			sid, err = windows.StringToSid(account)
+		} else {
+			sid, _, _, err = windows.LookupSID("", account)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unknown account %q: %w", account, err)
+		}
+		sids = append(sids, sid.String())
+	}
+	return sids, nil
+}
+
+// protectTokenFile replaces the DACL of path with one granting full access to
+// the trustees only, with inheritance from the directory disabled, and checks
+// the resulting ACL
+func protectTokenFile(path string, trustees []string) error {
+	entries := make([]windows.EXPLICIT_ACCESS, 0, len(trustees))
+	for _, trustee := range trustees {
+		sid, err := windows.StringToSid(trustee)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, windows.EXPLICIT_ACCESS{
+			AccessPermissions: windows.GENERIC_ALL,
+			AccessMode:        windows.SET_ACCESS,
+			Inheritance:       windows.NO_INHERITANCE,
+			Trustee: windows.TRUSTEE{
+				TrusteeForm:  windows.TRUSTEE_IS_SID,
+				TrusteeType:  windows.TRUSTEE_IS_UNKNOWN,
+				TrusteeValue: windows.TrusteeValueFromSID(sid),
+			},
+		})
+	}
+	acl, err := windows.ACLFromEntries(entries, nil)
+	if err != nil {
+		return fmt.Errorf("failed to build ACL: %w", err)
+	}
+	err = windows.SetNamedSecurityInfo(path, windows.SE_FILE_OBJECT,
+		windows.DACL_SECURITY_INFORMATION|windows.PROTECTED_DACL_SECURITY_INFORMATION, nil, nil, acl, nil)
+	if err != nil {
+		return fmt.Errorf("failed to set ACL of %s: %w", path, err)
+	}
+	return verifyTokenFileACL(path, trustees)
+}
+
+// verifyTokenFileACL checks that the DACL of path does not inherit entries
+// and only allows access to the trustees
+func verifyTokenFileACL(path string, trustees []string) error {
+	sd, err := windows.GetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, windows.DACL_SECURITY_INFORMATION)
+	if err != nil {
+		return fmt.Errorf("failed to read ACL of %s: %w", path, err)
+	}
+	control, _, err := sd.Control()
+	if err != nil {
+		return err
+	}
+	if control&windows.SE_DACL_PROTECTED == 0 {
+		return fmt.Errorf("ACL of %s still inherits from its directory", path)
+	}
+	dacl, _, err := sd.DACL()
+	if err != nil {
+		return err
+	}
+	if dacl == nil {
+		return fmt.Errorf("%s has no DACL, granting everyone access", path)
+	}
+	for i := range uint32(dacl.AceCount) {
+		var ace *windows.ACCESS_ALLOWED_ACE
+		if err := windows.GetAce(dacl, i, &ace); err != nil {
+			return err
+		}
+		if ace.Header.AceType != windows.ACCESS_ALLOWED_ACE_TYPE {
+			continue
+		}
+		sid := (*windows.SID)(unsafe.Pointer(&ace.SidStart)).String()
+		if !slices.Contains(trustees, sid) {
+			return fmt.Errorf("ACL of %s grants access to %s", path, sid)
+		}
+	}
+	return nil
+}
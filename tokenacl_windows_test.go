@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/windows"
+)
+
+// localSystemSID is the SID of the SYSTEM account
+const localSystemSID = "S-1-5-18"
+
+func TestResolveTrusteesDefault(t *testing.T) {
+	trustees, err := resolveTrustees(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{user.User.Sid.String(), localSystemSID}
+	if !slices.Equal(trustees, want) {
+		t.Errorf("trustees = %v, want %v", trustees, want)
+	}
+}
+
+func TestResolveTrusteesAccounts(t *testing.T) {
+	trustees, err := resolveTrustees([]string{localSystemSID, "NT AUTHORITY\\SYSTEM"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(trustees, []string{localSystemSID, localSystemSID}) {
+		t.Errorf("trustees = %v", trustees)
+	}
+	if _, err := resolveTrustees([]string{"no-such-account-spiffe-jwt"}); err == nil {
+		t.Error("resolveTrustees accepted an unknown account")
+	}
+}
+
+func TestProtectTokenFile(t *testing.T) {
+	trustees, err := resolveTrustees(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("token"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new file inherits the ACL of the temporary directory
+	if err := verifyTokenFileACL(path, trustees); err == nil || !strings.Contains(err.Error(), "inherits") {
+		t.Errorf("verifyTokenFileACL of an inheriting file = %v", err)
+	}
+	if err := protectTokenFile(path, trustees); err != nil {
+		t.Fatalf("protectTokenFile: %v", err)
+	}
+	if err := verifyTokenFileACL(path, trustees); err != nil {
+		t.Errorf("verifyTokenFileACL: %v", err)
+	}
+	// Access is only allowed to the trustees
+	if err := verifyTokenFileACL(path, trustees[:1]); err == nil {
+		t.Error("verifyTokenFileACL accepted an ACL granting access to another SID")
+	}
+}
+
+// TestWriteFileAtomicProtected checks that the restricted ACL survives the
+// rename of the temporary file over the destination
+func TestWriteFileAtomicProtected(t *testing.T) {
+	trustees, err := resolveTrustees(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "token")
+	if err := writeFileAtomic(path, []byte("token"), 0600, trustees); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyTokenFileACL(path, trustees); err != nil {
+		t.Errorf("verifyTokenFileACL: %v", err)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	tokenv1 "github.com/CentML/spiffe-jwt/proto/token/v1"
+)
+
+// peerAuthInfo carries the peer credentials of a token API connection
+type peerAuthInfo struct {
+	credentials.CommonAuthInfo
+	uid, pid int
+}
+
+// AuthType implements credentials.AuthInfo
+func (peerAuthInfo) AuthType() string { return "peercred" }
+
+// peerCredentialsTransport reads the credentials of the process connecting
+// to the token API socket during the handshake. It does not encrypt, the
+// connection never leaves the host.
+type peerCredentialsTransport struct{}
+
+func (peerCredentialsTransport) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, errors.New("peer credentials are server side only")
+}
+
+func (peerCredentialsTransport) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	uid, pid, err := peerCredentials(conn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read peer credentials: %w", err)
+	}
+	info := peerAuthInfo{
+		CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.NoSecurity},
+		uid:            uid,
+		pid:            pid,
+	}
+	return conn, info, nil
+}
+
+func (peerCredentialsTransport) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "peercred"}
+}
+
+func (t peerCredentialsTransport) Clone() credentials.TransportCredentials { return t }
+
+func (peerCredentialsTransport) OverrideServerName(string) error { return nil }
+
+// tokenWatchers fans the rotations of the primary token out to the
+// WatchToken streams. Each watcher only holds the latest token, so a slow
+// consumer skips rotations instead of holding up the refresh loop.
+type tokenWatchers struct {
+	mu   sync.Mutex
+	subs map[chan *tokenv1.Token]struct{}
+}
+
+// watch registers a new watcher
+func (w *tokenWatchers) watch() chan *tokenv1.Token {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.subs == nil {
+		w.subs = make(map[chan *tokenv1.Token]struct{})
+	}
+	ch := make(chan *tokenv1.Token, 1)
+	w.subs[ch] = struct{}{}
+	return ch
+}
+
+// unwatch removes a watcher
+func (w *tokenWatchers) unwatch(ch chan *tokenv1.Token) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.subs, ch)
+}
+
+// publish sends a rotated token to every watcher, replacing the one it has
+// not received yet
+func (w *tokenWatchers) publish(jwt *jwtsvid.SVID) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.subs) == 0 {
+		return
+	}
+	t := tokenMessage(jwt)
+	for ch := range w.subs {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- t
+	}
+}
+
+// tokenMessage converts a JWT SVID to its token API message
+func tokenMessage(jwt *jwtsvid.SVID) *tokenv1.Token {
+	return &tokenv1.Token{
+		Token:     jwt.Marshal(),
+		SpiffeId:  jwt.ID.String(),
+		Audience:  jwt.Audience,
+		ExpiresAt: jwt.Expiry.Unix(),
+	}
+}
+
+// tokenAPIServer implements spiffejwt.token.v1.TokenService for the primary
+// token
+type tokenAPIServer struct {
+	tokenv1.UnimplementedTokenServiceServer
+	s *SpiffeJWT
+	// Done when the daemon shuts down, ending the WatchToken streams
+	ctx context.Context
+}
+
+// GetToken returns the current JWT SVID
+func (a *tokenAPIServer) GetToken(ctx context.Context, req *tokenv1.GetTokenRequest) (*tokenv1.Token, error) {
+	jwt, err := a.current()
+	if err != nil {
+		return nil, err
+	}
+	return tokenMessage(jwt), nil
+}
+
+// WatchToken streams the current JWT SVID, if any, then every rotation
+func (a *tokenAPIServer) WatchToken(req *tokenv1.WatchTokenRequest, stream tokenv1.TokenService_WatchTokenServer) error {
+	ch := a.s.tokenWatchers.watch()
+	defer a.s.tokenWatchers.unwatch(ch)
+
+	if jwt, err := a.current(); err == nil {
+		if err := stream.Send(tokenMessage(jwt)); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case t := <-ch:
+			if err := stream.Send(t); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		case <-a.ctx.Done():
+			return status.Error(codes.Unavailable, "shutting down")
+		}
+	}
+}
+
+// current returns the current JWT SVID, or an Unavailable error before the
+// first one is written and once it expired
+func (a *tokenAPIServer) current() (*jwtsvid.SVID, error) {
+	a.s.mu.RLock()
+	jwt := a.s.current
+	a.s.mu.RUnlock()
+	if jwt == nil {
+		return nil, status.Error(codes.Unavailable, "no JWT SVID yet")
+	}
+	if !time.Now().Before(jwt.Expiry) {
+		return nil, status.Error(codes.Unavailable, "JWT SVID expired")
+	}
+	return jwt, nil
+}
+
+// authorize allows the uids of --token-api-allowed-uid, the uid of the
+// daemon when unset
+func (s *SpiffeJWT) authorize(ctx context.Context, method string) error {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return status.Error(codes.PermissionDenied, "unknown peer")
+	}
+	info, ok := p.AuthInfo.(peerAuthInfo)
+	if !ok {
+		return status.Error(codes.PermissionDenied, "unknown peer")
+	}
+	allowed := s.TokenAPIAllowedUIDs
+	if len(allowed) == 0 {
+		allowed = []int{os.Getuid()}
+	}
+	if !slices.Contains(allowed, info.uid) {
+		logrus.WithFields(logrus.Fields{"uid": info.uid, "pid": info.pid}).Warnf("Token API call %s denied", method)
+		return status.Errorf(codes.PermissionDenied, "uid %d is not allowed", info.uid)
+	}
+	logrus.WithFields(logrus.Fields{"uid": info.uid, "pid": info.pid}).Debugf("Token API call %s", method)
+	return nil
+}
+
+// listenTokenAPI opens the socket of --token-api-socket, replacing a stale
+// one. Anything else at the path is left alone. Any local user may connect,
+// calls are authorized by uid.
+func (s *SpiffeJWT) listenTokenAPI() (net.Listener, error) {
+	if err := removeStaleSocket(s.TokenAPISocket); err != nil {
+		return nil, fmt.Errorf("invalid --token-api-socket: %w", err)
+	}
+	lis, err := net.Listen("unix", s.TokenAPISocket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on --token-api-socket: %w", err)
+	}
+	if err := os.Chmod(s.TokenAPISocket, 0o666); err != nil {
+		lis.Close()
+		return nil, fmt.Errorf("failed to set the mode of the token API socket: %w", err)
+	}
+	return lis, nil
+}
+
+// removeStaleSocket removes the socket at path left by an earlier run. It
+// fails if anything else is there.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return nil
+	case err != nil:
+		return err
+	case info.Mode()&fs.ModeSocket == 0:
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+	return nil
+}
+
+// serveTokenAPI serves the token API on lis until ctx is done, then stops
+// it gracefully
+func (s *SpiffeJWT) serveTokenAPI(ctx context.Context, lis net.Listener) {
+	server := grpc.NewServer(
+		grpc.Creds(peerCredentialsTransport{}),
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			if err := s.authorize(ctx, info.FullMethod); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := s.authorize(ss.Context(), info.FullMethod); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	)
+	tokenv1.RegisterTokenServiceServer(server, &tokenAPIServer{s: s, ctx: ctx})
+
+	go func() {
+		<-ctx.Done()
+		stopped := make(chan struct{})
+		go func() {
+			server.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(5 * time.Second):
+			logrus.Warn("Token API did not shut down gracefully")
+			server.Stop()
+		}
+	}()
+
+	logrus.Infof("Serving the token API on %s", s.TokenAPISocket)
+	if err := server.Serve(lis); err != nil {
+		logrus.WithError(err).Fatal("Token API failed")
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestListenTokenAPI checks that a stale socket is replaced while any other
+// file at the path is left alone
+func TestListenTokenAPI(t *testing.T) {
+	t.Run("stale socket", func(t *testing.T) {
+		s := &SpiffeJWT{TokenAPISocket: filepath.Join(t.TempDir(), "token.sock")}
+		stale, err := s.listenTokenAPI()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Closing would unlink it, as a crashed daemon does not
+		stale.(*net.UnixListener).SetUnlinkOnClose(false)
+		stale.Close()
+
+		lis, err := s.listenTokenAPI()
+		if err != nil {
+			t.Fatalf("listenTokenAPI over a stale socket: %v", err)
+		}
+		lis.Close()
+	})
+
+	t.Run("regular file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "token")
+		if err := os.WriteFile(path, []byte("keep"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		s := &SpiffeJWT{TokenAPISocket: path}
+		if lis, err := s.listenTokenAPI(); err == nil {
+			lis.Close()
+			t.Fatal("listenTokenAPI replaced a regular file")
+		}
+		if data, err := os.ReadFile(path); err != nil || string(data) != "keep" {
+			t.Errorf("file at the socket path changed: %q, %v", data, err)
+		}
+	})
+}
@@ -0,0 +1,101 @@
+// Package tokenclient is a client of the token API of spiffe-jwt, served on
+// a unix socket with --token-api-socket.
+//
+//	c, err := tokenclient.Dial("/run/spiffe-jwt/token.sock")
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close()
+//	err = c.Watch(ctx, func(t *tokenclient.Token) error {
+//		setBearer(t.Token)
+//		return nil
+//	})
+package tokenclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	tokenv1 "github.com/CentML/spiffe-jwt/proto/token/v1"
+)
+
+// Token is a JWT SVID served by spiffe-jwt
+type Token struct {
+	// Token is the JWT SVID in compact serialization
+	Token    string
+	SpiffeID string
+	Audience []string
+	Expiry   time.Time
+}
+
+// Client is a connection to the token API. It is safe for concurrent use.
+type Client struct {
+	conn   *grpc.ClientConn
+	client tokenv1.TokenServiceClient
+}
+
+// Dial creates a client of the token API served on socketPath. The
+// connection is established lazily on the first call.
+func Dial(socketPath string) (*Client, error) {
+	conn, err := grpc.NewClient("unix://"+socketPath, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gRPC client: %w", err)
+	}
+	return &Client{conn: conn, client: tokenv1.NewTokenServiceClient(conn)}, nil
+}
+
+// Close closes the connection to the token API
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Get returns the current JWT SVID
+func (c *Client) Get(ctx context.Context) (*Token, error) {
+	t, err := c.client.GetToken(ctx, &tokenv1.GetTokenRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return fromMessage(t), nil
+}
+
+// Watch calls fn with the current JWT SVID, if any, and then with every
+// rotation until ctx is done, fn returns an error or the stream ends. It
+// returns nil when ctx is done. Callers that must survive restarts of
+// spiffe-jwt call Watch again after an error.
+func (c *Client) Watch(ctx context.Context, fn func(*Token) error) error {
+	stream, err := c.client.WatchToken(ctx, &tokenv1.WatchTokenRequest{})
+	if err != nil {
+		return err
+	}
+	for {
+		t, err := stream.Recv()
+		if ctx.Err() != nil {
+			return nil
+		}
+		if errors.Is(err, io.EOF) {
+			return errors.New("token stream ended")
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(fromMessage(t)); err != nil {
+			return err
+		}
+	}
+}
+
+// fromMessage converts a token API message
+func fromMessage(t *tokenv1.Token) *Token {
+	return &Token{
+		Token:    t.GetToken(),
+		SpiffeID: t.GetSpiffeId(),
+		Audience: t.GetAudience(),
+		Expiry:   time.Unix(t.GetExpiresAt(), 0),
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+)
+
+// primaryTokenName is the name of the JWT SVID of --jwt-audience among the
+// tokens of --token
+const primaryTokenName = "default"
+
+// tokenState is the health of one token, reported by /status and /readyz/{name}
+type tokenState struct {
+	Name           string     `json:"name"`
+	Audience       string     `json:"audience"`
+	File           string     `json:"file,omitempty"`
+	LastSuccess    *time.Time `json:"last_success,omitempty"`
+	Expiry         *time.Time `json:"expiry,omitempty"`
+	FailureStreak  int        `json:"failure_streak"`
+	LastErrorClass string     `json:"last_error_class,omitempty"`
+}
+
+// ready reports whether the token holds a JWT SVID that has not expired
+func (t *tokenState) ready() bool {
+	return t.Expiry != nil && time.Now().Before(*t.Expiry)
+}
+
+// validateReadinessPolicy checks that the critical tokens of
+// --readiness-policy=critical exist
+func (s *SpiffeJWT) validateReadinessPolicy() error {
+	if s.ReadinessPolicy != "critical" {
+		if len(s.ReadinessCriticalTokens) > 0 {
+			return errors.New("--readiness-critical-tokens requires --readiness-policy=critical")
+		}
+		return nil
+	}
+	if len(s.ReadinessCriticalTokens) == 0 {
+		return errors.New("--readiness-policy=critical requires --readiness-critical-tokens")
+	}
+	for _, name := range s.ReadinessCriticalTokens {
+		if name != primaryTokenName && !slices.ContainsFunc(s.Tokens, func(t tokenSpec) bool { return t.Name == name }) {
+			return fmt.Errorf("unknown critical token %q", name)
+		}
+	}
+	return nil
+}
+
+// initTokenStates registers the primary token and every --token
+func (s *SpiffeJWT) initTokenStates() {
+	s.tokenStates = []*tokenState{{Name: primaryTokenName, Audience: s.JWTAudience, File: s.JWTFileName}}
+	for _, t := range s.Tokens {
+		s.tokenStates = append(s.tokenStates, &tokenState{Name: t.Name, Audience: t.Audience, File: t.File})
+	}
+}
+
+// recordToken records the outcome of a refresh of the named token
+func (s *SpiffeJWT) recordToken(name string, jwt *jwtsvid.SVID, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.tokenStates, func(t *tokenState) bool { return t.Name == name })
+	if i < 0 {
+		return
+	}
+	t := s.tokenStates[i]
+	if err != nil {
+		t.FailureStreak++
+		t.LastErrorClass = "unknown"
+		var ce *classifiedError
+		if errors.As(err, &ce) {
+			t.LastErrorClass = ce.class
+		}
+		return
+	}
+	now, expiry := time.Now(), jwt.Expiry
+	t.LastSuccess, t.Expiry = &now, &expiry
+	t.FailureStreak, t.LastErrorClass = 0, ""
+}
+
+// tokenStatuses returns a snapshot of the health of every token. The caller
+// must hold s.mu.
+func (s *SpiffeJWT) tokenStatuses() []tokenState {
+	states := make([]tokenState, len(s.tokenStates))
+	for i, t := range s.tokenStates {
+		states[i] = *t
+	}
+	return states
+}
+
+// tokensNotReady returns the tokens failing --readiness-policy, if the
+// policy is not met
+func (s *SpiffeJWT) tokensNotReady() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var notReady []string
+	for _, t := range s.tokenStates {
+		if !t.ready() {
+			notReady = append(notReady, t.Name)
+		}
+	}
+	switch s.ReadinessPolicy {
+	case "quorum":
+		if len(s.tokenStates)-len(notReady) > len(s.tokenStates)/2 {
+			return nil
+		}
+	case "critical":
+		notReady = slices.DeleteFunc(notReady, func(name string) bool {
+			return !slices.Contains(s.ReadinessCriticalTokens, name)
+		})
+	}
+	return notReady
+}
+
+// tokensExpired reports whether every named token is not ready because its
+// JWT SVID expired, rather than because none was fetched yet
+func (s *SpiffeJWT) tokensExpired(names []string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, t := range s.tokenStates {
+		if slices.Contains(names, t.Name) && t.Expiry == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// handleTokenReadyz reports whether the named token is ready, regardless
+// of --readiness-policy
+func (s *SpiffeJWT) handleTokenReadyz(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	s.mu.RLock()
+	i := slices.IndexFunc(s.tokenStates, func(t *tokenState) bool { return t.Name == name })
+	var t tokenState
+	if i >= 0 {
+		t = *s.tokenStates[i]
+	}
+	s.mu.RUnlock()
+
+	switch {
+	case i < 0:
+		writeJSON(w, http.StatusNotFound, readiness{Reason: "unknown token"})
+	case atomic.LoadInt32(&s.draining) == 1:
+		writeJSON(w, http.StatusServiceUnavailable, readiness{Reason: "draining"})
+	case t.ready():
+		writeJSON(w, http.StatusOK, readiness{Ready: true})
+	case t.Expiry != nil:
+		code, body := s.expired(readiness{Reason: "expired"})
+		writeJSON(w, code, body)
+	case t.FailureStreak > 0:
+		writeJSON(w, http.StatusServiceUnavailable, readiness{Reason: "failing"})
+	default:
+		writeJSON(w, http.StatusServiceUnavailable, readiness{Reason: "starting"})
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+// newTestTokenStates returns a daemon with the primary token and tokens a, b
+// and c, where the named tokens hold a valid JWT SVID and the others an
+// expired one
+func newTestTokenStates(policy string, critical []string, ready ...string) *SpiffeJWT {
+	s := &SpiffeJWT{
+		JWTAudience:             "primary",
+		ReadinessPolicy:         policy,
+		ReadinessCriticalTokens: critical,
+		Tokens: []tokenSpec{
+			{Name: "a", Audience: "aud-a"},
+			{Name: "b", Audience: "aud-b"},
+			{Name: "c", Audience: "aud-c"},
+		},
+	}
+	s.initTokenStates()
+	for _, t := range s.tokenStates {
+		expiry := time.Now().Add(-time.Minute)
+		if slices.Contains(ready, t.Name) {
+			expiry = time.Now().Add(time.Hour)
+		}
+		t.Expiry = &expiry
+	}
+	return s
+}
+
+// TestTokensNotReady checks every --readiness-policy
+func TestTokensNotReady(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		policy   string
+		critical []string
+		ready    []string
+		want     []string
+	}{
+		{"all ready", "all", nil, []string{"default", "a", "b", "c"}, nil},
+		{"all with one failing", "all", nil, []string{"default", "a", "b"}, []string{"c"}},
+		{"quorum met", "quorum", nil, []string{"default", "a", "b"}, nil},
+		{"quorum tied", "quorum", nil, []string{"default", "a"}, []string{"b", "c"}},
+		{"quorum lost", "quorum", nil, []string{"a"}, []string{"default", "b", "c"}},
+		{"critical ready", "critical", []string{"default", "b"}, []string{"default", "b"}, nil},
+		{"critical failing", "critical", []string{"default", "b"}, []string{"default", "a", "c"}, []string{"b"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestTokenStates(tc.policy, tc.critical, tc.ready...)
+			if got := s.tokensNotReady(); !slices.Equal(got, tc.want) {
+				t.Errorf("tokensNotReady() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestValidateReadinessPolicy checks the critical tokens of every policy
+func TestValidateReadinessPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		policy   string
+		critical []string
+		wantErr  bool
+	}{
+		{"all", "all", nil, false},
+		{"quorum", "quorum", nil, false},
+		{"critical tokens without the critical policy", "quorum", []string{"a"}, true},
+		{"critical", "critical", []string{"default", "a"}, false},
+		{"critical without tokens", "critical", nil, true},
+		{"critical unknown token", "critical", []string{"d"}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestTokenStates(tc.policy, tc.critical)
+			if err := s.validateReadinessPolicy(); (err != nil) != tc.wantErr {
+				t.Errorf("validateReadinessPolicy() = %v, want error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+// TestHandleTokenReadyz checks /readyz/{name}, which ignores the policy
+func TestHandleTokenReadyz(t *testing.T) {
+	s := newTestTokenStates("quorum", nil, "default", "a", "b")
+	s.ExpiredResponseCode = http.StatusServiceUnavailable
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /readyz/{name}", s.handleTokenReadyz)
+
+	for _, tc := range []struct {
+		name       string
+		wantCode   int
+		wantReason string
+	}{
+		{"a", http.StatusOK, ""},
+		{"c", http.StatusServiceUnavailable, "expired"},
+		{"d", http.StatusNotFound, "unknown token"},
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz/"+tc.name, nil))
+		var body readiness
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if rec.Code != tc.wantCode || body.Reason != tc.wantReason {
+			t.Errorf("/readyz/%s = %d %q, want %d %q", tc.name, rec.Code, body.Reason, tc.wantCode, tc.wantReason)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+)
+
+// tokenMapMetaKey is the key of the per-audience metadata in the token map file
+const tokenMapMetaKey = "_meta"
+
+// tokenMapMeta is the metadata of the token of an audience in the token map file
+type tokenMapMeta struct {
+	Expiry   time.Time `json:"expiry"`
+	SpiffeID string    `json:"spiffe_id"`
+}
+
+// tokenMap aggregates the current token of every audience into the single
+// JSON document of --token-map-file, {"<audience>": "<jwt>", ...} with the
+// metadata under "_meta". An audience that fails to refresh keeps its last
+// good token.
+type tokenMap struct {
+	mu     sync.Mutex
+	path   string
+	tokens map[string]*secretBuffer
+	meta   map[string]tokenMapMeta
+}
+
+// validateTokenMap checks that every audience can be a key of the token map
+func (s *SpiffeJWT) validateTokenMap() error {
+	if s.encryptionKey != nil {
+		return errors.New("--token-map-file cannot be used in encrypted persistence mode")
+	}
+	audiences := map[string]bool{s.JWTAudience: true}
+	for _, t := range s.Tokens {
+		if audiences[t.Audience] {
+			return fmt.Errorf("audience %q is configured more than once, it cannot be a single key of --token-map-file", t.Audience)
+		}
+		audiences[t.Audience] = true
+	}
+	if audiences[tokenMapMetaKey] {
+		return fmt.Errorf("audience %q is reserved by --token-map-file", tokenMapMetaKey)
+	}
+	return nil
+}
+
+// loadTokenMap starts the token map from the existing file, if any, so that
+// the last good tokens survive a restart during which an audience fails.
+// Only the configured audiences are kept.
+func (s *SpiffeJWT) loadTokenMap() {
+	m := &tokenMap{path: s.TokenMapFile, tokens: map[string]*secretBuffer{}, meta: map[string]tokenMapMeta{}}
+	s.tokenMap = m
+
+	data, err := readFileLimited(m.path, maxReadBackSize)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	var doc map[string]json.RawMessage
+	if err == nil {
+		err = json.Unmarshal(data, &doc)
+	}
+	if err != nil {
+		logrus.WithError(err).Warnf("Ignoring the content of token map file %s", m.path)
+		return
+	}
+	var meta map[string]tokenMapMeta
+	if raw, ok := doc[tokenMapMetaKey]; ok {
+		_ = json.Unmarshal(raw, &meta)
+	}
+
+	audiences := []string{s.JWTAudience}
+	for _, t := range s.Tokens {
+		audiences = append(audiences, t.Audience)
+	}
+	for _, aud := range audiences {
+		var token string
+		if raw, ok := doc[aud]; ok && json.Unmarshal(raw, &token) == nil && token != "" {
+			m.tokens[aud], m.meta[aud] = newSecretBuffer([]byte(token)), meta[aud]
+		}
+	}
+}
+
+// updateTokenMap records the new token of an audience and rewrites the token
+// map file. Failures are logged, the token files remain the source of truth.
+func (s *SpiffeJWT) updateTokenMap(ctx context.Context, audience string, jwt *jwtsvid.SVID) {
+	if s.tokenMap == nil {
+		return
+	}
+	// The map holds the bearer token of every audience and is read as a
+	// whole, it is always replaced atomically
+	write := func(path string, data []byte) error {
+		return s.writeAtomicTokenOutputFile(path, data, 0600)
+	}
+	if err := s.tokenMap.update(audience, jwt, write); err != nil {
+		writeErrors.WithLabelValues("token-map").Inc()
+		logrus.WithContext(ctx).WithError(err).WithField(failureClassField, failureWrite).Errorf("unable to write token map file %s", s.tokenMap.path)
+	}
+}
+
+// update sets the token of an audience and writes the file with write
+func (m *tokenMap) update(audience string, jwt *jwtsvid.SVID, write func(path string, data []byte) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.tokens[audience].release()
+	m.tokens[audience] = newSecretBuffer([]byte(jwt.Marshal()))
+	m.meta[audience] = tokenMapMeta{Expiry: jwt.Expiry, SpiffeID: jwt.ID.String()}
+
+	doc := make(map[string]any, len(m.tokens)+1)
+	for aud, token := range m.tokens {
+		doc[aud] = token.String()
+	}
+	doc[tokenMapMetaKey] = m.meta
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode token map: %w", err)
+	}
+	if err := write(m.path, append(data, '\n')); err != nil {
+		return err
+	}
+	logrus.WithField("audience", audience).Infof("Token map %s updated", m.path)
+	return nil
+}
@@ -0,0 +1,64 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestTokenMapAtomic checks that the token map is replaced through a rename
+// in direct mode: a reader holding the old file keeps a complete document
+func TestTokenMapAtomic(t *testing.T) {
+	dir := t.TempDir()
+	s := &SpiffeJWT{FileWriteMode: "direct", JWTAudience: "a", TokenMapFile: filepath.Join(dir, "tokens.json")}
+	s.loadTokenMap()
+
+	first := signTestSVID(t, "a", time.Now().Add(time.Hour))
+	s.updateTokenMap(context.Background(), "a", first)
+	reader, err := os.Open(s.TokenMapFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	second := signTestSVID(t, "a", time.Now().Add(2*time.Hour))
+	s.updateTokenMap(context.Background(), "a", second)
+
+	for _, tc := range []struct {
+		name  string
+		open  func() (io.Reader, error)
+		token string
+	}{
+		{"reader of the old file", func() (io.Reader, error) { return reader, nil }, first.Marshal()},
+		{"new file", func() (io.Reader, error) { return os.Open(s.TokenMapFile) }, second.Marshal()},
+	} {
+		r, err := tc.open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var doc map[string]any
+		if err := json.NewDecoder(r).Decode(&doc); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if doc["a"] != tc.token {
+			t.Errorf("%s holds another token", tc.name)
+		}
+	}
+
+	info, err := os.Stat(s.TokenMapFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("token map has mode %s, want 0600", info.Mode().Perm())
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, ".tokens.json.tmp.*")); len(matches) > 0 {
+		t.Errorf("temporary files left: %v", matches)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+)
+
+// tokenSpec is an additional JWT SVID of --token, written as
+// audience=<audience>,file=<path>[,name=<name>]. The name defaults to the
+// audience.
+type tokenSpec struct {
+	Name     string
+	Audience string
+	File     string
+}
+
+func (t *tokenSpec) UnmarshalText(text []byte) error {
+	*t = tokenSpec{}
+	for _, field := range strings.Split(string(text), ",") {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok || value == "" {
+			return fmt.Errorf("invalid token %q: expected key=value, got %q", text, field)
+		}
+		switch strings.TrimSpace(key) {
+		case "audience":
+			// Trimmed with a warning by validateAudiences
+			t.Audience = value
+		case "file":
+			t.File = value
+		case "name":
+			t.Name = value
+		default:
+			return fmt.Errorf("invalid token %q: unknown key %q, expected audience, file or name", text, key)
+		}
+	}
+	if strings.TrimSpace(t.Audience) == "" || t.File == "" {
+		return fmt.Errorf("invalid token %q: audience and file are required, and the audience must not be only whitespace", text)
+	}
+	if t.Name == "" {
+		t.Name = strings.TrimSpace(t.Audience)
+	}
+	return nil
+}
+
+// validateTokens makes sure every token has its own name and file
+func (s *SpiffeJWT) validateTokens() error {
+	names := map[string]bool{primaryTokenName: true}
+	files := map[string]bool{s.JWTFileName: true}
+	for _, t := range s.Tokens {
+		if names[t.Name] {
+			return fmt.Errorf("duplicate token name %q, set distinct names", t.Name)
+		}
+		if files[t.File] {
+			return fmt.Errorf("token file %s is written more than once", t.File)
+		}
+		names[t.Name], files[t.File] = true, true
+	}
+	if s.TokenConcurrency < 1 {
+		return errors.New("--token-concurrency must be at least 1")
+	}
+	return nil
+}
+
+// Backoff between attempts of an additional JWT SVID that failed in daemon mode
+const (
+	tokenRetryMin = 5 * time.Second
+	tokenRetryMax = time.Minute
+)
+
+// tokenLoop keeps an additional JWT SVID of --token refreshed in daemon mode
+// until ctx is done. Unlike the primary token, failures are retried with
+// backoff rather than fatal, so that one broken audience does not take the
+// others down.
+func (s *SpiffeJWT) tokenLoop(ctx context.Context, t tokenSpec) {
+	log := logrus.WithFields(logrus.Fields{"name": t.Name, "audience": t.Audience})
+	failures := 0
+	for {
+		var wait time.Duration
+		if s.paused() {
+			// Checked again shortly, so the token catches up once resumed
+			wait = tokenRetryMin
+			log.Debug("Skipping token refresh, refreshes are paused")
+		} else if jwt, err := s.fetchAndWriteToken(s.fetchCtx, t.Audience, t.File); err != nil && ctx.Err() != nil {
+			log.WithError(err).Info("Token refresh interrupted by shutdown")
+			return
+		} else if err != nil {
+			s.recordToken(t.Name, jwt, err)
+			wait = min(tokenRetryMin<<min(failures, 4), tokenRetryMax)
+			failures++
+			failureLog(err).WithFields(log.Data).Errorf("unable to refresh token, retrying in %s", wait)
+		} else {
+			s.recordToken(t.Name, jwt, nil)
+			failures = 0
+			wait = s.getRefreshInterval(jwt)
+			log.Infof("Token will be refreshed in %s", wait)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// tokenResult is the outcome of fetching and writing one JWT SVID
+type tokenResult struct {
+	name     string
+	audience string
+	file     string
+	jwt      *jwtsvid.SVID
+	err      error
+	took     time.Duration
+}
+
+// fetchAndWriteTokens fetches and writes the JWT SVID of --jwt-audience and
+// of every --token in parallel over a single connection to the SPIFFE agent,
+// then reports a per-audience summary. It fails if any token failed, or with
+// --best-effort only if none was written.
+func (s *SpiffeJWT) fetchAndWriteTokens() error {
+	ctx := context.Background()
+	sourceCtx, cancel := context.WithTimeout(ctx, s.FetchTimeout)
+	source, err := s.newJWTSource(sourceCtx)
+	cancel()
+	if err != nil {
+		return err
+	}
+	s.source = source
+	defer func() {
+		s.source = nil
+		source.Close()
+	}()
+
+	results := make([]tokenResult, len(s.Tokens)+1)
+	results[0] = tokenResult{name: primaryTokenName, audience: s.JWTAudience, file: s.JWTFileName}
+	for i, t := range s.Tokens {
+		results[i+1] = tokenResult{name: t.Name, audience: t.Audience, file: t.File}
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, s.TokenConcurrency)
+	for i := range results {
+		wg.Add(1)
+		go func(r *tokenResult, primary bool) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			start := time.Now()
+			if primary {
+				r.jwt, r.err = s.fetchAndWriteJWTSVID(ctx)
+			} else {
+				r.jwt, r.err = s.fetchAndWriteToken(ctx, r.audience, r.file)
+			}
+			r.took = time.Since(start)
+		}(&results[i], i == 0)
+	}
+	wg.Wait()
+
+	if primary := &results[0]; primary.err == nil && s.wantsBundle() {
+		if err := s.fetchAndWriteJWTBundle(ctx, primary.jwt.ID.TrustDomain()); err != nil {
+			s.stats.recordFailure(failureBundle)
+			primary.err = &classifiedError{class: failureBundle, err: err}
+		}
+	}
+
+	written := 0
+	for _, r := range results {
+		entry := logrus.WithFields(logrus.Fields{
+			"name":     r.name,
+			"audience": r.audience,
+			"file":     r.file,
+			"took":     r.took.Round(time.Millisecond).String(),
+		})
+		if r.err != nil {
+			failureLog(r.err).WithFields(entry.Data).Error("Token failed")
+			continue
+		}
+		written++
+		entry.WithField("expires_in", time.Until(r.jwt.Expiry).Round(time.Second).String()).Info("Token written")
+	}
+	logrus.Infof("%d of %d tokens written", written, len(results))
+
+	switch {
+	case written == len(results):
+		return nil
+	case s.BestEffort && written > 0:
+		logrus.Warnf("%d tokens failed, ignored with --best-effort", len(results)-written)
+		return nil
+	default:
+		return fmt.Errorf("%d of %d tokens failed", len(results)-written, len(results))
+	}
+}
+
+// fetchAndWriteToken refreshes the token of an additional audience under a new
+// correlation ID, carried by its errors
+func (s *SpiffeJWT) fetchAndWriteToken(ctx context.Context, audience, file string) (*jwtsvid.SVID, error) {
+	ctx, id := withRefreshID(ctx)
+	jwt, err := s.refreshToken(ctx, audience, file)
+	if err != nil {
+		return nil, &refreshError{id: id, err: err}
+	}
+	return jwt, nil
+}
+
+// refreshToken fetches a JWT SVID for an additional audience and writes it to file
+func (s *SpiffeJWT) refreshToken(ctx context.Context, audience, file string) (*jwtsvid.SVID, error) {
+	s.inflight.RLock()
+	defer s.inflight.RUnlock()
+
+	if s.DeadlinePropagation {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.FetchTimeout)
+		defer cancel()
+	}
+
+	jwt, err := s.fetchJWTSVIDForAudience(ctx, audience)
+	var rejected *rejectedTokenError
+	if errors.As(err, &rejected) {
+		return nil, s.rejected(rejected)
+	}
+	if err != nil {
+		s.stats.recordFailure(failureFetch)
+		return nil, &classifiedError{class: failureFetch, err: fmt.Errorf("failed to fetch JWT: %w", err)}
+	}
+	if err := s.validateJWTSVID(jwt, audience); err != nil {
+		return nil, s.rejected(err)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, &classifiedError{class: failureWrite, err: fmt.Errorf("failed to write JWT: %w", err)}
+	}
+	if err := s.writeTokenFile(ctx, file, jwt); err != nil {
+		s.stats.recordFailure(failureWrite)
+		writeErrors.WithLabelValues("file").Inc()
+		return nil, &classifiedError{class: failureWrite, err: fmt.Errorf("failed to write JWT: %w", err)}
+	}
+	s.updateTokenMap(ctx, audience, jwt)
+	if s.audit != nil {
+		if err := s.audit.append(newTokenAuditRecord(jwt)); err != nil {
+			writeErrors.WithLabelValues("audit").Inc()
+			logrus.WithContext(ctx).WithError(err).Error("unable to record JWT SVID in the audit log")
+		}
+	}
+	return jwt, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+)
+
+// Backoff between fetches after a rejected JWT SVID
+const (
+	rejectedRetryMin = 5 * time.Second
+	rejectedRetryMax = time.Minute
+)
+
+// Reasons of a rejected JWT SVID, the reason label of spiffe_jwt_rejected_total
+const (
+	rejectAudience = "audience"
+	rejectAlg      = "alg"
+	rejectSkew     = "skew"
+	rejectExpired  = "expired"
+	rejectHint     = "hint"
+	rejectIssuer   = "issuer"
+)
+
+// rejectedTokenError is a fetched JWT SVID that failed validation
+type rejectedTokenError struct {
+	reason string
+	err    error
+}
+
+func (e *rejectedTokenError) Error() string { return e.err.Error() }
+func (e *rejectedTokenError) Unwrap() error { return e.err }
+
+// validateJWTSVID checks a fetched JWT SVID beyond the Workload API before
+// it is written anywhere: it must carry the requested audience, be signed
+// with an allowed algorithm, come from the pinned issuer, be issued within
+// the tolerated clock skew and have some lifetime left
+func (s *SpiffeJWT) validateJWTSVID(jwt *jwtsvid.SVID, audience string) *rejectedTokenError {
+	if !slices.ContainsFunc(jwt.Audience, func(aud string) bool { return s.audienceMatches(aud, audience) }) {
+		return &rejectedTokenError{rejectAudience, fmt.Errorf("audience %v does not match %q in %s mode", jwt.Audience, s.audienceExpected(audience), s.AudienceValidationMode)}
+	}
+
+	algs := make([]jose.SignatureAlgorithm, len(s.AllowedAlgorithms))
+	for i, alg := range s.AllowedAlgorithms {
+		algs[i] = jose.SignatureAlgorithm(alg)
+	}
+	if _, err := jose.ParseSigned(jwt.Marshal(), algs); err != nil {
+		return &rejectedTokenError{rejectAlg, fmt.Errorf("signature algorithm not in --allowed-algorithms: %w", err)}
+	}
+
+	// Guards against an agent reconfigured to another upstream server
+	if s.ExpectedIssuer != "" {
+		if iss, _ := jwt.Claims["iss"].(string); iss != s.ExpectedIssuer {
+			return &rejectedTokenError{rejectIssuer, fmt.Errorf("issuer %q does not match --expected-issuer %q", iss, s.ExpectedIssuer)}
+		}
+	}
+
+	now := time.Now()
+	if iat, ok := jwt.Claims["iat"].(float64); ok {
+		if issued := time.Unix(int64(iat), 0); issued.After(now.Add(s.MaxIssuedAtSkew)) {
+			return &rejectedTokenError{rejectSkew, fmt.Errorf("issued at %s, more than %s in the future", issued.Format(time.RFC3339), s.MaxIssuedAtSkew)}
+		}
+	}
+	// Writing a token without remaining lifetime would only make the refresh
+	// loop spin on the minimum interval
+	if remaining := jwt.Expiry.Add(s.TokenExpirySlack).Sub(now); remaining <= 0 {
+		return &rejectedTokenError{rejectExpired, fmt.Errorf("no remaining lifetime, expires at %s and local time is %s",
+			jwt.Expiry.Format(time.RFC3339), now.Format(time.RFC3339))}
+	}
+	return nil
+}
+
+// selectHint picks the JWT SVID carrying --svid-hint among the ones of the
+// workload. When none does, as while its registration is being changed, the
+// fetch is rejected so that the current token is kept and the fetch retried,
+// unless --svid-hint-mismatch=fallback picks the first one instead.
+func (s *SpiffeJWT) selectHint(ctx context.Context, svids []*jwtsvid.SVID) (*jwtsvid.SVID, error) {
+	if i := slices.IndexFunc(svids, func(svid *jwtsvid.SVID) bool { return svid.Hint == s.SVIDHint }); i >= 0 {
+		return svids[i], nil
+	}
+	hintMismatches.Inc()
+	hints := make([]string, len(svids))
+	for i, svid := range svids {
+		hints[i] = svid.Hint
+	}
+	if s.SVIDHintMismatch != "fallback" || len(svids) == 0 {
+		return nil, &rejectedTokenError{rejectHint, fmt.Errorf("no JWT SVID with hint %q, got hints %q", s.SVIDHint, hints)}
+	}
+	logrus.WithContext(ctx).WithFields(svidFields(svids[0])).Warnf("No JWT SVID with hint %q, falling back to the first one of hints %q", s.SVIDHint, hints)
+	return svids[0], nil
+}
+
+// audienceMatches reports whether an audience of a JWT SVID fetched for the
+// requested audience is acceptable under --audience-validation-mode
+func (s *SpiffeJWT) audienceMatches(aud, requested string) bool {
+	switch s.AudienceValidationMode {
+	case "prefix":
+		return strings.HasPrefix(aud, s.audienceExpected(requested))
+	case "regex":
+		return s.audienceRegexp.MatchString(aud)
+	default:
+		return aud == requested
+	}
+}
+
+// audienceExpected returns the audience, prefix or pattern a JWT SVID fetched
+// for the requested audience is checked against
+func (s *SpiffeJWT) audienceExpected(requested string) string {
+	if s.AudienceValidationMode != "exact" && s.AudiencePattern != "" {
+		return s.AudiencePattern
+	}
+	return requested
+}
+
+// rejectedRetry returns how long to wait before fetching again after the
+// given number of earlier consecutive rejected JWT SVIDs, while the last known good
+// one stays in place. It returns false once the good token expires within
+// --rejected-fatal-before, as there is nothing valid left to serve.
+func (s *SpiffeJWT) rejectedRetry(good *jwtsvid.SVID, rejections int) (time.Duration, bool) {
+	remaining := time.Until(good.Expiry.Add(s.TokenExpirySlack).Add(-s.RejectedFatalBefore))
+	if remaining <= 0 {
+		return 0, false
+	}
+
+	wait := rejectedRetryMin << min(rejections, 4)
+	wait = min(wait, rejectedRetryMax, remaining)
+	return wait, true
+}
+
+// rejected records a JWT SVID that failed validation and classifies the error
+func (s *SpiffeJWT) rejected(err *rejectedTokenError) error {
+	s.stats.recordFailure(failureReject)
+	rejectedTokens.WithLabelValues(err.reason).Inc()
+	s.mu.Lock()
+	s.rejections++
+	s.mu.Unlock()
+	return &classifiedError{class: failureReject, err: fmt.Errorf("rejected JWT: %w", err)}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestValidateJWTSVIDExpired checks that a JWT SVID fetched without remaining
+// lifetime, from a zero TTL or clock skew, is rejected rather than written
+func TestValidateJWTSVIDExpired(t *testing.T) {
+	// The Workload API client tolerates a minute of skew, so tokens expired
+	// within the last minute still reach the validation
+	for _, tc := range []struct {
+		name   string
+		expiry time.Duration
+		slack  time.Duration
+		reason string
+	}{
+		{"valid", time.Hour, 0, ""},
+		{"zero TTL", 0, 0, rejectExpired},
+		{"already expired", -30 * time.Second, 0, rejectExpired},
+		{"expired within the slack", -30 * time.Second, time.Minute, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &SpiffeJWT{
+				AllowedAlgorithms:      []string{"ES256"},
+				AudienceValidationMode: "exact",
+				MaxIssuedAtSkew:        time.Minute,
+				TokenExpirySlack:       tc.slack,
+			}
+			svid := signTestSVID(t, "workload", time.Now().Add(tc.expiry))
+			err := s.validateJWTSVID(svid, "workload")
+			switch {
+			case tc.reason == "" && err != nil:
+				t.Fatalf("validateJWTSVID: %v", err)
+			case tc.reason == "":
+				return
+			case err == nil:
+				t.Fatal("validateJWTSVID accepted a token without remaining lifetime")
+			case err.reason != tc.reason:
+				t.Fatalf("rejected for %s, want %s: %v", err.reason, tc.reason, err)
+			}
+
+			// The fetch counts as failed and is retried with backoff
+			var ce *classifiedError
+			if !errors.As(s.rejected(err), &ce) || ce.class != failureReject {
+				t.Errorf("rejection is not classified as %s", failureReject)
+			}
+		})
+	}
+}
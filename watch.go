@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+// WatchCmd prints a summary of every rotation of a token file. It watches the
+// parent directory so rotations done by renaming a new file over the old one
+// are seen as well as in-place writes.
+type WatchCmd struct {
+	File      string `arg:"" help:"Token file to watch."`
+	JSON      bool   `help:"Print one JSON object per rotation instead of a text line."`
+	NotifyCmd string `help:"Shell command to run on every rotation. The rotation is passed in SPIFFE_JWT_* environment variables."`
+
+	EncryptionKeyFile string `env:"ENCRYPTION_KEY_FILE" help:"File with the hex encoded AES key of an encrypted token file." type:"existingfile"`
+}
+
+// rotation describes a change of the token in the watched file
+type rotation struct {
+	Time      time.Time `json:"time"`
+	Expiry    time.Time `json:"expiry"`
+	Remaining string    `json:"remaining"`
+	Subject   string    `json:"subject"`
+	Audience  []string  `json:"audience"`
+	TTLChange string    `json:"ttl_change"`
+	// Xattrs are the extended attributes set by --xattr, if any
+	Xattrs map[string]string `json:"xattrs,omitempty"`
+}
+
+// Run watches the token file until the process is interrupted
+func (c *WatchCmd) Run() error {
+	path, err := filepath.Abs(c.File)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", c.File, err)
+	}
+
+	var key []byte
+	if c.EncryptionKeyFile != "" {
+		if key, err = loadEncryptionKey(c.EncryptionKeyFile); err != nil {
+			return err
+		}
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create file watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("failed to watch %s: %w", filepath.Dir(path), err)
+	}
+
+	var last string
+	var lastExpiry time.Time
+	check := func() {
+		token, err := readTokenFile(path, key)
+		if err != nil {
+			// The file may be missing or half written, the next event will tell
+			logrus.WithError(err).Debug("unable to read token file")
+			return
+		}
+		if token == last {
+			return
+		}
+		p, err := parseTokenInsecure(token)
+		if err != nil {
+			logrus.WithError(err).Warn("unable to parse token")
+			return
+		}
+
+		r := rotation{
+			Time:      time.Now(),
+			Expiry:    p.expiry(),
+			Remaining: time.Until(p.expiry()).Round(time.Second).String(),
+			Subject:   p.subject(),
+			Audience:  p.audience(),
+			TTLChange: ttlChange(lastExpiry, p.expiry(), last == ""),
+			Xattrs:    readTokenXattrs(path),
+		}
+		last, lastExpiry = token, p.expiry()
+
+		c.print(r)
+		if c.NotifyCmd != "" {
+			c.notify(path, r)
+		}
+	}
+
+	check()
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(event.Name) != path || !event.Has(fsnotify.Create|fsnotify.Write) {
+				continue
+			}
+			check()
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			logrus.WithError(err).Warn("file watcher error")
+		}
+	}
+}
+
+// ttlChange describes how the remaining lifetime changed with a rotation
+func ttlChange(prev, next time.Time, initial bool) string {
+	switch {
+	case initial:
+		return "initial"
+	case next.After(prev):
+		return "lengthened"
+	case next.Before(prev):
+		return "shortened"
+	default:
+		return "unchanged"
+	}
+}
+
+// print writes a rotation to stdout
+func (c *WatchCmd) print(r rotation) {
+	if c.JSON {
+		data, err := json.Marshal(r)
+		if err != nil {
+			logrus.WithError(err).Error("unable to encode rotation")
+			return
+		}
+		fmt.Println(string(data))
+		return
+	}
+	line := fmt.Sprintf("%s expiry=%s remaining=%s sub=%s aud=%s ttl=%s",
+		r.Time.Format(time.RFC3339), r.Expiry.Format(time.RFC3339), r.Remaining,
+		r.Subject, strings.Join(r.Audience, ","), r.TTLChange)
+	for _, name := range []string{xattrExpiry, xattrID} {
+		if value, ok := r.Xattrs[name]; ok {
+			line += fmt.Sprintf(" %s=%s", name, value)
+		}
+	}
+	fmt.Println(line)
+}
+
+// notify runs the notify command for a rotation
+func (c *WatchCmd) notify(path string, r rotation) {
+	cmd := exec.Command("sh", "-c", c.NotifyCmd)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"SPIFFE_JWT_FILE="+path,
+		"SPIFFE_JWT_EXPIRY="+r.Expiry.Format(time.RFC3339),
+		"SPIFFE_JWT_SUBJECT="+r.Subject,
+		"SPIFFE_JWT_AUDIENCE="+strings.Join(r.Audience, ","),
+		"SPIFFE_JWT_TTL_CHANGE="+r.TTLChange,
+	)
+	if err := cmd.Run(); err != nil {
+		logrus.WithError(err).Warn("notify command failed")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// setupWorkloadAPITLS configures TLS on a tcp:// Workload API address, which
+// must otherwise be acknowledged as plaintext with --insecure-workload-api.
+// Unix socket addresses take none of these options.
+func (s *SpiffeJWT) setupWorkloadAPITLS() error {
+	addr := workloadAPIAddr(s.SpiffeAgentSocket)
+	useTLS := s.WorkloadAPICAFile != "" || s.WorkloadAPICertFile != "" || s.WorkloadAPIServerName != ""
+	if !strings.HasPrefix(addr, "tcp://") {
+		if useTLS || s.InsecureWorkloadAPI {
+			return errors.New("--workload-api-ca-file, --workload-api-cert-file, --workload-api-server-name and --insecure-workload-api only apply to tcp:// Workload API addresses")
+		}
+		return nil
+	}
+	if !useTLS {
+		if !s.InsecureWorkloadAPI {
+			return fmt.Errorf("the Workload API at %s would be reached over plaintext TCP, set --workload-api-ca-file or acknowledge it with --insecure-workload-api", addr)
+		}
+		logrus.Warnf("Reaching the Workload API at %s over plaintext TCP", addr)
+		return nil
+	}
+	if s.InsecureWorkloadAPI {
+		return errors.New("--insecure-workload-api cannot be combined with the TLS options of the Workload API")
+	}
+
+	// gRPC servers require HTTP/2 to be negotiated over TLS
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: s.WorkloadAPIServerName,
+		NextProtos: []string{"h2"},
+	}
+	if s.WorkloadAPICAFile != "" {
+		pem, err := os.ReadFile(s.WorkloadAPICAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read --workload-api-ca-file: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in --workload-api-ca-file %s", s.WorkloadAPICAFile)
+		}
+	}
+	if s.WorkloadAPICertFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.WorkloadAPICertFile, s.WorkloadAPIKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load the Workload API client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	s.workloadTLS = config
+	logrus.Infof("Reaching the Workload API at %s over TLS", addr)
+	return nil
+}
+
+// dialWorkloadAPI opens a TLS connection to the Workload API. The client
+// forces insecure transport credentials, so TLS is set up by the dialer and
+// gRPC runs over the established connection.
+func (s *SpiffeJWT) dialWorkloadAPI(ctx context.Context, addr string) (net.Conn, error) {
+	dialer := &tls.Dialer{Config: s.workloadTLS}
+	return dialer.DialContext(ctx, "tcp", addr)
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+)
+
+// Extended attributes describing the token in a token file with --xattr
+const (
+	xattrExpiry = "user.spiffe.expiry"
+	xattrID     = "user.spiffe.id"
+)
+
+// writeOutputFile writes an output file according to --file-write-mode.
+// Files are written in place by default. With atomic-per-dir they are
+// written to .<basename>.tmp.<pid> next to the destination and renamed over
+// it, so that readers never see a partial file and the rename cannot cross
+// filesystems.
+func (s *SpiffeJWT) writeOutputFile(path string, data []byte, perm fs.FileMode) error {
+	return s.writeOutput(path, data, perm, nil)
+}
+
+// writeTokenOutputFile writes an output file holding tokens. On Windows, its
+// ACL is restricted to --token-file-windows-acl before the tokens are
+// written, so they are never readable through an inherited ACL.
+func (s *SpiffeJWT) writeTokenOutputFile(path string, data []byte, perm fs.FileMode) error {
+	return s.writeOutput(path, data, perm, s.tokenFileTrustees)
+}
+
+// writeAtomicTokenOutputFile writes an output file holding tokens through a
+// temporary file renamed over it whatever --file-write-mode, for documents
+// that readers must never see partially written
+func (s *SpiffeJWT) writeAtomicTokenOutputFile(path string, data []byte, perm fs.FileMode) error {
+	if err := writeFileAtomic(path, data, perm, s.tokenFileTrustees); err != nil {
+		return err
+	}
+	return s.finishOutput(path, perm)
+}
+
+// writeOutput writes an output file, restricted to the trustees if any
+func (s *SpiffeJWT) writeOutput(path string, data []byte, perm fs.FileMode, trustees []string) error {
+	if err := s.writeFile(path, data, perm, trustees); err != nil {
+		return err
+	}
+	return s.finishOutput(path, perm)
+}
+
+// finishOutput applies the mode and SELinux label of a written output file
+func (s *SpiffeJWT) finishOutput(path string, perm fs.FileMode) error {
+	// The umask and the mode of an existing file both win over perm otherwise
+	if s.TokenFileModeRefresh {
+		if err := os.Chmod(path, perm); err != nil {
+			return fmt.Errorf("failed to set the mode of %s: %w", path, err)
+		}
+	}
+
+	// Files renamed into place do not inherit the context of the old file
+	if s.FileSELinuxLabel != "" {
+		if err := setSELinuxLabel(path, s.FileSELinuxLabel); err != nil {
+			if s.FileSELinuxRequired {
+				return fmt.Errorf("failed to set SELinux label of %s: %w", path, err)
+			}
+			logrus.WithError(err).Warnf("unable to set SELinux label of %s", path)
+		}
+	}
+	return nil
+}
+
+// setFileACL adds --token-file-acl to the ACL of a token file with setfacl.
+// Files renamed into place do not keep the ACL of the old file, so it is set
+// after every write. A failure is only logged.
+func setFileACL(ctx context.Context, path, acl string) {
+	out, err := exec.Command("setfacl", "-m", acl, path).CombinedOutput()
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).WithField("output", strings.TrimSpace(string(out))).Warnf("unable to set ACL %s on %s", acl, path)
+	}
+}
+
+// tokenHeaderFile is the companion file of --token-include-raw-header
+func tokenHeaderFile(path string) string {
+	return path + ".header.json"
+}
+
+// writeTokenHeader writes the decoded JOSE header of a JWT SVID next to the
+// token file. It is only rewritten when the header changes, so that its
+// mtime records key rotations rather than token refreshes.
+func (s *SpiffeJWT) writeTokenHeader(ctx context.Context, path string, jwt *jwtsvid.SVID) error {
+	seg, _, _ := strings.Cut(jwt.Marshal(), ".")
+	header, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(seg, "="))
+	if err != nil {
+		return fmt.Errorf("invalid JWT header: %w", err)
+	}
+	path = tokenHeaderFile(path)
+	if existing, err := readFileLimited(path, maxReadBackSize); err == nil && bytes.Equal(existing, header) {
+		return nil
+	}
+	if err := s.writeOutputFile(path, header, 0644); err != nil {
+		return fmt.Errorf("failed to write JWT header file: %w", err)
+	}
+	logrus.WithContext(ctx).Infof("JWT header written to %s", path)
+	return nil
+}
+
+// setTokenXattrs tags a token file with the expiry and SPIFFE ID of the token
+// it holds, so that inventories need not open it. It is best effort, and
+// filesystems without extended attributes are skipped quietly.
+func setTokenXattrs(ctx context.Context, path string, jwt *jwtsvid.SVID) {
+	attrs := [][2]string{
+		{xattrExpiry, jwt.Expiry.UTC().Format(time.RFC3339)},
+		{xattrID, jwt.ID.String()},
+	}
+	for _, attr := range attrs {
+		if err := setXattr(path, attr[0], attr[1]); err != nil {
+			if xattrUnsupported(err) {
+				logrus.WithContext(ctx).WithError(err).Debugf("extended attributes not supported on %s", path)
+				return
+			}
+			logrus.WithContext(ctx).WithError(err).Warnf("unable to set extended attribute %s of %s", attr[0], path)
+		}
+	}
+}
+
+// readTokenXattrs returns the extended attributes set by --xattr on a token
+// file, if any
+func readTokenXattrs(path string) map[string]string {
+	attrs := map[string]string{}
+	for _, name := range []string{xattrExpiry, xattrID} {
+		value, err := getXattr(path, name)
+		if err != nil {
+			if !xattrUnsupported(err) && !xattrMissing(err) {
+				logrus.WithError(err).Debugf("unable to read extended attribute %s of %s", name, path)
+			}
+			continue
+		}
+		attrs[name] = value
+	}
+	if len(attrs) == 0 {
+		return nil
+	}
+	return attrs
+}
+
+// writeFile writes an output file in place or atomically
+func (s *SpiffeJWT) writeFile(path string, data []byte, perm fs.FileMode, trustees []string) error {
+	if s.FileWriteMode != "atomic-per-dir" {
+		return writeFileInPlace(path, data, perm, trustees)
+	}
+	return writeFileAtomic(path, data, perm, trustees)
+}
+
+// writeFileInPlace writes a file like os.WriteFile. With trustees, its ACL
+// is restricted to them before the old content is truncated.
+func writeFileInPlace(path string, data []byte, perm fs.FileMode, trustees []string) error {
+	if trustees == nil {
+		return os.WriteFile(path, data, perm)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, perm)
+	if err != nil {
+		return err
+	}
+	err = protectTokenFile(path, trustees)
+	if err == nil {
+		err = f.Truncate(0)
+	}
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// writeFileAtomic writes a file through a temporary file renamed over it.
+// With trustees, the ACL of the temporary file is restricted to them before
+// anything is written. Concurrent writes of the same path must be
+// serialized by the caller, as they share the temporary file.
+func writeFileAtomic(path string, data []byte, perm fs.FileMode, trustees []string) error {
+	// Keep the mode of an existing file, as an in-place write would
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	tmp := tempFileName(path, os.Getpid())
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if trustees != nil {
+		err = protectTokenFile(tmp, trustees)
+	}
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// Persist the rename itself
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// tempFileName returns the temporary file of an atomic write of path
+func tempFileName(path string, pid int) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp."+strconv.Itoa(pid))
+}
+
+// removeStaleTempFiles removes the temporary files a crashed previous run
+// left next to the output files
+func (s *SpiffeJWT) removeStaleTempFiles() {
+	for _, path := range s.outputFiles() {
+		pattern := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp.*")
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			continue
+		}
+		for _, tmp := range matches {
+			pid := tmp[strings.LastIndex(tmp, ".")+1:]
+			if _, err := strconv.Atoi(pid); err != nil || pid == strconv.Itoa(os.Getpid()) {
+				continue
+			}
+			if err := os.Remove(tmp); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				logrus.WithError(err).Warnf("unable to remove stale temporary file %s", tmp)
+				continue
+			}
+			logrus.Infof("Removed stale temporary file %s", tmp)
+		}
+	}
+}
+
+// checkHardenedMounts makes sure the directories of the output files are
+// on filesystems mounted noexec and nosuid, for --require-hardened-mount
+func (s *SpiffeJWT) checkHardenedMounts() error {
+	for _, path := range s.outputFiles() {
+		dir := filepath.Dir(path)
+		noexec, nosuid, err := mountFlags(dir)
+		if errors.Is(err, errors.ErrUnsupported) {
+			return errors.New("--require-hardened-mount is only supported on Linux")
+		}
+		if err != nil {
+			return fmt.Errorf("unable to check the mount of %s: %w", dir, err)
+		}
+		logrus.WithFields(logrus.Fields{"noexec": noexec, "nosuid": nosuid}).Infof("Mount flags of %s", dir)
+		if !noexec || !nosuid {
+			return fmt.Errorf("%s is not on a noexec,nosuid mount, required by --require-hardened-mount", dir)
+		}
+	}
+	return nil
+}
+
+// outputFiles returns the local files written by the daemon
+func (s *SpiffeJWT) outputFiles() []string {
+	var files []string
+	for _, t := range s.Tokens {
+		files = append(files, t.File)
+	}
+	for _, f := range []string{s.JWTFileName, s.JWTBundleFileName, s.SigningKeyJWKFile, s.TokenMapFile} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	if s.TokenIncludeRawHeader && s.JWTFileName != "" {
+		files = append(files, tokenHeaderFile(s.JWTFileName))
+	}
+	return files
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// setXattr sets an extended attribute of a file
+func setXattr(path, name, value string) error {
+	return unix.Setxattr(path, name, []byte(value), 0)
+}
+
+// getXattr reads an extended attribute of a file
+func getXattr(path, name string) (string, error) {
+	buf := make([]byte, 256)
+	for {
+		n, err := unix.Getxattr(path, name, buf)
+		if errors.Is(err, unix.ERANGE) {
+			buf = make([]byte, 2*len(buf))
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return string(buf[:n]), nil
+	}
+}
+
+// xattrUnsupported reports whether err means the filesystem has no extended
+// attributes, or none in the user namespace
+func xattrUnsupported(err error) bool {
+	return errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP)
+}
+
+// xattrMissing reports whether err means the file lacks the attribute
+func xattrMissing(err error) bool {
+	return errors.Is(err, unix.ENODATA)
+}
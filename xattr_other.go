@@ -0,0 +1,25 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// setXattr is not supported outside of Linux
+func setXattr(path, name, value string) error {
+	return errors.ErrUnsupported
+}
+
+// getXattr is not supported outside of Linux
+func getXattr(path, name string) (string, error) {
+	return "", errors.ErrUnsupported
+}
+
+// xattrUnsupported reports whether err means extended attributes are not supported
+func xattrUnsupported(err error) bool {
+	return errors.Is(err, errors.ErrUnsupported)
+}
+
+// xattrMissing reports whether err means the file lacks the attribute
+func xattrMissing(err error) bool {
+	return false
+}